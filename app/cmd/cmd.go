@@ -1,16 +1,25 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"github.com/AndresBott/goback/app/goback"
 	"github.com/AndresBott/goback/app/logger"
 	"github.com/AndresBott/goback/app/metainfo"
 	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/zip"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // Execute is the entry point for the command line
@@ -44,6 +53,12 @@ func newRootCommand() *cobra.Command {
 		generateCmd(),
 		backupCmd(),
 		validateCmd(),
+		sshTrustCmd(),
+		restoreCmd(),
+		verifyCmd(),
+		daemonCmd(),
+		systemdCmd(),
+		statusCmd(),
 	)
 
 	return cmd
@@ -53,15 +68,64 @@ func newRootCommand() *cobra.Command {
 func backupCmd() *cobra.Command {
 
 	loglevel := "info"
+	stdout := false
+	wait := false
+	skipLocked := false
+	digest := ""
+	metricsFile := ""
+	historyFile := ""
+	lockDir := ""
+	report := ""
+	reportFile := ""
+	noProgress := false
 	cmd := cobra.Command{
 		Use:   "backup",
 		Short: "backup a profile or a directory",
-		Long:  `backup a profile or a directory`,
-		Args:  cobra.ExactArgs(1),
+		Long: `backup a profile or a directory
+
+a profile and its destination directory are locked while the profile runs, if another run holds the lock
+the profile fails, unless --wait or --skip-if-locked are set; locks of processes that are gone are ignored.
+
+with --digest a single notification with the results of all the profiles is sent at the end of the run,
+the file has the same fields as the notify section of a profile.
+
+with --metrics-file the result of every profile run is written as Prometheus metrics to a node_exporter
+textfile, the metrics of the profiles not part of the run are kept.
+
+with --report json a structured result of every profile and each of its dirs and dbs is written to stdout,
+or to --report-file; the logs are written to stderr when the report goes to stdout.
+
+the progress of every dir and db is logged every 30 seconds, when stdout is a terminal a live progress bar
+is shown as well, unless --no-progress is set or the report goes to stdout.
+
+with --stdout a local profile is read from stdin and the archive is written to stdout instead of the
+destination, this is used by remote profiles to run goback on the remote host.`,
+		Args: cobra.RangeArgs(0, 1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if stdout {
+				if len(args) != 0 {
+					return fmt.Errorf("--stdout reads the profile from stdin and takes no arguments")
+				}
+				// stdout is used for the archive, log to stderr
+				log, err := logger.GetWithOutput(logger.GetLogLevel(loglevel), os.Stderr)
+				if err != nil {
+					return err
+				}
+				return backupToStdout(log)
+			}
+			if len(args) != 1 {
+				return fmt.Errorf("accepts 1 arg(s), received %d", len(args))
+			}
 			file := args[0]
 
-			log, err := logger.GetDefault(logger.GetLogLevel(loglevel))
+			if report != "" && report != goback.ReportJson {
+				return fmt.Errorf("unsupported report format: %s", report)
+			}
+			logOut := os.Stdout
+			if report != "" && (reportFile == "" || reportFile == "-") {
+				logOut = os.Stderr
+			}
+			log, err := logger.GetWithOutput(logger.GetLogLevel(loglevel), logOut)
 			if err != nil {
 				return err
 			}
@@ -71,15 +135,51 @@ func backupCmd() *cobra.Command {
 				return err
 			}
 
+			goback.MigrateState(log)
+			if historyFile == "" {
+				historyFile = goback.DefaultHistoryFile()
+			}
+			runner := goback.BackupRunner{
+				Logger:  log,
+				History: &goback.History{File: historyFile},
+				LockDir: lockDir,
+			}
+			if !noProgress && logOut == os.Stdout && isatty.IsTerminal(os.Stdout.Fd()) {
+				runner.Progress = os.Stdout
+			}
+			if wait {
+				runner.LockMode = goback.LockWait
+			}
+			if skipLocked {
+				runner.LockMode = goback.LockSkip
+			}
+			if digest != "" {
+				cfg, err := profile.LoadNotify(digest)
+				if err != nil {
+					return err
+				}
+				runner.Digest = &cfg
+			}
+			if metricsFile != "" {
+				runner.Metrics = &goback.Metrics{File: metricsFile, LockDir: lockDir}
+			}
+
 			fstat, err := os.Stat(absPath)
 			if err != nil {
 				return err
 			}
 			if fstat.IsDir() {
-				return backupFromDir(absPath, log)
+				err = backupFromDir(absPath, &runner)
 			} else {
-				return backupFromFile(absPath, log)
+				err = backupFromFile(absPath, &runner)
+			}
+			if report != "" && runner.Results != nil {
+				rErr := writeReport(report, reportFile, runner.Results)
+				if rErr != nil {
+					log.Error("unable to write report", "error", rErr)
+				}
 			}
+			return err
 		},
 	}
 
@@ -89,16 +189,56 @@ func backupCmd() *cobra.Command {
 		command.Parent().HelpFunc()(command, strings)
 	})
 	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+	cmd.Flags().BoolVar(&stdout, "stdout", false, "Read a local profile from stdin and write the archive to stdout")
+	cmd.Flags().BoolVar(&wait, "wait", false, "Wait for other runs of the same profile or destination to finish")
+	cmd.Flags().BoolVar(&skipLocked, "skip-if-locked", false, "Skip profiles locked by another run without failing")
+	cmd.Flags().StringVar(&digest, "digest", digest, "Notify config file used to send one summary of all the profiles")
+	cmd.Flags().StringVar(&metricsFile, "metrics-file", metricsFile, "Write the metrics of the runs to this node_exporter textfile")
+	cmd.Flags().StringVar(&historyFile, "history", historyFile, "Location of the run history, defaults to the user state dir")
+	cmd.Flags().StringVar(&lockDir, "lock-dir", lockDir, "Location of the lock files, defaults to the user cache dir")
+	cmd.Flags().StringVar(&report, "report", report, "Write a report of the run in this format, only json is supported")
+	cmd.Flags().StringVar(&reportFile, "report-file", reportFile, "Write the report into this file instead of stdout")
+	cmd.Flags().BoolVar(&noProgress, "no-progress", false, "Don't show the progress bar when stdout is a terminal")
+	cmd.MarkFlagsMutuallyExclusive("wait", "skip-if-locked")
+	cmd.MarkFlagsMutuallyExclusive("stdout", "report")
 
 	return &cmd
 }
 
-func backupFromFile(absFile string, logger *slog.Logger) error {
-	logger.Info(fmt.Sprintf("using up %s", absFile))
-	runner := goback.BackupRunner{
-		Logger: logger,
+// writeReport writes the report of the results into the file, or to stdout if file is empty or "-"
+func writeReport(format, file string, results []goback.RunResult) error {
+	if file == "" || file == "-" {
+		return goback.WriteReport(os.Stdout, format, results)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("unable to create report file: %v", err)
+	}
+	err = goback.WriteReport(f, format, results)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func backupToStdout(log *slog.Logger) error {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("unable to read profile from stdin: %v", err)
+	}
+	prfl, err := profile.LoadProfileData(data)
+	if err != nil {
+		return err
 	}
 
+	log.Info("writing backup to stdout", "name", prfl.Name)
+	return goback.BackupToWriter(prfl, os.Stdout, log)
+}
+
+func backupFromFile(absFile string, runner *goback.BackupRunner) error {
+	runner.Logger.Info(fmt.Sprintf("using up %s", absFile))
+
 	err := runner.LoadProfileFile(absFile)
 	if err != nil {
 		return err
@@ -112,15 +252,12 @@ func backupFromFile(absFile string, logger *slog.Logger) error {
 	return nil
 }
 
-func backupFromDir(absPath string, logger *slog.Logger) error {
-	logger.Info(fmt.Sprintf("using Dir %s", absPath))
+func backupFromDir(absPath string, runner *goback.BackupRunner) error {
+	runner.Logger.Info(fmt.Sprintf("using Dir %s", absPath))
 	// handle a directory containing profiles
-	runner := goback.BackupRunner{
-		Logger: logger,
-	}
+	// profiles that fail to load are part of the results of Run
 	err := runner.LoadProfilesDir(absPath)
 	if err != nil {
-		// TODO capture the errors but still run the correct profiles
 		return err
 	}
 
@@ -204,6 +341,472 @@ func validateSingle(absPath string, log *slog.Logger) error {
 	return nil
 }
 
+func sshTrustCmd() *cobra.Command {
+	loglevel := "info"
+	assumeYes := false
+	cmd := cobra.Command{
+		Use:   "ssh-trust <profile>",
+		Short: "trust the ssh host keys of a profile",
+		Long: `connect to the ssh host of a profile, and its jump hosts, show the fingerprint of unknown host keys
+and record them in the known_hosts file managed by goback`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logger.GetDefault(logger.GetLogLevel(loglevel))
+			if err != nil {
+				return err
+			}
+
+			absPath, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+
+			prfl, err := profile.LoadProfile(absPath)
+			if err != nil {
+				return err
+			}
+
+			confirm := func(hostname, keyType, fingerprint string) bool {
+				fmt.Printf("The authenticity of host '%s' can't be established.\n", hostname)
+				fmt.Printf("%s key fingerprint is %s.\n", keyType, fingerprint)
+				if assumeYes {
+					return true
+				}
+				fmt.Print("Are you sure you want to trust this host (yes/no)? ")
+				answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				answer = strings.ToLower(strings.TrimSpace(answer))
+				return answer == "yes" || answer == "y"
+			}
+
+			err = goback.TrustProfileHosts(prfl, confirm)
+			if err != nil {
+				return err
+			}
+			log.Info("host keys of profile are trusted", "profile", prfl.Name)
+			return nil
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", assumeYes, "Trust unknown host keys without asking")
+
+	return &cmd
+}
+
+func restoreCmd() *cobra.Command {
+	loglevel := "info"
+	cmd := cobra.Command{
+		Use:   "restore <backup.zip> <destination>",
+		Short: "extract a backup file",
+		Long: `extract a backup file into the destination directory, restoring the mode and modification time
+of files and directories; the owner is restored as well when running as root.
+Split backups are restored by passing either the zip name or its first volume, e.g. backup.zip.001`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logger.GetDefault(logger.GetLogLevel(loglevel))
+			if err != nil {
+				return err
+			}
+
+			dest, err := filepath.Abs(args[1])
+			if err != nil {
+				return err
+			}
+
+			log.Info("restoring backup", "file", args[0], "destination", dest)
+			err = zip.Extract(args[0], dest)
+			if err != nil {
+				return err
+			}
+			log.Info("backup restored", "destination", dest)
+			return nil
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+
+	return &cmd
+}
+
+func daemonCmd() *cobra.Command {
+	loglevel := "info"
+	stateFile := ""
+	metricsFile := ""
+	metricsListen := ""
+	historyFile := ""
+	cmd := cobra.Command{
+		Use:   "daemon <dir>",
+		Short: "run the profiles of a directory on their schedule",
+		Long: `run the profiles of a directory on the cron schedule defined in the profile "schedule" field,
+profiles without schedule are ignored. Changes in the directory are picked up automatically.
+
+The time of the last run of every profile is kept in a state file, runs missed while the daemon was not
+running are started right away. A profile is never started while its previous run is still in progress.
+
+With --metrics-listen the Prometheus metrics of the runs are served on /metrics, with --metrics-file they are
+written to a node_exporter textfile.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logger.GetDefault(logger.GetLogLevel(loglevel))
+			if err != nil {
+				return err
+			}
+
+			absPath, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			goback.MigrateState(log)
+			if historyFile == "" {
+				historyFile = goback.DefaultHistoryFile()
+			}
+			d := goback.Daemon{
+				Logger:      log,
+				Dir:         absPath,
+				StateFile:   stateFile,
+				History:     &goback.History{File: historyFile},
+				MetricsAddr: metricsListen,
+			}
+			if metricsFile != "" {
+				d.Metrics = &goback.Metrics{File: metricsFile}
+			}
+			log.Info("starting daemon", "dir", absPath)
+			return d.Run(ctx)
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+	cmd.Flags().StringVar(&stateFile, "state", stateFile, "Location of the state file, defaults to the user state dir")
+	cmd.Flags().StringVar(&metricsFile, "metrics-file", metricsFile, "Write the metrics of the runs to this node_exporter textfile")
+	cmd.Flags().StringVar(&metricsListen, "metrics-listen", metricsListen, "Serve the metrics on this address at /metrics, e.g. :9876")
+	cmd.Flags().StringVar(&historyFile, "history", historyFile, "Location of the run history, defaults to the user state dir")
+
+	return &cmd
+}
+
+func statusCmd() *cobra.Command {
+	loglevel := "info"
+	historyFile := ""
+	cmd := cobra.Command{
+		Use:   "status [dir]",
+		Short: "show the last runs and backups of the profiles",
+		Long: `show the last successful and failed run of every profile recorded in the run history, with a profile
+file or directory the age of the newest backup in the destination is shown as well and profiles with a
+"staleAfter" threshold are flagged as stale if the newest backup is older.
+
+the command fails if a profile is stale or its last run failed.`,
+		Args: cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logger.GetWithOutput(logger.GetLogLevel(loglevel), os.Stderr)
+			if err != nil {
+				return err
+			}
+			goback.MigrateState(log)
+			if historyFile == "" {
+				historyFile = goback.DefaultHistoryFile()
+			}
+			entries, err := goback.ReadHistory(historyFile)
+			if err != nil {
+				return err
+			}
+
+			var profiles []profile.Profile
+			if len(args) == 1 {
+				profiles, err = loadProfiles(args[0], log)
+				if err != nil {
+					return err
+				}
+			}
+
+			statuses := goback.ProfileStatuses(profiles, entries, time.Now())
+			if len(statuses) == 0 {
+				log.Info("no runs recorded", "history", historyFile)
+				return nil
+			}
+			err = goback.WriteStatus(os.Stdout, statuses, time.Now())
+			if err != nil {
+				return err
+			}
+
+			failing := 0
+			for _, s := range statuses {
+				if s.Stale || s.LastFailed {
+					failing++
+				}
+			}
+			if failing > 0 {
+				return fmt.Errorf("%d of %d profiles are stale or failing", failing, len(statuses))
+			}
+			return nil
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+	cmd.Flags().StringVar(&historyFile, "history", historyFile, "Location of the run history, defaults to the user state dir")
+
+	return &cmd
+}
+
+// loadProfiles loads a profile file or the profiles of a directory, invalid profiles of a directory are logged
+func loadProfiles(path string, log *slog.Logger) ([]profile.Profile, error) {
+	fstat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fstat.IsDir() {
+		prfl, err := profile.LoadProfile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []profile.Profile{prfl}, nil
+	}
+	profiles, err := profile.LoadProfiles(path)
+	if err != nil {
+		log.Error("unable to load some profiles", "dir", path, "error", err)
+	}
+	return profiles, nil
+}
+
+func systemdCmd() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "systemd",
+		Short: "manage systemd timers running the profiles of a directory",
+		Long: `generate, install or uninstall a systemd service and timer per profile of a directory, as an alternative
+to the daemon; the timer runs the profile on the cron schedule of its "schedule" field, profiles without
+schedule are skipped.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_ = cmd.Help()
+			return nil
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+
+	cmd.AddCommand(
+		systemdGenerateCmd(),
+		systemdInstallCmd(),
+		systemdUninstallCmd(),
+	)
+	return &cmd
+}
+
+// systemdUnits loads the profiles of dir and returns their units
+func systemdUnits(dir string, opts goback.SystemdOptions, log *slog.Logger) ([]goback.SystemdUnit, error) {
+	absPath, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Binary == "" {
+		opts.Binary, err = os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the goback binary: %v", err)
+		}
+		opts.Binary, err = filepath.EvalSymlinks(opts.Binary)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the goback binary: %v", err)
+		}
+	}
+	if opts.MetricsFile != "" {
+		opts.MetricsFile, err = filepath.Abs(opts.MetricsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return goback.SystemdUnits(absPath, opts, log)
+}
+
+func systemdGenerateCmd() *cobra.Command {
+	loglevel := "info"
+	binary := ""
+	onFailure := ""
+	metricsFile := ""
+	output := ""
+	cmd := cobra.Command{
+		Use:   "generate <dir>",
+		Short: "print the systemd units of the profiles in a directory",
+		Long: `print a service and a timer unit for every profile with a schedule in the directory,
+with --output the units are written into the output directory instead.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logger.GetWithOutput(logger.GetLogLevel(loglevel), os.Stderr)
+			if err != nil {
+				return err
+			}
+
+			opts := goback.SystemdOptions{Binary: binary, OnFailure: onFailure, MetricsFile: metricsFile}
+			units, err := systemdUnits(args[0], opts, log)
+			if err != nil {
+				return err
+			}
+			for _, u := range units {
+				if output == "" {
+					fmt.Println(u.Content)
+					continue
+				}
+				// #nosec G306 -- unit files are world readable
+				err = os.WriteFile(filepath.Join(output, u.Name), []byte(u.Content), 0644)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+	cmd.Flags().StringVar(&binary, "binary", binary, "Path of the goback binary, defaults to the running one")
+	cmd.Flags().StringVar(&onFailure, "on-failure", onFailure, "Unit started when a backup fails, e.g. notify@%n.service")
+	cmd.Flags().StringVar(&metricsFile, "metrics-file", metricsFile, "Write the metrics of the runs to this node_exporter textfile")
+	cmd.Flags().StringVarP(&output, "output", "o", output, "Write the units into this directory instead of stdout")
+
+	return &cmd
+}
+
+func systemdInstallCmd() *cobra.Command {
+	loglevel := "info"
+	binary := ""
+	onFailure := ""
+	metricsFile := ""
+	unitDir := goback.DefaultUnitDir
+	cmd := cobra.Command{
+		Use:   "install <dir>",
+		Short: "install and start the systemd timers of the profiles in a directory",
+		Long: `write a service and a timer unit for every profile with a schedule in the directory into the systemd
+unit directory, reload systemd and enable and start the timers.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logger.GetDefault(logger.GetLogLevel(loglevel))
+			if err != nil {
+				return err
+			}
+
+			opts := goback.SystemdOptions{Binary: binary, OnFailure: onFailure, MetricsFile: metricsFile}
+			units, err := systemdUnits(args[0], opts, log)
+			if err != nil {
+				return err
+			}
+			if len(units) == 0 {
+				log.Warn("no profile with schedule found", "dir", args[0])
+				return nil
+			}
+			return goback.InstallSystemdUnits(units, unitDir, log)
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+	cmd.Flags().StringVar(&binary, "binary", binary, "Path of the goback binary, defaults to the running one")
+	cmd.Flags().StringVar(&onFailure, "on-failure", onFailure, "Unit started when a backup fails, e.g. notify@%n.service")
+	cmd.Flags().StringVar(&metricsFile, "metrics-file", metricsFile, "Write the metrics of the runs to this node_exporter textfile")
+	cmd.Flags().StringVar(&unitDir, "unit-dir", unitDir, "Directory the units are installed into")
+
+	return &cmd
+}
+
+func systemdUninstallCmd() *cobra.Command {
+	loglevel := "info"
+	unitDir := goback.DefaultUnitDir
+	cmd := cobra.Command{
+		Use:   "uninstall <dir>",
+		Short: "stop and remove the systemd timers of the profiles in a directory",
+		Long:  `stop and disable the timers of the profiles in the directory, and delete their units.`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logger.GetDefault(logger.GetLogLevel(loglevel))
+			if err != nil {
+				return err
+			}
+
+			absPath, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			return goback.UninstallSystemdUnits(absPath, unitDir, log)
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+	cmd.Flags().StringVar(&unitDir, "unit-dir", unitDir, "Directory the units are installed into")
+
+	return &cmd
+}
+
+func verifyCmd() *cobra.Command {
+	loglevel := "info"
+	cmd := cobra.Command{
+		Use:   "verify <backup.zip>",
+		Short: "check the integrity of a backup file",
+		Long: `read every file in a backup file and check it against its checksum.
+Split backups are verified by passing either the zip name or its first volume, e.g. backup.zip.001`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logger.GetDefault(logger.GetLogLevel(loglevel))
+			if err != nil {
+				return err
+			}
+
+			log.Info("verifying backup", "file", args[0])
+			err = zip.Verify(args[0])
+			if err != nil {
+				return err
+			}
+			log.Info("backup is valid", "file", args[0])
+			return nil
+		},
+	}
+
+	// hide persistent flag on this command
+	cmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		_ = command.Flags().MarkHidden("pers")
+		command.Parent().HelpFunc()(command, strings)
+	})
+	cmd.Flags().StringVarP(&loglevel, "loglevel", "l", loglevel, "Set the log level")
+
+	return &cmd
+}
+
 func generateCmd() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   "generate",
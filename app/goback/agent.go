@@ -0,0 +1,196 @@
+package goback
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/ssh"
+	"github.com/AndresBott/goback/lib/zip"
+	"github.com/pkg/sftp"
+)
+
+// backupRemoteAgent runs "goback backup --stdout" on the remote host, sends it the dirs and dbs of the profile
+// over stdin and writes the archive streamed back on stdout into dest.
+func backupRemoteAgent(sshC *ssh.Client, prfl profile.Profile, dest string, log *slog.Logger) (err error) {
+	bin, cleanup, err := agentBinary(sshC, prfl.Agent, log)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	data, err := profile.AgentProfile(prfl)
+	if err != nil {
+		return err
+	}
+
+	sess, err := sshC.Session()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = sess.Close()
+	}()
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("unable to set stdout pipe: %v", err)
+	}
+	stderr := bytes.Buffer{}
+	sess.Stderr = &stderr
+
+	cmd := ssh.ShellQuote(bin) + " backup --stdout"
+	log.Info("running goback on the remote host", "bin", bin)
+	err = sshC.StartCmdWithStdin(sess, cmd, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unable to start remote goback: %v", err)
+	}
+
+	file, err := zip.Create(dest, int64(prfl.Destination.SplitSize))
+	if err != nil {
+		return fmt.Errorf("failed to open zip for writing: %s", err)
+	}
+	// discards the temp files unless the archive was committed
+	defer func() {
+		_ = file.Close()
+	}()
+
+	_, err = io.Copy(file, stdout)
+	if err != nil {
+		return fmt.Errorf("failed to receive archive from remote goback: %v", err)
+	}
+
+	err = sess.Wait()
+	if err != nil {
+		return fmt.Errorf("remote goback failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	log.Debug("remote goback output", "output", stderr.String())
+
+	err = file.Commit()
+	if err != nil {
+		return err
+	}
+
+	// make sure the received stream is a complete archive
+	_, err = zip.ListFiles(dest)
+	if err != nil {
+		return fmt.Errorf("received an invalid archive from remote goback: %v", err)
+	}
+	return nil
+}
+
+// agentBinary returns the path of the goback binary to run on the remote host and a function to clean up after the run
+func agentBinary(sshC *ssh.Client, agent profile.Agent, log *slog.Logger) (string, func(), error) {
+	noop := func() {}
+	if agent.Upload {
+		return uploadAgent(sshC, log)
+	}
+	if agent.Path != "" {
+		return agent.Path, noop, nil
+	}
+
+	bin, err := sshC.Which("goback")
+	if err != nil {
+		return "", noop, fmt.Errorf("goback not found on the remote host: %v", err)
+	}
+	return bin, noop, nil
+}
+
+// uploadAgent copies the running goback binary into a temp dir on the remote host,
+// the platform of the remote host needs to match the one of the binary
+func uploadAgent(sshC *ssh.Client, log *slog.Logger) (bin string, cleanup func(), err error) {
+	cleanup = func() {}
+
+	uname, err := remoteOutput(sshC, "uname -sm")
+	if err != nil {
+		return "", cleanup, err
+	}
+	if !platformMatches(uname, runtime.GOOS, runtime.GOARCH) {
+		return "", cleanup, fmt.Errorf("remote platform %s does not match local binary %s/%s", uname, runtime.GOOS, runtime.GOARCH)
+	}
+
+	tmpDir, err := remoteOutput(sshC, "mktemp -d")
+	if err != nil {
+		return "", cleanup, err
+	}
+
+	// upload as the login user, not through sudo
+	sftpc, err := sftp.NewClient(sshC.Connection())
+	if err != nil {
+		return "", cleanup, fmt.Errorf("unable to create sftp client %v", err)
+	}
+	cleanup = func() {
+		rErr := sftpc.RemoveAll(tmpDir)
+		if rErr != nil {
+			log.Warn("unable to remove uploaded goback binary", "dir", tmpDir, "error", rErr)
+		}
+		_ = sftpc.Close()
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("unable to locate goback binary: %v", err)
+	}
+
+	bin = path.Join(tmpDir, "goback")
+	log.Info("uploading goback binary to the remote host", "dest", bin)
+	err = sftpUpload(sftpc, exe, bin)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+
+	// allow a sudo user to run the binary
+	err = errors.Join(sftpc.Chmod(tmpDir, 0711), sftpc.Chmod(bin, 0755))
+	if err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("unable to change mode of uploaded binary: %v", err)
+	}
+	return bin, cleanup, nil
+}
+
+// remoteOutput runs a command on the remote host as the login user and returns its trimmed output
+func remoteOutput(sshC *ssh.Client, cmd string) (string, error) {
+	sess, err := sshC.Session()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = sess.Close()
+	}()
+
+	out, err := sess.CombinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("command '%s' failed: %v", cmd, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// platformMatches checks the output of "uname -sm" against a go OS and architecture
+func platformMatches(uname, goos, goarch string) bool {
+	fields := strings.Fields(uname)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], goos) {
+		return false
+	}
+
+	machines := map[string][]string{
+		"amd64": {"x86_64", "amd64"},
+		"arm64": {"aarch64", "arm64"},
+		"386":   {"i386", "i686"},
+		"arm":   {"armv6l", "armv7l"},
+	}
+	for _, m := range machines[goarch] {
+		if fields[1] == m {
+			return true
+		}
+	}
+	return fields[1] == goarch
+}
@@ -0,0 +1,82 @@
+package goback
+
+import (
+	"archive/zip"
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlatformMatches(t *testing.T) {
+	tcs := []struct {
+		uname  string
+		goos   string
+		goarch string
+		want   bool
+	}{
+		{uname: "Linux x86_64", goos: "linux", goarch: "amd64", want: true},
+		{uname: "Linux aarch64", goos: "linux", goarch: "arm64", want: true},
+		{uname: "Linux armv7l", goos: "linux", goarch: "arm", want: true},
+		{uname: "FreeBSD amd64", goos: "freebsd", goarch: "amd64", want: true},
+		{uname: "Linux aarch64", goos: "linux", goarch: "amd64", want: false},
+		{uname: "Darwin x86_64", goos: "linux", goarch: "amd64", want: false},
+		{uname: "", goos: "linux", goarch: "amd64", want: false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.uname+"-"+tc.goos+"/"+tc.goarch, func(t *testing.T) {
+			got := platformMatches(tc.uname, tc.goos, tc.goarch)
+			if got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBackupToWriter(t *testing.T) {
+	prfl := profile.Profile{
+		Name: "agent",
+		Type: profile.TypeLocal,
+		Dirs: []profile.BackupPath{
+			{Path: "sampledata/files/dir1"},
+		},
+	}
+
+	buf := bytes.Buffer{}
+	err := BackupToWriter(prfl, &buf, logger.SilentLogger())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("stream is not a valid zip: %v", err)
+	}
+	got := []string{}
+	for _, f := range zr.File {
+		got = append(got, f.Name)
+	}
+	sort.Strings(got)
+
+	want := []string{
+		"dir1/",
+		"dir1/file.json",
+		"dir1/subdir1/",
+		"dir1/subdir1/subfile.log",
+		"dir1/subdir1/subfile1.txt",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("reject remote profiles", func(t *testing.T) {
+		err := BackupToWriter(profile.Profile{Name: "remote", Type: profile.TypeRemote}, &bytes.Buffer{}, logger.SilentLogger())
+		if err == nil {
+			t.Fatal("expected an error but got none")
+		}
+	})
+}
@@ -0,0 +1,379 @@
+package goback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/cron"
+)
+
+// defaultReloadInterval is the interval the daemon checks the profile directory for changes
+const defaultReloadInterval = 30 * time.Second
+
+// Daemon runs the profiles of a directory on the cron schedule defined in every profile,
+// profiles without schedule are ignored
+type Daemon struct {
+	Logger *slog.Logger
+	Dir    string
+	// StateFile keeps the last run of every profile, to catch up on runs missed while the daemon was not running
+	StateFile string
+	// LockDir is the location of the profile lock files, DefaultLockDir is used if empty
+	LockDir string
+	// ReloadInterval is the interval the directory is checked for changed profiles
+	ReloadInterval time.Duration
+	// History keeps a record of every run, not recorded if nil
+	History *History
+	// Metrics records the result of every run, not recorded if nil
+	Metrics *Metrics
+	// MetricsAddr is the address the metrics are served on at /metrics, e.g. ":9876"; not served if empty
+	MetricsAddr string
+
+	// exposed internally for testing purposes only
+	now   func() time.Time
+	runFn func(profile.Profile) error
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	state daemonState
+	wg    sync.WaitGroup
+}
+
+// job is a scheduled profile
+type job struct {
+	prfl     profile.Profile
+	schedule *cron.Schedule
+	next     time.Time
+	running  bool
+}
+
+// daemonState is persisted in the state file
+type daemonState struct {
+	LastRun map[string]time.Time `json:"lastRun"`
+}
+
+// DefaultStateFile returns the location of the daemon state file in the user state dir
+func DefaultStateFile() string {
+	return stateFile("daemon-state.json")
+}
+
+// Run schedules the profiles until the context is cancelled, it waits for running profiles before returning
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.Dir == "" {
+		return errors.New("profile directory cannot be empty")
+	}
+	if d.ReloadInterval <= 0 {
+		d.ReloadInterval = defaultReloadInterval
+	}
+	if d.StateFile == "" {
+		d.StateFile = DefaultStateFile()
+	}
+	if d.MetricsAddr != "" && d.Metrics == nil {
+		d.Metrics = &Metrics{}
+	}
+	d.init()
+
+	err := d.loadState()
+	if err != nil {
+		d.Logger.Warn("unable to load daemon state, missed runs are not detected", "file", d.StateFile, "error", err)
+	}
+
+	fingerprint, err := dirFingerprint(d.Dir)
+	if err != nil {
+		return err
+	}
+
+	if d.Metrics != nil && d.Metrics.File != "" {
+		err = d.Metrics.Load()
+		if err != nil {
+			d.Logger.Warn("unable to load metrics", "error", err)
+		}
+	}
+	if d.MetricsAddr != "" {
+		stopServer, err := d.serveMetrics()
+		if err != nil {
+			return err
+		}
+		defer stopServer()
+	}
+
+	d.reload()
+
+	for {
+		timer := time.NewTimer(d.nextWake().Sub(d.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			d.Logger.Info("stopping daemon, waiting for running profiles")
+			d.wg.Wait()
+			return nil
+		case <-timer.C:
+		}
+
+		fp, err := dirFingerprint(d.Dir)
+		if err != nil {
+			d.Logger.Error("unable to check profile directory", "dir", d.Dir, "error", err)
+		} else if fp != fingerprint {
+			d.Logger.Info("profile directory changed, reloading profiles", "dir", d.Dir)
+			fingerprint = fp
+			d.reload()
+		}
+		d.runDue()
+	}
+}
+
+// init sets the defaults of the fields used for testing
+func (d *Daemon) init() {
+	if d.now == nil {
+		d.now = time.Now
+	}
+	if d.runFn == nil {
+		runner := BackupRunner{
+			Logger: d.Logger,
+			// profiles sharing a destination wait for each other, the same profile never runs twice
+			// as the daemon skips it while it is running
+			LockMode: LockWait,
+			LockDir:  d.LockDir,
+			History:  d.History,
+			Metrics:  d.Metrics,
+		}
+		d.runFn = runner.RunProfile
+	}
+	if d.jobs == nil {
+		d.jobs = map[string]*job{}
+	}
+	if d.state.LastRun == nil {
+		d.state.LastRun = map[string]time.Time{}
+	}
+}
+
+// serveMetrics starts the http server of the metrics, the returned function shuts it down
+func (d *Daemon) serveMetrics() (func(), error) {
+	ln, err := net.Listen("tcp", d.MetricsAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serve metrics: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", d.Metrics)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			d.Logger.Error("metrics server failed", "error", err)
+		}
+	}()
+	d.Logger.Info("serving metrics", "addr", ln.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
+
+// reload loads the profiles of the directory, jobs of unchanged profiles keep their next run
+func (d *Daemon) reload() {
+	profiles, err := profile.LoadProfiles(d.Dir)
+	if err != nil {
+		// the valid profiles are still scheduled
+		d.Logger.Error("unable to load some profiles", "dir", d.Dir, "error", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	jobs := map[string]*job{}
+	for _, prfl := range profiles {
+		if prfl.Schedule == "" {
+			d.Logger.Debug("profile has no schedule", "profile", prfl.Name)
+			continue
+		}
+		if _, ok := jobs[prfl.Name]; ok {
+			d.Logger.Warn("duplicated profile name, only the first one is scheduled", "profile", prfl.Name)
+			continue
+		}
+		sched, err := cron.Parse(prfl.Schedule)
+		if err != nil {
+			d.Logger.Error("invalid profile schedule", "profile", prfl.Name, "error", err)
+			continue
+		}
+
+		// an existing job is kept so that a running profile is still recognised as running
+		j, ok := d.jobs[prfl.Name]
+		if !ok {
+			j = &job{}
+		}
+		if !ok || j.prfl.Schedule != prfl.Schedule {
+			j.schedule = sched
+			j.next = d.firstRun(prfl.Name, sched)
+		}
+		j.prfl = prfl
+		if j.next.IsZero() {
+			d.Logger.Warn("profile schedule never matches", "profile", prfl.Name, "schedule", prfl.Schedule)
+		} else {
+			d.Logger.Info("profile scheduled", "profile", prfl.Name, "schedule", prfl.Schedule, "next", j.next)
+		}
+		jobs[prfl.Name] = j
+	}
+	d.jobs = jobs
+}
+
+// firstRun returns the next run of a newly loaded profile, if a run was missed since the last
+// recorded one, e.g. because the host was down, the profile is run right away
+func (d *Daemon) firstRun(name string, sched *cron.Schedule) time.Time {
+	now := d.now()
+	if last, ok := d.state.LastRun[name]; ok {
+		missed := sched.Next(last)
+		if !missed.IsZero() && !missed.After(now) {
+			d.Logger.Info("catching up on missed run", "profile", name, "missed", missed)
+			return now
+		}
+	}
+	return sched.Next(now)
+}
+
+// nextWake returns the time of the next due job, or the next directory check if that comes first
+func (d *Daemon) nextWake() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	wake := d.now().Add(d.ReloadInterval)
+	for _, j := range d.jobs {
+		if !j.next.IsZero() && j.next.Before(wake) {
+			wake = j.next
+		}
+	}
+	return wake
+}
+
+// runDue starts the jobs whose next run is due, a job that is still running is skipped
+func (d *Daemon) runDue() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	names := make([]string, 0, len(d.jobs))
+	for name := range d.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	started := false
+	for _, name := range names {
+		j := d.jobs[name]
+		if j.next.IsZero() || j.next.After(now) {
+			continue
+		}
+		j.next = j.schedule.Next(now)
+
+		if j.running {
+			d.Logger.Warn("skipping scheduled run, the previous run is still in progress", "profile", name)
+			continue
+		}
+		j.running = true
+		d.state.LastRun[name] = now
+		started = true
+
+		d.wg.Add(1)
+		go d.run(j, j.prfl)
+	}
+
+	if started {
+		err := d.saveState()
+		if err != nil {
+			d.Logger.Warn("unable to save daemon state", "file", d.StateFile, "error", err)
+		}
+	}
+}
+
+func (d *Daemon) run(j *job, prfl profile.Profile) {
+	defer d.wg.Done()
+
+	d.Logger.Info("running scheduled profile", "profile", prfl.Name)
+	err := d.runFn(prfl)
+	if err != nil {
+		d.Logger.Error("scheduled profile failed", "profile", prfl.Name, "error", err)
+	}
+
+	d.mu.Lock()
+	j.running = false
+	next := j.next
+	d.mu.Unlock()
+	d.Logger.Info("scheduled profile finished", "profile", prfl.Name, "next", next)
+}
+
+func (d *Daemon) loadState() error {
+	// #nosec G304 -- the state file is defined by the user
+	data, err := os.ReadFile(d.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := daemonState{}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("invalid state file: %v", err)
+	}
+	for name, t := range state.LastRun {
+		d.state.LastRun[name] = t
+	}
+	return nil
+}
+
+// saveState writes the state into a temp file that is renamed, so that the state file is never incomplete
+func (d *Daemon) saveState() error {
+	data, err := json.MarshalIndent(d.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(d.StateFile), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := d.StateFile + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.StateFile)
+}
+
+// dirFingerprint summarises the name, size and modification time of the profiles in a directory,
+// it changes whenever a profile is added, removed or modified
+func dirFingerprint(dir string) (string, error) {
+	var sb strings.Builder
+	err := filepath.WalkDir(dir, func(path string, e os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() || !strings.HasSuffix(e.Name(), profile.ProfileExt) {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(&sb, "%s:%d:%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to read profile directory: %v", err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,192 @@
+package goback
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDaemon(t *testing.T) {
+	writeProfile := func(t *testing.T, dir, name, schedule string) {
+		data := "version: 1\nname: " + name + "\ntype: local\n"
+		if schedule != "" {
+			data += "schedule: \"" + schedule + "\"\n"
+		}
+		data += "dirs:\n  - path: /tmp\ndestination:\n  path: /tmp\n"
+		err := os.WriteFile(filepath.Join(dir, name+profile.ProfileExt), []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustTime := func(in string) time.Time {
+		tt, err := time.ParseInLocation("2006-01-02 15:04", in, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tt
+	}
+
+	type fakeDaemon struct {
+		*Daemon
+		now  time.Time
+		mu   sync.Mutex
+		runs []string
+		// block holds the runs until closed
+		block chan struct{}
+	}
+
+	setup := func(t *testing.T, now string) *fakeDaemon {
+		dir := t.TempDir()
+		writeProfile(t, dir, "daily", "30 2 * * *")
+		writeProfile(t, dir, "manual", "")
+
+		fd := &fakeDaemon{now: mustTime(now), block: make(chan struct{})}
+		close(fd.block)
+		fd.Daemon = &Daemon{
+			Logger:         logger.SilentLogger(),
+			Dir:            dir,
+			StateFile:      filepath.Join(t.TempDir(), "state.json"),
+			ReloadInterval: time.Minute,
+			now: func() time.Time {
+				return fd.now
+			},
+			runFn: func(prfl profile.Profile) error {
+				fd.mu.Lock()
+				fd.runs = append(fd.runs, prfl.Name)
+				block := fd.block
+				fd.mu.Unlock()
+				<-block
+				return nil
+			},
+		}
+		fd.init()
+		return fd
+	}
+
+	t.Run("only profiles with schedule run when due", func(t *testing.T) {
+		d := setup(t, "2024-03-10 01:00")
+		d.reload()
+
+		if len(d.jobs) != 1 || d.jobs["daily"] == nil {
+			t.Fatalf("expected only the daily profile to be scheduled, got %v", d.jobs)
+		}
+		if got, want := d.jobs["daily"].next, mustTime("2024-03-10 02:30"); !got.Equal(want) {
+			t.Errorf("got next run %s, want %s", got, want)
+		}
+		if got, want := d.nextWake(), mustTime("2024-03-10 01:01"); !got.Equal(want) {
+			t.Errorf("got next wake %s, want %s", got, want)
+		}
+
+		d.runDue()
+		d.wg.Wait()
+		if len(d.runs) != 0 {
+			t.Errorf("expected no runs before the scheduled time, got %v", d.runs)
+		}
+
+		d.now = mustTime("2024-03-10 02:30")
+		d.runDue()
+		d.wg.Wait()
+		if diff := cmp.Diff([]string{"daily"}, d.runs); diff != "" {
+			t.Errorf("runs mismatch (-want +got):\n%s", diff)
+		}
+		if got, want := d.jobs["daily"].next, mustTime("2024-03-11 02:30"); !got.Equal(want) {
+			t.Errorf("got next run %s, want %s", got, want)
+		}
+	})
+
+	t.Run("missed run is started right away", func(t *testing.T) {
+		d := setup(t, "2024-03-10 01:00")
+		d.state.LastRun["daily"] = mustTime("2024-03-08 02:30")
+		if err := d.saveState(); err != nil {
+			t.Fatal(err)
+		}
+
+		// a new daemon reads the state file
+		d2 := setup(t, "2024-03-10 01:00")
+		d2.StateFile = d.StateFile
+		if err := d2.loadState(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d2.reload()
+		d2.runDue()
+		d2.wg.Wait()
+		if diff := cmp.Diff([]string{"daily"}, d2.runs); diff != "" {
+			t.Errorf("runs mismatch (-want +got):\n%s", diff)
+		}
+		if got, want := d2.jobs["daily"].next, mustTime("2024-03-10 02:30"); !got.Equal(want) {
+			t.Errorf("got next run %s, want %s", got, want)
+		}
+	})
+
+	t.Run("no catch up if the last run is recent", func(t *testing.T) {
+		d := setup(t, "2024-03-10 01:00")
+		d.state.LastRun["daily"] = mustTime("2024-03-09 02:30")
+		d.reload()
+		d.runDue()
+		d.wg.Wait()
+		if len(d.runs) != 0 {
+			t.Errorf("expected no runs, got %v", d.runs)
+		}
+	})
+
+	t.Run("a running profile is not started again", func(t *testing.T) {
+		d := setup(t, "2024-03-10 02:30")
+		d.block = make(chan struct{})
+		d.reload()
+		d.jobs["daily"].next = d.now
+
+		d.runDue()
+		// the directory changes while the profile runs
+		writeProfile(t, d.Dir, "daily", "30 2 * * *")
+		d.reload()
+		d.now = mustTime("2024-03-11 02:30")
+		d.runDue()
+
+		close(d.block)
+		d.wg.Wait()
+		if diff := cmp.Diff([]string{"daily"}, d.runs); diff != "" {
+			t.Errorf("runs mismatch (-want +got):\n%s", diff)
+		}
+		if d.jobs["daily"].running {
+			t.Errorf("expected the job to not be running anymore")
+		}
+	})
+
+	t.Run("changed profiles are detected", func(t *testing.T) {
+		d := setup(t, "2024-03-10 01:00")
+		before, err := dirFingerprint(d.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeProfile(t, d.Dir, "hourly", "@hourly")
+		after, err := dirFingerprint(d.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before == after {
+			t.Fatalf("expected the fingerprint to change")
+		}
+
+		d.reload()
+		if got, want := d.jobs["hourly"].next, mustTime("2024-03-10 02:00"); !got.Equal(want) {
+			t.Errorf("got next run %s, want %s", got, want)
+		}
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		d := setup(t, "2024-03-10 01:00")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := d.Run(ctx); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
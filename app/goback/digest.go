@@ -0,0 +1,178 @@
+package goback
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AndresBott/goback/internal/profile"
+)
+
+const defaultDigestSubject = `Goback Digest: {{if .Success}}all {{.Total}} profiles succeeded{{else}}{{.Failed}} of {{.Total}} profiles failed{{end}}`
+
+const defaultDigestBody = `{{if .Success}}✅ All backups completed successfully.{{else}}❌ {{.Failed}} of {{.Total}} backups failed.{{end}}
+
+Host: {{.Host}}
+Time: {{.Time.Format "Mon, 02 Jan 2006 15:04:05 MST"}}
+Duration: {{.Duration}}
+
+{{.Table}}
+{{- range .Profiles}}
+{{- if not .Success}}
+
+{{.Profile}} failed:
+{{- range .Errors}}
+  - {{.}}
+{{- end}}
+{{- end}}
+{{- end}}
+`
+
+const defaultDigestHtmlBody = `<html><body>
+<h3>{{if .Success}}✅ All backups completed successfully.{{else}}❌ {{.Failed}} of {{.Total}} backups failed.{{end}}</h3>
+<p>Host: {{.Host}}<br>Time: {{.Time.Format "Mon, 02 Jan 2006 15:04:05 MST"}}<br>Duration: {{.Duration}}</p>
+<table border="1" cellpadding="4" style="border-collapse: collapse">
+<tr><th>Profile</th><th>Status</th><th>Duration</th><th>Size</th></tr>
+{{- range .Profiles}}
+<tr><td>{{.Profile}}</td><td>{{.Status}}</td><td>{{.Duration}}</td><td>{{if .Archive}}{{.Size}}{{else}}-{{end}}</td></tr>
+{{- end}}
+</table>
+{{- range .Profiles}}
+{{- if not .Success}}
+<h4>{{.Profile}} failed</h4>
+<ul>
+{{- range .Errors}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- end}}
+</body></html>
+`
+
+var digestTemplates = emailTemplates{subject: defaultDigestSubject, body: defaultDigestBody, html: defaultDigestHtmlBody}
+
+// digestData is the data available in the digest email templates
+type digestData struct {
+	Host     string
+	Time     time.Time
+	Success  bool
+	Total    int
+	Failed   int
+	Skipped  int
+	Duration time.Duration
+	// Profiles holds the result of every profile, with the same fields as the profile email templates
+	Profiles []emailData
+	// Table lists the status, duration and archive size of every profile as aligned plain text
+	Table string
+}
+
+func newDigestData(results []RunResult) digestData {
+	host, _ := os.Hostname()
+	d := digestData{
+		Host:  host,
+		Time:  time.Now(),
+		Total: len(results),
+	}
+
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PROFILE\tSTATUS\tDURATION\tSIZE")
+	var total time.Duration
+	for _, res := range results {
+		p := newEmailData(res)
+		d.Profiles = append(d.Profiles, p)
+		total += res.Duration
+
+		switch {
+		case res.Err != nil:
+			d.Failed++
+		case res.Skipped:
+			d.Skipped++
+		}
+
+		size := "-"
+		if p.Archive != "" {
+			size = p.Size.String()
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Profile, p.Status, p.Duration, size)
+	}
+	_ = tw.Flush()
+
+	d.Success = d.Failed == 0
+	d.Duration = roundDuration(total)
+	d.Table = strings.TrimRight(sb.String(), "\n")
+	return d
+}
+
+// digestPayload is the JSON document posted to webhooks by the digest
+type digestPayload struct {
+	Status   string           `json:"status"`
+	Host     string           `json:"host"`
+	Total    int              `json:"total"`
+	Failed   int              `json:"failed"`
+	Skipped  int              `json:"skipped"`
+	Profiles []webhookPayload `json:"profiles"`
+
+	Title   string `json:"title"`
+	Text    string `json:"text"`
+	Content string `json:"content"`
+	Message string `json:"message"`
+}
+
+func newDigestPayload(d digestData, results []RunResult) digestPayload {
+	p := digestPayload{
+		Status:  "success",
+		Host:    d.Host,
+		Total:   d.Total,
+		Failed:  d.Failed,
+		Skipped: d.Skipped,
+		Title:   fmt.Sprintf("goback: all %d profiles on %s succeeded", d.Total, d.Host),
+	}
+	if !d.Success {
+		p.Status = "failure"
+		p.Title = fmt.Sprintf("goback: %d of %d profiles on %s failed", d.Failed, d.Total, d.Host)
+	}
+	for _, res := range results {
+		rp := newWebhookPayload(res)
+		// the summary is only sent once for the digest
+		rp.Title, rp.Text, rp.Content, rp.Message = "", "", "", ""
+		p.Profiles = append(p.Profiles, rp)
+	}
+	p.Text = p.Title + "\n```\n" + d.Table + "\n```"
+	p.Content = p.Text
+	p.Message = p.Text
+	return p
+}
+
+// NotifyDigest sends one notification with the results of several profiles, it is sent if any
+// profile failed, or always if onSuccess is set
+func NotifyDigest(cfg profile.Notify, results []RunResult, log *slog.Logger) {
+	if len(results) == 0 {
+		return
+	}
+	data := newDigestData(results)
+
+	if cfg.HasValues() && (!data.Success || cfg.OnSuccess) {
+		msg, err := emailMessage(cfg.EmailNotify, digestTemplates, data, data.Time)
+		if err == nil {
+			err = send(cfg.EmailNotify, msg)
+		}
+		if err != nil {
+			log.Error("Error while sending digest notification", "err", err)
+		}
+	}
+
+	for _, hook := range cfg.Webhooks {
+		if data.Success && !hook.OnSuccess {
+			continue
+		}
+		err := postJSON(hook, newDigestPayload(data, results))
+		if err != nil {
+			log.Error("Error while sending digest webhook", "err", err)
+		}
+	}
+}
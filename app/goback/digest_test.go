@@ -0,0 +1,158 @@
+package goback
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func digestResults() []RunResult {
+	start := time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC)
+	return []RunResult{
+		{Profile: "web", Start: start, Duration: 90 * time.Second, Archive: "/backups/web.zip", Size: 3 << 20, Files: 10},
+		{Profile: "db", Start: start, Duration: 2 * time.Second, Err: errors.Join(errors.New("dump failed"), errors.New("disk full"))},
+		{Profile: "mail", Start: start, Skipped: true},
+	}
+}
+
+func TestNewDigestData(t *testing.T) {
+	d := newDigestData(digestResults())
+
+	if d.Success || d.Total != 3 || d.Failed != 1 || d.Skipped != 1 {
+		t.Errorf("unexpected counters: success %t, total %d, failed %d, skipped %d", d.Success, d.Total, d.Failed, d.Skipped)
+	}
+	if d.Duration != 92*time.Second {
+		t.Errorf("unexpected duration %s", d.Duration)
+	}
+	want := `PROFILE  STATUS   DURATION  SIZE
+web      success  1m30s     3.0 MiB
+db       failure  2s        -
+mail     skipped  0s        -`
+	if diff := cmp.Diff(want, d.Table); diff != "" {
+		t.Errorf("table mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNotifyDigest(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+
+	t.Run("send failures", func(t *testing.T) {
+		srv := newSmtpServer(t, nil)
+		ws := newWebhookServer(t)
+		cfg := profile.Notify{
+			EmailNotify: profile.EmailNotify{Host: srv.host, Port: srv.port, From: "goback@mail.com", To: []string{"a@mail.com"}},
+			Webhooks:    []profile.Webhook{{Url: ws.URL}},
+		}
+		NotifyDigest(cfg, digestResults(), logger.SilentLogger())
+
+		if len(srv.data) != 1 {
+			t.Fatalf("expected one email, got %d", len(srv.data))
+		}
+		header, parts := readBody(t, []byte(srv.data[0]))
+		if got := header.Get("Subject"); got != "Goback Digest: 1 of 3 profiles failed" {
+			t.Errorf("unexpected subject %s", got)
+		}
+		body := parts["text/plain"]
+		for _, want := range []string{"❌ 1 of 3 backups failed.", "web      success  1m30s     3.0 MiB", "db failed:\n  - dump failed\n  - disk full"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected body to contain %q, got:\n%s", want, body)
+			}
+		}
+		if strings.Contains(body, "web failed") || strings.Contains(body, "mail failed") {
+			t.Errorf("only failures should be expanded, got:\n%s", body)
+		}
+
+		if len(ws.bodies) != 1 {
+			t.Fatalf("expected one webhook request, got %d", len(ws.bodies))
+		}
+		got := digestPayload{}
+		err := json.Unmarshal(ws.bodies[0], &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != "failure" || got.Failed != 1 || len(got.Profiles) != 3 || got.Profiles[1].Error != "dump failed\ndisk full" {
+			t.Errorf("unexpected payload: %+v", got)
+		}
+	})
+
+	t.Run("nothing is sent if all succeeded", func(t *testing.T) {
+		srv := newSmtpServer(t, nil)
+		ws := newWebhookServer(t)
+		cfg := profile.Notify{
+			EmailNotify: profile.EmailNotify{Host: srv.host, Port: srv.port, From: "goback@mail.com", To: []string{"a@mail.com"}},
+			Webhooks:    []profile.Webhook{{Url: ws.URL}},
+		}
+		NotifyDigest(cfg, digestResults()[:1], logger.SilentLogger())
+		if len(srv.data) != 0 || len(ws.bodies) != 0 {
+			t.Errorf("expected no notifications, got %d emails and %d webhooks", len(srv.data), len(ws.bodies))
+		}
+	})
+}
+
+func TestRunDigest(t *testing.T) {
+	ws := newWebhookServer(t)
+	historyFile := filepath.Join(t.TempDir(), "history.jsonl")
+	br := BackupRunner{
+		Logger:  logger.SilentLogger(),
+		LockDir: t.TempDir(),
+		Digest:  &profile.Notify{Webhooks: []profile.Webhook{{Url: ws.URL}}},
+		History: &History{File: historyFile},
+		profiles: []profile.Profile{
+			{
+				Name:        "ok",
+				Type:        profile.TypeLocal,
+				Dirs:        []profile.BackupPath{{Path: "sampledata/files/dir1"}},
+				Destination: profile.Destination{Path: filepath.Join(t.TempDir(), "ok")},
+			},
+			{
+				Name:        "broken",
+				Type:        profile.TypeLocal,
+				Dirs:        []profile.BackupPath{{Path: "sampledata/files/missing"}},
+				Destination: profile.Destination{Path: filepath.Join(t.TempDir(), "broken")},
+			},
+		},
+	}
+
+	err := br.Run()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if len(ws.bodies) != 1 {
+		t.Fatalf("expected one digest, got %d", len(ws.bodies))
+	}
+	got := digestPayload{}
+	err = json.Unmarshal(ws.bodies[0], &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, p := range got.Profiles {
+		statuses = append(statuses, p.Profile+":"+p.Status)
+	}
+	if diff := cmp.Diff([]string{"ok:success", "broken:failure"}, statuses); diff != "" {
+		t.Errorf("statuses mismatch (-want +got):\n%s", diff)
+	}
+	if got.Profiles[0].Archive == "" || got.Profiles[0].ArchiveSize == 0 {
+		t.Errorf("expected the archive of the successful profile, got %+v", got.Profiles[0])
+	}
+
+	entries, err := ReadHistory(historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []string
+	for _, e := range entries {
+		recorded = append(recorded, e.Profile+":"+e.Status)
+	}
+	if diff := cmp.Diff([]string{"ok:success", "broken:failure"}, recorded); diff != "" {
+		t.Errorf("history mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -10,23 +10,42 @@ import (
 	"strings"
 	"time"
 
+	"github.com/AndresBott/goback/lib/zip"
 	"github.com/gobwas/glob"
 )
 
 // ExpurgeDir deletes all the older backups keeping N older versions of a specific backup profile name
 func ExpurgeDir(path string, keepN int, name string, log *slog.Logger) error {
 
+	filesToDelete, err := listExpurge(path, keepN, name)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range filesToDelete {
+		log.Info("Deleting old backup", "file", file)
+		e := os.Remove(filepath.Join(path, file))
+		if e != nil {
+			return fmt.Errorf("unable to delete old zip file: %v", e)
+		}
+	}
+	return nil
+}
+
+// listExpurge returns the backup files in path that ExpurgeDir would delete
+func listExpurge(path string, keepN int, name string) ([]string, error) {
+
 	pathInfo, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("error getting stat of path: %v", err)
+		return nil, fmt.Errorf("error getting stat of path: %v", err)
 	}
 	if !pathInfo.IsDir() {
-		return fmt.Errorf("path is not a directory")
+		return nil, fmt.Errorf("path is not a directory")
 	}
 
-	files, err := filepath.Glob(path + "/*.zip")
+	files, err := filepath.Glob(path + "/*.zip*")
 	if err != nil {
-		return fmt.Errorf("error listing files in path: %v", err)
+		return nil, fmt.Errorf("error listing files in path: %v", err)
 	}
 
 	fileNames := []string{}
@@ -36,21 +55,9 @@ func ExpurgeDir(path string, keepN int, name string, log *slog.Logger) error {
 
 	filesToDelete, err := findToDelete(fileNames, name, keepN)
 	if err != nil {
-		return fmt.Errorf("error parsing files to delete: %v", err)
-	}
-
-	if len(filesToDelete) == 0 {
-		return nil
-	}
-
-	for _, file := range filesToDelete {
-		log.Info("Deleting old backup", "file", file)
-		e := os.Remove(filepath.Join(path, file))
-		if e != nil {
-			return fmt.Errorf("unable to delete old zip file: %v", e)
-		}
+		return nil, fmt.Errorf("error parsing files to delete: %v", err)
 	}
-	return nil
+	return filesToDelete, nil
 }
 
 func findToDelete(files []string, profileName string, n int) ([]string, error) {
@@ -64,14 +71,21 @@ func findToDelete(files []string, profileName string, n int) ([]string, error) {
 		return nil, errors.New("profile name cannot be empty")
 	}
 
-	// this glob patter matches any file with the pattern: name_2006_02_01-15:04:05_backup.zip
-	pattern := profileName + "_[0-9][0-9][0-9][0-9]_[0-9][0-9]_[0-9][0-9]-[0-9][0-9]:[0-9][0-9]:[0-9][0-9]_backup.zip"
-	g, _ := glob.Compile(pattern)
+	g, err := backupGlob(profileName)
+	if err != nil {
+		return nil, err
+	}
 
+	// the volumes of a split backup are grouped by their zip name
+	volumes := map[string][]string{}
 	found := []string{}
 	for _, f := range files {
 		if g.Match(f) {
-			found = append(found, f)
+			base := zip.VolumeBase(f)
+			if _, ok := volumes[base]; !ok {
+				found = append(found, base)
+			}
+			volumes[base] = append(volumes[base], f)
 		}
 	}
 
@@ -92,7 +106,53 @@ func findToDelete(files []string, profileName string, n int) ([]string, error) {
 	}
 
 	// drop the N newest (bottom of list) items from the list ( to not be deleted )
-	return found[0 : len(found)-n], nil
+	toDelete := []string{}
+	for _, base := range found[0 : len(found)-n] {
+		sort.Strings(volumes[base])
+		toDelete = append(toDelete, volumes[base]...)
+	}
+	return toDelete, nil
+}
+
+// backupGlob returns a glob that matches the backup files of a profile name, including the volumes of split backups:
+// name_2006_02_01-15:04:05_backup.zip and name_2006_02_01-15:04:05_backup.zip.001
+func backupGlob(name string) (glob.Glob, error) {
+	g, err := glob.Compile(backupPattern(name))
+	if err != nil {
+		return nil, fmt.Errorf("glop pattern for '%s' does not compile: %v", name, err)
+	}
+	return g, nil
+}
+
+func backupPattern(name string) string {
+	return name + "_[0-9][0-9][0-9][0-9]_[0-9][0-9]_[0-9][0-9]-[0-9][0-9]:[0-9][0-9]:[0-9][0-9]_backup.zip{,.[0-9][0-9][0-9]}"
+}
+
+// cleanStaleTmp deletes the hidden temp files left behind by interrupted backups of a profile name
+func cleanStaleTmp(path string, name string, log *slog.Logger) error {
+	if name == "" {
+		return errors.New("profile name cannot be empty")
+	}
+	g, err := glob.Compile("." + backupPattern(name) + zip.TmpExt)
+	if err != nil {
+		return fmt.Errorf("glop pattern for '%s' does not compile: %v", name, err)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("error reading dir %s, %v", path, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !g.Match(e.Name()) {
+			continue
+		}
+		log.Info("Deleting incomplete backup of an earlier run", "file", e.Name())
+		err = os.Remove(filepath.Join(path, e.Name()))
+		if err != nil {
+			return fmt.Errorf("unable to delete incomplete zip file: %v", err)
+		}
+	}
+	return nil
 }
 
 // extractTime takes a filename and generates a time.Time for when the file was created
@@ -101,7 +161,7 @@ func extractTime(in string) time.Time {
 
 	// sample file pattern name_2006_02_01-15:04:05_backup.zip
 	// this extracts the date-time string starting from the end of the file name
-	parts := strings.Split(in, "_")
+	parts := strings.Split(zip.VolumeBase(in), "_")
 	parts = parts[len(parts)-4 : len(parts)-1]
 
 	t, _ := time.Parse(dateStr, strings.Join(parts, "_"))
@@ -50,6 +50,42 @@ func TestExpurgeDir(t *testing.T) {
 	}
 }
 
+func TestCleanStaleTmp(t *testing.T) {
+	tmpdir := t.TempDir()
+	files := []string{
+		".blib_2006_02_05-17:04:05_backup.zip.tmp",
+		".blib_2007_02_05-17:04:05_backup.zip.002.tmp",
+		".name_2008_02_05-17:04:05_backup.zip.tmp",
+		"blib_2008_02_05-17:04:05_backup.zip",
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(tmpdir, f), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := cleanStaleTmp(tmpdir, "blib", logger.SilentLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := os.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{
+		".name_2008_02_05-17:04:05_backup.zip.tmp",
+		"blib_2008_02_05-17:04:05_backup.zip",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestFindToDelete(t *testing.T) {
 
 	tcs := []struct {
@@ -102,6 +138,25 @@ func TestFindToDelete(t *testing.T) {
 				"blib_2006_02_08-17:04:05_backup.zip",
 			},
 		},
+		{
+			name:        "volumes of a split backup count as one backup",
+			profileName: "blib",
+			keepOld:     1,
+			in: []string{
+				"blib_2006_02_05-17:04:05_backup.zip.002",
+				"blib_2006_02_05-17:04:05_backup.zip.001",
+				"blib_2006_02_06-17:04:05_backup.zip.001",
+				"blib_2006_02_06-17:04:05_backup.zip.002",
+				"blib_2006_02_06-17:04:05_backup.zip.003",
+				"blib_2006_02_04-17:04:05_backup.zip",
+				"blib_2006_02_04-17:04:05_backup.zip.part",
+			},
+			expect: []string{
+				"blib_2006_02_04-17:04:05_backup.zip",
+				"blib_2006_02_05-17:04:05_backup.zip.001",
+				"blib_2006_02_05-17:04:05_backup.zip.002",
+			},
+		},
 	}
 
 	for _, tc := range tcs {
@@ -143,6 +198,11 @@ func TestExtractTome(t *testing.T) {
 			in:     "name_2021_11_05-05:02:32_backup.zip",
 			expect: genTime("2021_11_05-05:02:32"),
 		},
+		{
+			name:   "volume of a split backup",
+			in:     "name_2021_11_05-05:02:32_backup.zip.002",
+			expect: genTime("2021_11_05-05:02:32"),
+		},
 	}
 	for _, tc := range tcs {
 		t.Run(tc.name, func(t *testing.T) {
@@ -4,9 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/AndresBott/goback/internal/profile"
-	"github.com/AndresBott/goback/lib/ssh"
 	"github.com/AndresBott/goback/lib/zip"
+	"github.com/gobwas/glob"
 	"github.com/pkg/sftp"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -14,10 +15,19 @@ import (
 type fileAdder interface {
 	AddFile(origin string, dest string) error
 	AddSymlink(origin string, dest string) error
+	AddDir(origin string, dest string) error
+	AddHardlink(origin string, target string, dest string) error
 }
 
-// copyLocalFiles takes a single backup dir, recursively traverses the files and adds them to the zip handler
-func copyLocalFiles(dir profile.BackupPath, fa fileAdder) error {
+// inode identifies a file on the local file system, used to detect hard links
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// copyLocalFiles takes a single backup dir, recursively traverses the files and adds them to the zip handler,
+// the files added and excluded are counted in p
+func copyLocalFiles(dir profile.BackupPath, fa fileAdder, p *sourceProgress) error {
 
 	rootDir := dir.Path
 
@@ -45,22 +55,32 @@ func copyLocalFiles(dir profile.BackupPath, fa fileAdder) error {
 		return errors.New("the path is not a directory")
 	}
 
+	// the size of the files is the total used to report the progress
+	if p != nil {
+		total, err := localSize(rootDir, dir.Exclude)
+		if err != nil {
+			return err
+		}
+		p.setTotal(total)
+	}
+
+	// the first archive path of every inode with multiple hard links, its content is only stored once
+	hardlinks := map[inode]string{}
+
 	// this function is called for every file/dir when walking the file system
 	fn := func(path string, info os.FileInfo, err error) error {
 
 		if err != nil {
 			return fmt.Errorf("error waling directory: %v", err)
 		}
-		// skip directories, they are created by the zip handler
-		if info.IsDir() {
-			return nil
-		}
 
-		// skip excluded glob patterns
-		for _, g := range dir.Exclude {
-			if g.Match(path) {
-				return nil
+		// skip excluded glob patterns, an excluded dir is skipped with all its content
+		if isExcluded(dir.Exclude, path, info.IsDir()) {
+			p.addExcluded(1)
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
 
 		// transform the origin to absolute path
@@ -79,19 +99,41 @@ func copyLocalFiles(dir profile.BackupPath, fa fileAdder) error {
 		// here we use the profile root not the calculated one in case of  symlink
 		relPath = filepath.Join(filepath.Base(dir.Path), relPath)
 
+		// add directories as well, to keep empty ones and their mode
+		if info.IsDir() {
+			return fa.AddDir(absPath, relPath)
+		}
+
 		// if target is a symlink add the symlink
 		if info.Mode()&os.ModeSymlink == os.ModeSymlink { // & is a bit AND
 			err := fa.AddSymlink(absPath, relPath)
 			if err != nil {
 				return err
 			}
+			p.addFile()
 			return nil
 		}
 
+		key, isLink := hardlinkKey(info)
+		if isLink {
+			if target, seen := hardlinks[key]; seen {
+				err = fa.AddHardlink(absPath, target, relPath)
+				if err != nil {
+					return err
+				}
+				p.addFile()
+				return nil
+			}
+		}
+
 		err = fa.AddFile(absPath, relPath)
 		if err != nil {
 			return err
 		}
+		p.addFile()
+		if isLink {
+			hardlinks[key] = relPath
+		}
 		return nil
 	}
 
@@ -103,86 +145,190 @@ func copyLocalFiles(dir profile.BackupPath, fa fileAdder) error {
 	return nil
 }
 
-// copyRemoteFiles takes a single backup dir, connects over ssh and recursively traverses the files and adds them to the zip handler
-func copyRemoteFiles(sshc *ssh.Client, dir profile.BackupPath, zh *zip.Handler) (err error) {
-
-	sftpc, err := sftp.NewClient(sshc.Connection())
-	if err != nil {
-		return fmt.Errorf("unable to create sftp client %v", err)
+// isExcluded checks if the path matches one of the exclude patterns, dirs are matched with a trailing slash,
+// so that only patterns like "*/cache/" or "*/cache/**" exclude a dir, file patterns like "*.log" don't
+func isExcluded(exclude []glob.Glob, path string, isDir bool) bool {
+	if isDir {
+		path += "/"
 	}
-	defer func() {
-		err = errors.Join(err, sftpc.Close())
-	}()
+	for _, g := range exclude {
+		if g.Match(path) {
+			return true
+		}
+	}
+	return false
+}
 
-	rootDir := dir.Path
-	if !filepath.IsAbs(rootDir) {
-		wd, err := sftpc.Getwd()
+// localSize returns the size of the regular files in rootDir that are not excluded
+func localSize(rootDir string, exclude []glob.Glob) (int64, error) {
+	var total int64
+	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return fmt.Errorf("unable to get working dir %v", err)
+			return fmt.Errorf("error waling directory: %v", err)
 		}
-		rootDir = filepath.Join(wd, rootDir)
-	}
+		if isExcluded(exclude, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
 
-	// check if dir exists
-	finfo, err := sftpc.Stat(rootDir)
+// copyRemoteFiles takes a single backup dir, recursively traverses the remote files over sftp and adds them to the zip handler.
+// If the connection is lost, the session reconnects and the current step is retried; a file copy resumes where it stopped.
+// The files are listed first, their size is the total used to report the progress in p.
+func copyRemoteFiles(sess *sftpSession, dir profile.BackupPath, zh *zip.Handler, p *sourceProgress) error {
+
+	var rootDir string
+	err := sess.do(func(sftpc *sftp.Client) error {
+		rootDir = dir.Path
+		if !filepath.IsAbs(rootDir) {
+			wd, err := sftpc.Getwd()
+			if err != nil {
+				return fmt.Errorf("unable to get working dir %v", err)
+			}
+			rootDir = filepath.Join(wd, rootDir)
+		}
+
+		// check if dir exists
+		finfo, err := sftpc.Stat(rootDir)
+		if err != nil {
+			return fmt.Errorf("error checking dir %s, %v", rootDir, err)
+		}
+		if !finfo.IsDir() {
+			return errors.New("the path is not a directory")
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error checking dir %s, %v", rootDir, err)
+		return err
 	}
-	if !finfo.IsDir() {
-		return errors.New("the path is not a directory")
+
+	// list the files first, so that a lost connection does not leave a partial walk behind
+	type remoteEntry struct {
+		path string
+		info os.FileInfo
 	}
+	var entries []remoteEntry
+	excluded := 0
+	err = sess.do(func(sftpc *sftp.Client) error {
+		entries = nil
+		excluded = 0
+		w := sftpc.Walk(rootDir)
+
+		for w.Step() {
+			if w.Err() != nil {
+				return fmt.Errorf("error walking directory: %v", w.Err())
+			}
 
-	w := sftpc.Walk(rootDir)
+			// skip excluded glob patterns, an excluded dir is skipped with all its content
+			if isExcluded(dir.Exclude, w.Path(), w.Stat().IsDir()) {
+				excluded++
+				if w.Stat().IsDir() {
+					w.SkipDir()
+				}
+				continue
+			}
+			entries = append(entries, remoteEntry{path: w.Path(), info: w.Stat()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.addExcluded(excluded)
 
-OUTER:
-	for w.Step() {
-		if w.Err() != nil {
-			return fmt.Errorf("error walking directory")
+	var total int64
+	for _, entry := range entries {
+		if entry.info.Mode().IsRegular() {
+			total += entry.info.Size()
 		}
-		info := w.Stat()
+	}
+	p.setTotal(total)
 
-		// skip directories, they are created by the zip handler
-		if info.IsDir() {
-			continue OUTER
+	for _, entry := range entries {
+		// transform to relative path for the destination
+		relPath, err := filepath.Rel(rootDir, entry.path)
+		if err != nil {
+			return err
 		}
 
-		// skip excluded glob patterns
-		for _, g := range dir.Exclude {
-			if g.Match(w.Path()) {
-				continue OUTER
+		// add the directory base to the destination
+		relPath = filepath.Join(filepath.Base(rootDir), relPath)
+
+		// add directories as well, to keep empty ones and their mode
+		if entry.info.IsDir() {
+			err = zh.AddDirInfo(entry.info, relPath)
+			if err != nil {
+				return err
 			}
+			continue
 		}
 
-		// transform to absolute path
-		absPath := w.Path()
-		if !filepath.IsAbs(w.Path()) {
-			wd, err := sftpc.Getwd()
+		if entry.info.Mode()&os.ModeSymlink == os.ModeSymlink {
+			var target string
+			err = sess.do(func(sftpc *sftp.Client) error {
+				var lErr error
+				target, lErr = sftpc.ReadLink(entry.path)
+				return lErr
+			})
 			if err != nil {
-				return fmt.Errorf("unable to get working dir %v", err)
+				return fmt.Errorf("unable to read remote link %s: %v", entry.path, err)
+			}
+			err = zh.WriteSymlink(entry.info, target, relPath)
+			if err != nil {
+				return err
 			}
-			absPath = filepath.Join(wd, w.Path())
+			p.addFile()
+			continue
 		}
 
-		// and back to relative for the destination
-		relPath, err := filepath.Rel(rootDir, absPath)
+		wr, err := zh.FileWriterInfo(entry.info, relPath)
 		if err != nil {
 			return err
 		}
 
-		// add the directory base to the destination
-		relPath = filepath.Join(filepath.Base(rootDir), relPath)
-
-		f, err := sftpc.Open(w.Path())
+		var written int64
+		err = sess.do(func(sftpc *sftp.Client) error {
+			n, cErr := copyRemoteFile(sftpc, entry.path, written, wr)
+			written += n
+			return cErr
+		})
 		if err != nil {
-			return fmt.Errorf("unable to open remote file %s cause: %v", w.Path(), err)
+			return err
 		}
+		p.addFile()
+	}
 
-		err = zh.WriteFile(f, relPath)
+	return nil
+}
+
+// copyRemoteFile copies a remote file into the writer starting at offset and returns the number of bytes written
+func copyRemoteFile(sftpc *sftp.Client, file string, offset int64, wr io.Writer) (n int64, err error) {
+	f, err := sftpc.Open(file)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open remote file %s cause: %v", file, err)
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+
+	if offset > 0 {
+		_, err = f.Seek(offset, io.SeekStart)
 		if err != nil {
-			return err
+			return 0, fmt.Errorf("unable to seek remote file %s cause: %v", file, err)
 		}
-
 	}
 
-	return nil
+	n, err = io.Copy(wr, f)
+	if err != nil {
+		return n, fmt.Errorf("failed to copy remote file %s: %v", file, err)
+	}
+	return n, nil
 }
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package goback
+
+import (
+	"os"
+)
+
+// hardlinkKey is only supported on unix, hard links are stored as separate files
+func hardlinkKey(info os.FileInfo) (inode, bool) {
+	return inode{}, false
+}
@@ -1,15 +1,23 @@
 package goback
 
 import (
+	stdzip "archive/zip"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
+	"github.com/AndresBott/goback/app/logger"
 	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/zip"
 	"github.com/gobwas/glob"
 	"github.com/google/go-cmp/cmp"
 )
 
 type fileAppender struct {
-	files []string
+	files     []string
+	dirs      []string
+	hardlinks map[string]string
 }
 
 func (a *fileAppender) AddFile(origin string, dest string) error {
@@ -20,6 +28,17 @@ func (a *fileAppender) AddSymlink(origin string, dest string) error {
 	a.files = append(a.files, dest)
 	return nil
 }
+func (a *fileAppender) AddDir(origin string, dest string) error {
+	a.dirs = append(a.dirs, dest)
+	return nil
+}
+func (a *fileAppender) AddHardlink(origin string, target string, dest string) error {
+	if a.hardlinks == nil {
+		a.hardlinks = map[string]string{}
+	}
+	a.hardlinks[dest] = target
+	return nil
+}
 
 func TestCopyLocalFiles(t *testing.T) {
 
@@ -76,6 +95,20 @@ func TestCopyLocalFiles(t *testing.T) {
 				"files/notRoot/link",
 			},
 		},
+		{
+			name: "expect excluded directory to be skipped with its content",
+			profile: profile.BackupPath{
+				Path: "sampledata/files",
+				Exclude: []glob.Glob{
+					getGlob("sampledata/files/dir1/"),
+				},
+			},
+			want: []string{
+				"files/dir2/.hidden",
+				"files/dir2/file.yaml",
+				"files/notRoot/link",
+			},
+		},
 		{
 			name: "expect root symlink evaluated and backed up",
 			profile: profile.BackupPath{
@@ -108,7 +141,7 @@ func TestCopyLocalFiles(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 
 			fa := fileAppender{}
-			err := copyLocalFiles(tc.profile, &fa)
+			err := copyLocalFiles(tc.profile, &fa, nil)
 			got := fa.files
 
 			if err != nil {
@@ -123,6 +156,135 @@ func TestCopyLocalFiles(t *testing.T) {
 	}
 }
 
+func TestCopyLocalFilesDirs(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	for _, d := range []string{"empty", "sub/nested"} {
+		err := os.MkdirAll(filepath.Join(root, d), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fa := fileAppender{}
+	err := copyLocalFiles(profile.BackupPath{Path: root}, &fa, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"root", "root/empty", "root/sub", "root/sub/nested"}
+	if diff := cmp.Diff(want, fa.dirs); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCopyLocalFilesHardlinks(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "mail")
+	err := os.MkdirAll(filepath.Join(root, "cur"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(root, "a"), []byte("message"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{"b", "cur/c"} {
+		err = os.Link(filepath.Join(root, "a"), filepath.Join(root, l))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fa := fileAppender{}
+	err = copyLocalFiles(profile.BackupPath{Path: root}, &fa, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"mail/a"}, fa.files); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	wantLinks := map[string]string{
+		"mail/b":     "mail/a",
+		"mail/cur/c": "mail/a",
+	}
+	if diff := cmp.Diff(wantLinks, fa.hardlinks); diff != "" {
+		t.Errorf("hard links mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestCopyRemoteFiles(t *testing.T) {
 
 }
+
+func TestCopyFilesExcludedDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "files")
+	for _, f := range []string{"dir1/a.txt", "dir1/sub/b.txt", "app.log/data.txt", "other.log", "keep.txt"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, f), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a dir pattern skips dir1 with its content, the file pattern *.log keeps the content of the dir app.log
+	dir := profile.BackupPath{Path: root, Exclude: []glob.Glob{getGlob(root + "/dir1/"), getGlob("*.log")}}
+	want := []string{"files/app.log/data.txt", "files/keep.txt"}
+
+	t.Run("local", func(t *testing.T) {
+		res := &RunResult{Sources: []SourceResult{{Kind: sourceDir, Name: root}}}
+		p := res.startSource(sourceDir, 0, nil, logger.SilentLogger())
+		fa := fileAppender{}
+		err := copyLocalFiles(dir, &fa, p)
+		p.done(err)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(want, fa.files); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+		if got := res.Sources[0].Stats.Excluded; got != 2 {
+			t.Errorf("got %d excluded, want 2", got)
+		}
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		zipFile := filepath.Join(t.TempDir(), "remote.zip")
+		zh, err := zip.New(zipFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := &RunResult{Sources: []SourceResult{{Kind: sourceDir, Name: root}}}
+		p := res.startSource(sourceDir, 0, zh, logger.SilentLogger())
+		sess := &sftpSession{sftpc: localSftp(t), log: logger.SilentLogger()}
+		err = copyRemoteFiles(sess, dir, zh, p)
+		p.done(err)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = zh.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		read, err := stdzip.OpenReader(zipFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = read.Close()
+		}()
+		var got []string
+		for _, f := range read.File {
+			if !f.Mode().IsDir() {
+				got = append(got, f.Name)
+			}
+		}
+		// the remote walk is in the order the server lists the dirs
+		sort.Strings(got)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+		if got := res.Sources[0].Stats.Excluded; got != 2 {
+			t.Errorf("got %d excluded, want 2", got)
+		}
+	})
+}
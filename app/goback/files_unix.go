@@ -0,0 +1,17 @@
+//go:build unix
+
+package goback
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardlinkKey returns the inode of a file that has more than one hard link
+func hardlinkKey(info os.FileInfo) (inode, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inode{}, false
+	}
+	return inode{dev: uint64(st.Dev), ino: st.Ino}, true // #nosec G115 -- dev is never negative
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/user"
@@ -28,8 +29,26 @@ const dateStr = "2006_02_01-15:04:05"
 
 // BackupRunner is the entry point to the application
 type BackupRunner struct {
-	Logger   *slog.Logger
+	Logger *slog.Logger
+	// LockMode defines what happens if a profile is already running
+	LockMode LockMode
+	// LockDir is the location of the profile lock files, DefaultLockDir is used if empty
+	LockDir string
+	// Digest sends a single notification with the results of all the profiles at the end of Run
+	Digest *profile.Notify
+	// Metrics records the result of every profile run, not recorded if nil
+	Metrics *Metrics
+	// History keeps a record of every profile run, not recorded if nil
+	History *History
+	// Results holds the result of every profile once Run returns
+	Results []RunResult
+	// Progress is where a live progress bar of the running dir or db is drawn, e.g. a terminal, no bar if nil
+	Progress io.Writer
 	profiles []profile.Profile
+	// bar is the progress bar drawn on Progress
+	bar *progressBar
+	// loadErrs are the profiles of a directory that failed to load, Run reports them as failed
+	loadErrs []*profile.LoadError
 }
 
 // LoadProfileFile adds a single profile file to the list of profiles to be executed
@@ -45,9 +64,9 @@ func (br *BackupRunner) LoadProfileFile(file string) error {
 	return nil
 }
 
-// LoadProfilesDir adds all the profiles found in the directory to the list of profiles to be executed
-// note that profile.LoadProfiles returns a partial correct result, hence even if there were errors
-// the callers can still run the correct profiles.
+// LoadProfilesDir adds all the profiles found in the directory to the list of profiles to be executed,
+// profiles that fail to load don't make it fail, they are run as failed profiles so that the correct ones
+// still run and the failures are part of the results, notifications and report.
 func (br *BackupRunner) LoadProfilesDir(dir string) error {
 
 	br.Logger.Info("Loading profile directory", "dor", dir)
@@ -55,25 +74,60 @@ func (br *BackupRunner) LoadProfilesDir(dir string) error {
 	prfl, err := profile.LoadProfiles(dir)
 	// we still want to append the correct profiles
 	br.profiles = append(br.profiles, prfl...)
-	if err != nil {
-		return err
+
+	// profiles that failed to load are reported as failed runs by Run
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else if err != nil {
+		errs = []error{err}
 	}
-	return nil
+	var other error
+	for _, e := range errs {
+		loadErr := &profile.LoadError{}
+		if errors.As(e, &loadErr) {
+			br.loadErrs = append(br.loadErrs, loadErr)
+			continue
+		}
+		other = errors.Join(other, e)
+	}
+	return other
 }
 
-// Run executes all the profiles loaded
+// Run executes all the profiles loaded, if a digest is configured one summary of all the runs is sent at the end
 func (br *BackupRunner) Run() error {
 
 	var errs error
+	var results []RunResult
+
+	log := br.Logger
+	var hb *heartbeat
+	start := time.Now()
+	if br.Digest != nil {
+		hb, log = startHeartbeat(br.Digest.Heartbeat, fmt.Sprintf("backup of %d profiles", len(br.profiles)), log)
+	}
+
+	for _, loadErr := range br.loadErrs {
+		log.Error("Profile failed to load", "file", loadErr.File, "error", loadErr.Err.Error())
+		results = append(results, RunResult{Profile: loadErr.File, Start: start, Err: loadErr})
+		errs = errors.Join(errs, loadErr)
+	}
 
 	for _, prfl := range br.profiles {
-		err := br.RunProfile(prfl)
-		if err != nil {
-			br.Logger.Error("Profile execution failed", "profile", prfl.Name, "error", err.Error())
-			errs = errors.Join(errs, fmt.Errorf("profile %s failed: %w", prfl.Name, err))
+		res := br.runProfile(prfl, log)
+		results = append(results, res)
+		if res.Err != nil {
+			log.Error("Profile execution failed", "profile", prfl.Name, "error", res.Err.Error())
+			errs = errors.Join(errs, fmt.Errorf("profile %s failed: %w", prfl.Name, res.Err))
 		}
 	}
 
+	br.Results = results
+	hb.finish(time.Since(start), errs)
+	if br.Digest != nil {
+		NotifyDigest(*br.Digest, results, br.Logger)
+	}
+
 	if errs != nil {
 		// we don't need to unwarp the errors, they are logged already (?)
 		return errors.New("at least one profile execution was not successful")
@@ -83,10 +137,31 @@ func (br *BackupRunner) Run() error {
 
 // RunProfile Runs a single backup profile
 func (br *BackupRunner) RunProfile(prfl profile.Profile) error {
-	br.Logger.Info("Loading profile", "name", prfl.Name)
-	start := time.Now()
+	res := br.runProfile(prfl, br.Logger)
+	if res.Err != nil {
+		return fmt.Errorf("profile %s failed: %w", prfl.Name, res.Err)
+	}
+	return nil
+}
+
+// runProfile runs a single backup profile and returns its result, errors are part of the result
+func (br *BackupRunner) runProfile(prfl profile.Profile, log *slog.Logger) RunResult {
+	log.Info("Loading profile", "name", prfl.Name)
+	res := RunResult{
+		Profile: prfl.Name,
+		Type:    prfl.Type,
+		Start:   time.Now(),
+		Sources: profileSources(prfl),
+	}
+	if br.Progress != nil {
+		if br.bar == nil {
+			br.bar = &progressBar{out: br.Progress}
+		}
+		res.progress = br.bar
+		log = slog.New(barHandler{log.Handler(), br.bar})
+	}
+	defer br.record(&res, log)
 
-	type runnerFn func(profile.Profile, *slog.Logger) error
 	var runFn runnerFn
 	switch prfl.Type {
 	case profile.TypeLocal:
@@ -95,74 +170,241 @@ func (br *BackupRunner) RunProfile(prfl profile.Profile) error {
 		runFn = runRemoteProfile
 	case profile.TypeSftpSync:
 		runFn = runSyncProfile
+	case profile.TypeSftpPush:
+		runFn = runPushProfile
 	default:
-		return fmt.Errorf("unknown profile type: %s", prfl.Type)
+		res.Err = fmt.Errorf("unknown profile type: %s", prfl.Type)
+		return res
+	}
+
+	lockDir := br.LockDir
+	if lockDir == "" {
+		lockDir = DefaultLockDir()
+	}
+	// the lock is taken within the notified run, so that a locked profile is reported like any other failure
+	// and a skipped one like a success
+	err := RunWithNotify(prfl, &res, log, func(prfl profile.Profile, res *RunResult, log *slog.Logger) error {
+		release, err := lockProfile(prfl, lockDir, br.LockMode, log)
+		if errors.Is(err, errSkipLocked) {
+			res.Skipped = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer release()
+		return runFn(prfl, res, log)
+	})
+	if err == nil && !res.Skipped {
+		log.Info("Backup duration", "dur", res.Duration)
+	}
+	return res
+}
+
+// record adds the result of a run to the history and the metrics
+func (br *BackupRunner) record(res *RunResult, log *slog.Logger) {
+	if br.History != nil {
+		err := br.History.Record(*res)
+		if err != nil {
+			log.Error("unable to record run history", "profile", res.Profile, "error", err)
+		}
+	}
+	if br.Metrics != nil {
+		err := br.Metrics.Record(*res)
+		if err != nil {
+			log.Error("unable to record metrics", "profile", res.Profile, "error", err)
+		}
+	}
+}
+
+// RunResult holds the outcome of a profile run, it is sent in the notifications
+type RunResult struct {
+	Profile  string
+	Type     profile.ProfileType
+	Start    time.Time
+	Duration time.Duration
+	// Archive is the backup file created by local and remote profiles, split backups are recorded by
+	// the name of the zip without volume number
+	Archive string
+	// Size is the size of the archive, including all volumes
+	Size int64
+	// Files is the number of files in the archive
+	Files int
+	// Skipped is set if the profile was not run because it was locked by another run
+	Skipped bool
+	Err     error
+	// Sources holds the outcome of every dir and db of the profile, in the order they are run
+	Sources []SourceResult
+	// progress is the bar the progress of the sources is drawn on
+	progress *progressBar
+}
+
+const (
+	sourceDir = "dir"
+	sourceDb  = "db"
+)
+
+// SourceResult is the outcome of a single dir or db of a profile
+type SourceResult struct {
+	// Kind is dir or db
+	Kind string
+	// Name is the path of a dir or the name of a db
+	Name string
+	// Status is success, failure or skipped if the source was not reached because of an earlier error
+	Status string
+	Err    error
+	// Stats are collected for dirs and dbs written into an archive
+	Stats SourceStats
+}
+
+// profileSources returns the sources of the profile, all of them skipped until they are run
+func profileSources(prfl profile.Profile) []SourceResult {
+	var srcs []SourceResult
+	for _, d := range prfl.Dirs {
+		srcs = append(srcs, SourceResult{Kind: sourceDir, Name: d.Path, Status: "skipped"})
+	}
+	for _, db := range prfl.Dbs {
+		srcs = append(srcs, SourceResult{Kind: sourceDb, Name: db.Name, Status: "skipped"})
 	}
+	return srcs
+}
+
+// runnerFn runs a profile, runners record the archive they create and the outcome of the sources in the result
+type runnerFn func(profile.Profile, *RunResult, *slog.Logger) error
 
-	err := RunWithNotify(prfl, br.Logger, runFn)
+// sourceDone records the outcome of the i-th source of a kind; runs without result, e.g. the agent, pass nil
+func (r *RunResult) sourceDone(kind string, i int, err error) {
+	if r == nil {
+		return
+	}
+	src := r.source(kind, i)
+	if src == nil {
+		return
+	}
+	src.Status = "success"
+	src.Err = err
 	if err != nil {
-		return fmt.Errorf("profile %s failed: %w", prfl.Name, err)
+		src.Status = "failure"
 	}
+}
 
-	t := time.Now()
-	elapsed := t.Sub(start)
-	br.Logger.Info("Backup duration", "dur", elapsed)
+// source returns the i-th source of a kind, nil if there is none
+func (r *RunResult) source(kind string, i int) *SourceResult {
+	n := 0
+	for j := range r.Sources {
+		if r.Sources[j].Kind != kind {
+			continue
+		}
+		if n == i {
+			return &r.Sources[j]
+		}
+		n++
+	}
 	return nil
 }
 
-// RunWithNotify ias a wrapper function to the different profile runner functions, it will call the run function
-// and if the profile notification is defined it will send the profile owner notification out.
-func RunWithNotify(prfl profile.Profile, log *slog.Logger, fn func(prfl profile.Profile, log *slog.Logger) error) error {
-	err := fn(prfl, log)
+// sourcesDone records all sources as successful, used when the sources are run as a whole
+func (r *RunResult) sourcesDone() {
+	if r == nil {
+		return
+	}
+	for j := range r.Sources {
+		r.Sources[j].Status = "success"
+		r.Sources[j].Err = nil
+	}
+}
+
+// status returns success, failure or skipped
+func (r *RunResult) status() string {
+	switch {
+	case r.Err != nil:
+		return "failure"
+	case r.Skipped:
+		return "skipped"
+	default:
+		return "success"
+	}
+}
+
+// setArchive records the archive, its size and the number of files in it
+func (r *RunResult) setArchive(destZip string) error {
+	files, err := zip.Volumes(destZip)
 	if err != nil {
-		if prfl.Notify.HasValues() {
-			err2 := NotifyFailure(prfl.Notify, prfl.Name, err)
-			if err2 != nil {
-				log.Error("Error while sending notification", "err", err2)
-			}
+		return fmt.Errorf("unable to find zip file: %v", err)
+	}
+	var size int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
 		}
-		return err
+		size += info.Size()
 	}
-	if prfl.Notify.HasValues() && prfl.Notify.OnSuccess {
-		err2 := NotifySuccess(prfl.Notify, prfl.Name)
+	names, err := zip.ListFiles(destZip)
+	if err != nil {
+		return fmt.Errorf("unable to list files of zip file: %v", err)
+	}
+	r.Archive = destZip
+	r.Size = size
+	r.Files = len(names)
+	return nil
+}
+
+// RunWithNotify ias a wrapper function to the different profile runner functions, it will call the run function
+// and if the profile notification is defined it will send the profile owner notification out.
+func RunWithNotify(prfl profile.Profile, res *RunResult, log *slog.Logger, fn runnerFn) error {
+	hb, runLog := startHeartbeat(prfl.Notify.Heartbeat, "profile "+prfl.Name, log)
+	err := fn(prfl, res, runLog)
+	res.Duration = time.Since(res.Start)
+	res.Err = err
+	hb.finish(res.Duration, err)
+
+	if prfl.Notify.HasValues() && (err != nil || prfl.Notify.OnSuccess) {
+		err2 := NotifyEmail(prfl.Notify.EmailNotify, *res)
 		if err2 != nil {
 			log.Error("Error while sending notification", "err", err2)
 		}
 	}
-	return nil
+
+	for _, hook := range prfl.Notify.Webhooks {
+		if err != nil || hook.OnSuccess {
+			err2 := sendWebhook(hook, *res)
+			if err2 != nil {
+				log.Error("Error while sending webhook", "err", err2)
+			}
+		}
+	}
+	return err
 }
 
 // runLocalProfile takes a single profile as input and generates a single Zip backup as output
 // the sources of backup MUST  be a local profile
-func runLocalProfile(prfl profile.Profile, log *slog.Logger) error {
+func runLocalProfile(prfl profile.Profile, res *RunResult, log *slog.Logger) error {
 
 	// check if destination dir exists, or create
 	err := prepareDestination(prfl.Destination.Path)
 	if err != nil {
 		return err
 	}
+	err = cleanStaleTmp(prfl.Destination.Path, prfl.Name, log)
+	if err != nil {
+		return err
+	}
 	destZip := filepath.Join(prfl.Destination.Path, getZipName(prfl.Name))
 
 	log.Info("backing up local profile to file", "destination", destZip)
-	err = backupLocal(prfl, destZip, log)
+	err = backupLocal(prfl, destZip, res, log)
 	if err != nil {
 		return delZipAndErr(destZip, err)
 	}
 
-	// change file ownership
-	if prfl.Destination.Owner != "" {
-		err := chown(destZip, prfl.Destination.Owner)
-		if err != nil {
-			return fmt.Errorf("unable to change owner of file: \"%s\", %v", destZip, err)
-		}
+	err = setDestinationPerms(destZip, prfl.Destination)
+	if err != nil {
+		return err
 	}
-
-	// change file mode
-	if prfl.Destination.Mode != "" {
-		err := chmod(destZip, prfl.Destination.Mode)
-		if err != nil {
-			return fmt.Errorf("unable to change perm of file: \"%s\", %v", destZip, err)
-		}
+	err = res.setArchive(destZip)
+	if err != nil {
+		return err
 	}
 
 	if prfl.Destination.Keep > 0 {
@@ -314,17 +556,57 @@ func backupRemoteDatabase(sshC *ssh.Client, db profile.BackupDb, zipHandler *zip
 }
 
 // backupLocal will run all the backup steps when running on the same machine
-func backupLocal(prfl profile.Profile, zipDestination string, log *slog.Logger) error {
+func backupLocal(prfl profile.Profile, zipDestination string, res *RunResult, log *slog.Logger) error {
 
-	zipHandler, err := zip.New(zipDestination)
+	zipHandler, err := zip.NewSplit(zipDestination, int64(prfl.Destination.SplitSize))
 	if err != nil {
 		return err
 	}
+	zipHandler.SetCompression(zipCompression(prfl.Compression))
+	return writeLocalBackup(prfl, zipHandler, res, log)
+}
+
+// BackupToWriter runs the backup of a local profile and streams the zip archive into w,
+// this is the agent mode used by remote profiles to create the archive on the remote host
+func BackupToWriter(prfl profile.Profile, w io.Writer, log *slog.Logger) error {
+	if prfl.Type != profile.TypeLocal {
+		return fmt.Errorf("only local profiles can be written to a stream, got: %s", prfl.Type)
+	}
+	zipHandler := zip.NewStream(w)
+	zipHandler.SetCompression(zipCompression(prfl.Compression))
+	return writeLocalBackup(prfl, zipHandler, nil, log)
+}
+
+// zipCompression translates the profile compression settings into the zip handler ones
+func zipCompression(c profile.Compression) zip.Compression {
+	out := zip.Compression{
+		Level:           -1,
+		StoreExtensions: zip.DefaultStoreExtensions,
+	}
+	if c.Level != nil {
+		out.Level = *c.Level
+	}
+	if c.StoreExtensions != nil {
+		out.StoreExtensions = c.StoreExtensions
+	}
+	return out
+}
+
+// writeLocalBackup copies the local dirs and dbs of the profile into the zip handler and closes it,
+// on error the incomplete zip file is discarded
+func writeLocalBackup(prfl profile.Profile, zipHandler *zip.Handler, res *RunResult, log *slog.Logger) (err error) {
+	defer func() {
+		if err != nil {
+			_ = zipHandler.Abort()
+		}
+	}()
 
 	// copy files into the zip
-	for _, bkpDir := range prfl.Dirs {
+	for i, bkpDir := range prfl.Dirs {
 		log.Info("backing up directory", "dir", bkpDir.Path)
-		err = copyLocalFiles(bkpDir, zipHandler)
+		p := res.startSource(sourceDir, i, zipHandler, log)
+		err = copyLocalFiles(bkpDir, zipHandler, p)
+		p.done(err)
 		if err != nil {
 			return err
 		}
@@ -332,9 +614,11 @@ func backupLocal(prfl profile.Profile, zipDestination string, log *slog.Logger)
 
 	// dump DBs into the zip
 	if len(prfl.Dbs) > 0 {
-		for _, db := range prfl.Dbs {
+		for i, db := range prfl.Dbs {
 			log.Info("backing up Database", "db", db.Name, "type", db.Type)
+			p := res.startSource(sourceDb, i, zipHandler, log)
 			err = backupLocalDatabase(db, zipHandler, log)
+			p.done(err)
 			if err != nil {
 				return err
 			}
@@ -342,41 +626,37 @@ func backupLocal(prfl profile.Profile, zipDestination string, log *slog.Logger)
 	}
 
 	// close the zip file at the end
-	zipHandler.Close()
-	return nil
+	return zipHandler.Close()
 }
 
 // runLocalProfile takes a single profile as input and generates a single Zip backup as output
 // the sources of backup MUST be a remote profile
-func runRemoteProfile(prfl profile.Profile, log *slog.Logger) error {
+func runRemoteProfile(prfl profile.Profile, res *RunResult, log *slog.Logger) error {
 
 	// check if destination dir exists, or create
 	err := prepareDestination(prfl.Destination.Path)
 	if err != nil {
 		return err
 	}
-	destZip := filepath.Join(prfl.Destination.Path)
+	err = cleanStaleTmp(prfl.Destination.Path, prfl.Name, log)
+	if err != nil {
+		return err
+	}
+	destZip := filepath.Join(prfl.Destination.Path, getZipName(prfl.Name))
 
 	log.Info("backing up remote profile to file", "destination", destZip)
-	err = backupRemote(prfl, destZip, log)
+	err = backupRemote(prfl, destZip, res, log)
 	if err != nil {
 		return delZipAndErr(destZip, err)
 	}
 
-	// change file ownership
-	if prfl.Destination.Owner != "" {
-		err := chown(destZip, prfl.Destination.Owner)
-		if err != nil {
-			return fmt.Errorf("unable to change owner of file: \"%s\", %v", destZip, err)
-		}
+	err = setDestinationPerms(destZip, prfl.Destination)
+	if err != nil {
+		return err
 	}
-
-	// change file mode
-	if prfl.Destination.Mode != "" {
-		err := chmod(destZip, prfl.Destination.Mode)
-		if err != nil {
-			return fmt.Errorf("unable to change perm of file: \"%s\", %v", destZip, err)
-		}
+	err = res.setArchive(destZip)
+	if err != nil {
+		return err
 	}
 
 	if prfl.Destination.Keep > 0 {
@@ -396,48 +676,179 @@ func runRemoteProfile(prfl profile.Profile, log *slog.Logger) error {
 // exposed internally for testing purposes only
 var ignoreHostKey = false
 
-// backupRemote will open an ssh connection to a remote location and run copy of files and dbs
-func backupRemote(prfl profile.Profile, dest string, log *slog.Logger) error {
-
-	sshC, err := ssh.New(ssh.Cfg{
-		Host:          prfl.Ssh.Host,
-		Port:          prfl.Ssh.Port,
-		Auth:          ssh.GetAuthType(prfl.Ssh.Type),
-		User:          prfl.Ssh.User,
-		Password:      prfl.Ssh.Password,
-		PrivateKey:    prfl.Ssh.PrivateKey,
-		PassPhrase:    prfl.Ssh.Passphrase,
-		IgnoreHostKey: ignoreHostKey, // set to false and only exposed for testing
-	})
+// connectSsh creates an ssh client out of the profile ssh settings and opens the connection
+func connectSsh(cfg profile.Ssh, log *slog.Logger) (*ssh.Client, error) {
+	var trust ssh.TrustFunc
+	if cfg.TrustOnFirstUse {
+		trust = func(hostname, keyType, fingerprint string) bool {
+			log.Warn("trusting unknown host key on first use", "host", hostname, "type", keyType, "fingerprint", fingerprint)
+			return true
+		}
+	}
 
+	sshC, err := ssh.New(sshCfg(cfg, trust))
 	if err != nil {
-		return fmt.Errorf("error creating ssh client: %v", err)
+		return nil, fmt.Errorf("error creating ssh client: %v", err)
 	}
+
 	err = sshC.Connect()
 	if err != nil {
-		return fmt.Errorf("error connecting ssh: %v", err)
+		return nil, fmt.Errorf("error connecting ssh: %v", err)
+	}
+	return sshC, nil
+}
+
+const (
+	defaultKeepAlive  = 30 * time.Second
+	defaultRetries    = 3
+	defaultRetryDelay = 5 * time.Second
+	maxRetryDelay     = time.Minute
+)
+
+// sshRetries returns the number of retries of the profile, negative values disable them
+func sshRetries(cfg profile.Ssh) int {
+	if cfg.Retries == 0 {
+		return defaultRetries
+	}
+	return max(cfg.Retries, 0)
+}
+
+// sshCfg translates the profile ssh settings into the ssh client configuration
+func sshCfg(cfg profile.Ssh, trust ssh.TrustFunc) ssh.Cfg {
+	knownHosts := cfg.KnownHosts
+	if knownHosts == "" {
+		knownHosts = DefaultKnownHostsFile()
+	}
+
+	keepAlive := cfg.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = defaultKeepAlive
+	}
+	retryDelay := cfg.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = defaultRetryDelay
+	}
+
+	var jumps []ssh.Cfg
+	for _, jump := range cfg.Jump {
+		jumps = append(jumps, ssh.Cfg{
+			Host:           jump.Host,
+			Port:           jump.Port,
+			Auth:           ssh.GetAuthType(jump.Type),
+			User:           jump.User,
+			Password:       jump.Password,
+			PrivateKey:     jump.PrivateKey,
+			PassPhrase:     jump.Passphrase,
+			IgnoreHostKey:  ignoreHostKey, // set to false and only exposed for testing
+			HostKey:        jump.HostKey,
+			KnownHostsFile: knownHosts,
+			TrustHostKey:   trust,
+		})
+	}
+
+	return ssh.Cfg{
+		Host:           cfg.Host,
+		Port:           cfg.Port,
+		Auth:           ssh.GetAuthType(cfg.Type),
+		User:           cfg.User,
+		Password:       cfg.Password,
+		PrivateKey:     cfg.PrivateKey,
+		PassPhrase:     cfg.Passphrase,
+		IgnoreHostKey:  ignoreHostKey, // set to false and only exposed for testing
+		Jump:           jumps,
+		ConfigHost:     cfg.ConfigHost,
+		HostKey:        cfg.HostKey,
+		KnownHostsFile: knownHosts,
+		TrustHostKey:   trust,
+		Sudo: ssh.Sudo{
+			Enabled:    cfg.Sudo,
+			User:       cfg.SudoUser,
+			Password:   cfg.SudoPassword,
+			SftpServer: cfg.SftpServer,
+		},
+		KeepAlive: max(keepAlive, 0),
+		Retry: ssh.RetryPolicy{
+			Attempts: sshRetries(cfg),
+			Delay:    retryDelay,
+			MaxDelay: maxRetryDelay,
+		},
+	}
+}
+
+// DefaultKnownHostsFile returns the location of the known_hosts file managed by goback,
+// it is checked in addition to ~/.ssh/known_hosts and trusted host keys are recorded in it.
+func DefaultKnownHostsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goback", "known_hosts")
+}
+
+// backupRemote will open an ssh connection to a remote location and run copy of files and dbs
+func backupRemote(prfl profile.Profile, dest string, res *RunResult, log *slog.Logger) (err error) {
+
+	sshC, err := connectSsh(prfl.Ssh, log)
+	if err != nil {
+		return err
 	}
 	defer func() {
 		_ = sshC.Disconnect()
 	}()
 
-	zipHandler, err := zip.New(dest)
+	if prfl.Agent.Enabled {
+		err = backupRemoteAgent(sshC, prfl, dest, log)
+		if err == nil {
+			res.sourcesDone()
+			return nil
+		}
+		log.Warn("unable to run goback on the remote host, falling back to sftp", "error", err)
+		// the volumes of a partially received split archive would not be overwritten
+		if rErr := removeZip(dest); rErr != nil && !errors.Is(rErr, os.ErrNotExist) {
+			return fmt.Errorf("unable to delete incomplete zip file: %v", rErr)
+		}
+	}
+
+	zipHandler, err := zip.NewSplit(dest, int64(prfl.Destination.SplitSize))
+	if err != nil {
+		return err
+	}
+	zipHandler.SetCompression(zipCompression(prfl.Compression))
+	defer func() {
+		if err != nil {
+			_ = zipHandler.Abort()
+		}
+	}()
+
+	sess, err := newSftpSession(sshC, sshRetries(prfl.Ssh), log)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = sess.Close()
+	}()
 
 	// dump filesystem data into zip
-	for _, bkpDir := range prfl.Dirs {
+	for i, bkpDir := range prfl.Dirs {
 		log.Info("backing up directory", "dir", bkpDir.Path)
-		err := copyRemoteFiles(sshC, bkpDir, zipHandler)
+		p := res.startSource(sourceDir, i, zipHandler, log)
+		retries := sess.retries
+		err := copyRemoteFiles(sess, bkpDir, zipHandler, p)
+		p.retried(sess.retries - retries)
+		p.done(err)
 		if err != nil {
 			return err
 		}
 	}
+	if sess.retries > 0 {
+		log.Warn("remote files copied after reconnecting", "retries", sess.retries)
+	}
 
 	if len(prfl.Dbs) > 0 {
-		for _, db := range prfl.Dbs {
+		for i, db := range prfl.Dbs {
+			p := res.startSource(sourceDb, i, zipHandler, log)
 			err = backupRemoteDatabase(sshC, db, zipHandler, log)
+			p.done(err)
 			if err != nil {
 				return err
 			}
@@ -445,14 +856,13 @@ func backupRemote(prfl profile.Profile, dest string, log *slog.Logger) error {
 	}
 
 	// close the zip file at the end
-	zipHandler.Close()
-	return nil
+	return zipHandler.Close()
 }
 
 // runSyncProfile takes a remote (sftp) location from the profile and downloads remote backups files
 // to the local location
 // the sources of backup MUST be a sftpSync profile
-func runSyncProfile(prfl profile.Profile, log *slog.Logger) (err error) {
+func runSyncProfile(prfl profile.Profile, res *RunResult, log *slog.Logger) (err error) {
 
 	// check if destination dir exists, or create
 	err = prepareDestination(prfl.Destination.Path)
@@ -460,29 +870,81 @@ func runSyncProfile(prfl profile.Profile, log *slog.Logger) (err error) {
 		return err
 	}
 
-	sshC, err := ssh.New(ssh.Cfg{
-		Host:          prfl.Ssh.Host,
-		Port:          prfl.Ssh.Port,
-		Auth:          ssh.GetAuthType(prfl.Ssh.Type),
-		User:          prfl.Ssh.User,
-		Password:      prfl.Ssh.Password,
-		PrivateKey:    prfl.Ssh.PrivateKey,
-		PassPhrase:    prfl.Ssh.Passphrase,
-		IgnoreHostKey: ignoreHostKey, // set to false and only exposed for testing
-	})
+	sshC, err := connectSsh(prfl.Ssh, log)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = sshC.Disconnect()
+	}()
 
+	sftpc, err := sshC.SftpClient()
 	if err != nil {
-		return fmt.Errorf("error creating ssh client: %v", err)
+		return fmt.Errorf("unable to create sftp client %v", err)
 	}
-	err = sshC.Connect()
+	defer func() {
+		cErr := sftpc.Close()
+		if cErr != nil {
+			err = errors.Join(err, cErr)
+		}
+	}()
+
+	// copy remote dirs contents into local
+	for i, syncDir := range prfl.Dirs {
+		err = syncRemoteDir(sftpc, prfl, syncDir, log)
+		res.sourceDone(sourceDir, i, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncRemoteDir downloads the backups of a single remote dir and deletes the old ones
+func syncRemoteDir(sftpc *sftp.Client, prfl profile.Profile, syncDir profile.BackupPath, log *slog.Logger) error {
+	log.Info("synchronising remote directory", "dir", syncDir.Path)
+	err := syncRemoteBackups(sftpc, syncDir.Path, syncDir.Name, prfl.Destination.Path, prfl.Sync, prfl.Destination.Keep, log)
+	if err != nil {
+		return err
+	}
+
+	if prfl.Destination.Keep <= 0 {
+		log.Info("skipping deleting older backups because", "name", prfl.Name)
+		return nil
+	}
+	if prfl.Sync.DryRun {
+		expurge, lErr := listExpurge(prfl.Destination.Path, prfl.Destination.Keep, syncDir.Name)
+		if lErr != nil {
+			return fmt.Errorf("error listing old backup files: %w", lErr)
+		}
+		for _, f := range expurge {
+			log.Info("dry-run: would delete old backup", "file", f)
+		}
+		return nil
+	}
+	// delete old backup files
+	log.Info("Deleting older backups for profile", "name", syncDir.Name)
+	err = ExpurgeDir(prfl.Destination.Path, prfl.Destination.Keep, syncDir.Name, log)
+	if err != nil {
+		return fmt.Errorf("error expurging old backup files: %w", err)
+	}
+	return nil
+}
+
+// runPushProfile takes local backup files from the profile dirs and uploads them to the remote (sftp) destination
+// the sources of backup MUST be a sftpPush profile
+func runPushProfile(prfl profile.Profile, res *RunResult, log *slog.Logger) (err error) {
+
+	sshC, err := connectSsh(prfl.Ssh, log)
 	if err != nil {
-		return fmt.Errorf("error connecting ssh: %v", err)
+		return err
 	}
 	defer func() {
 		_ = sshC.Disconnect()
 	}()
 
-	sftpc, err := sftp.NewClient(sshC.Connection())
+	sftpc, err := sshC.SftpClient()
 	if err != nil {
 		return fmt.Errorf("unable to create sftp client %v", err)
 	}
@@ -493,26 +955,36 @@ func runSyncProfile(prfl profile.Profile, log *slog.Logger) (err error) {
 		}
 	}()
 
-	// copy remote dirs contents into local
-	for _, syncDir := range prfl.Dirs {
-		log.Info("synchronising remote directory", "dir", syncDir.Path)
-		err = syncRemoteBackups(sftpc, syncDir.Path, syncDir.Name, prfl.Destination.Path, log)
+	// copy local dirs contents into the remote
+	for i, pushDir := range prfl.Dirs {
+		err = pushLocalDir(sftpc, prfl, pushDir, log)
+		res.sourceDone(sourceDir, i, err)
 		if err != nil {
 			return err
 		}
+	}
 
-		if prfl.Destination.Keep > 0 {
-			// delete old backup files
-			log.Info("Deleting older backups for profile", "name", syncDir.Name)
-			err = ExpurgeDir(prfl.Destination.Path, prfl.Destination.Keep, syncDir.Name, log)
-			if err != nil {
-				return fmt.Errorf("error expurging old backup files: %w", err)
-			}
-		} else {
-			log.Info("skipping deleting older backups because", "name", prfl.Name)
-		}
+	return nil
+}
+
+// pushLocalDir uploads the backups of a single local dir and deletes the old ones on the remote
+func pushLocalDir(sftpc *sftp.Client, prfl profile.Profile, pushDir profile.BackupPath, log *slog.Logger) error {
+	log.Info("pushing local directory", "dir", pushDir.Path)
+	err := pushLocalBackups(sftpc, pushDir.Path, pushDir.Name, prfl.Destination.Path, log)
+	if err != nil {
+		return err
 	}
 
+	if prfl.Destination.Keep <= 0 {
+		log.Info("skipping deleting older backups because", "name", prfl.Name)
+		return nil
+	}
+	// delete old backup files
+	log.Info("Deleting older remote backups for profile", "name", pushDir.Name)
+	err = expurgeRemoteDir(sftpc, prfl.Destination.Path, prfl.Destination.Keep, pushDir.Name, log)
+	if err != nil {
+		return fmt.Errorf("error expurging old backup files: %w", err)
+	}
 	return nil
 }
 
@@ -541,17 +1013,63 @@ func prepareDestination(dest string) error {
 	return nil
 }
 
-// delZipAndErr deletes the incomplete zip file in case onf an error, and returns the error
-// if the delete operation fails a new error is created that states both problems
+// delZipAndErr deletes the incomplete zip file, or all its volumes if it was split, in case onf an error,
+// and returns the error; if the delete operation fails a new error is created that states both problems
 func delZipAndErr(dest string, err error) error {
-	//try to delete the temp zip file
-	e := os.Remove(dest)
-	if e != nil {
+	//try to delete the temp zip file, it does not exist if the writer already discarded it
+	e := removeZip(dest)
+	if e != nil && !errors.Is(e, os.ErrNotExist) {
 		return fmt.Errorf("unable to delete incomplete zip file due to: %v while handling error: %v", e, err)
 	}
 	return err
 }
 
+// removeZip deletes a zip file or all the volumes of a split zip file
+func removeZip(dest string) error {
+	files, err := zip.Volumes(dest)
+	if err != nil {
+		files = []string{dest}
+	}
+	for _, file := range files {
+		err = os.Remove(file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDestinationPerms changes the owner and mode of the zip file, or of all its volumes if it was split,
+// as defined in the profile destination
+func setDestinationPerms(destZip string, dest profile.Destination) error {
+	if dest.Owner == "" && dest.Mode == "" {
+		return nil
+	}
+	files, err := zip.Volumes(destZip)
+	if err != nil {
+		return fmt.Errorf("unable to find zip file: %v", err)
+	}
+
+	for _, file := range files {
+		// change file ownership
+		if dest.Owner != "" {
+			err := chown(file, dest.Owner)
+			if err != nil {
+				return fmt.Errorf("unable to change owner of file: \"%s\", %v", file, err)
+			}
+		}
+
+		// change file mode
+		if dest.Mode != "" {
+			err := chmod(file, dest.Mode)
+			if err != nil {
+				return fmt.Errorf("unable to change perm of file: \"%s\", %v", file, err)
+			}
+		}
+	}
+	return nil
+}
+
 func chown(file string, owner string) error {
 	usr, err := user.Lookup(owner)
 	if err != nil {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/AndresBott/goback/app/logger"
 	"github.com/AndresBott/goback/internal/profile"
+	gozip "github.com/AndresBott/goback/lib/zip"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"os"
@@ -51,7 +52,9 @@ func TestBackupLocal(t *testing.T) {
 				},
 			},
 			expectedFiles: []string{
+				"dir1/",
 				"dir1/file.json",
+				"dir1/subdir1/",
 				"dir1/subdir1/subfile.log",
 				"dir1/subdir1/subfile1.txt",
 			},
@@ -73,9 +76,12 @@ func TestBackupLocal(t *testing.T) {
 				},
 			},
 			expectedFiles: []string{
+				"dir1/",
 				"dir1/file.json",
+				"dir1/subdir1/",
 				"dir1/subdir1/subfile.log",
 				"dir1/subdir1/subfile1.txt",
+				"dir2/",
 				"dir2/.hidden",
 				"dir2/file.yaml",
 			},
@@ -101,7 +107,9 @@ func TestBackupLocal(t *testing.T) {
 				},
 			},
 			expectedFiles: []string{
+				"dir1/",
 				"dir1/file.json",
+				"dir1/subdir1/",
 				"dir1/subdir1/subfile.log",
 				"dir1/subdir1/subfile1.txt",
 				"_mysqldump/mydb.dump.sql",
@@ -143,9 +151,13 @@ func TestBackupLocal(t *testing.T) {
 					},
 				},
 			},
+			// * matches the empty string, so the dir is excluded with its content
 			expectedFiles: []string{
+				"files/",
+				"files/dir2/",
 				"files/dir2/.hidden",
 				"files/dir2/file.yaml",
+				"files/notRoot/",
 				"files/notRoot/link",
 			},
 		},
@@ -167,7 +179,7 @@ func TestBackupLocal(t *testing.T) {
 			zipFile := filepath.Join(tmpDir, "test.zip")
 			tc.profile.Destination.Path = tmpDir
 
-			err := backupLocal(tc.profile, zipFile, logger.SilentLogger())
+			err := backupLocal(tc.profile, zipFile, nil, logger.SilentLogger())
 
 			if tc.expectedErr == "" {
 				if err != nil {
@@ -200,6 +212,64 @@ func TestBackupLocal(t *testing.T) {
 	}
 }
 
+func TestRunLocalProfileSplit(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// an older split backup that should be expurged as a whole
+	for _, f := range []string{
+		"bla_2006_02_05-17:04:05_backup.zip.001",
+		"bla_2006_02_05-17:04:05_backup.zip.002",
+	} {
+		if err := os.WriteFile(filepath.Join(tmpDir, f), []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prfl := profile.Profile{
+		Name: "bla",
+		Type: profile.TypeLocal,
+		Dirs: []profile.BackupPath{
+			{Path: "sampledata/files/dir1"},
+		},
+		Destination: profile.Destination{
+			Path:      tmpDir,
+			Keep:      1,
+			SplitSize: 512,
+		},
+	}
+	err := runLocalProfile(prfl, &RunResult{}, logger.SilentLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 2 {
+		t.Fatalf("expected the backup to be split in several volumes, got %d files", len(entries))
+	}
+	zipName := gozip.VolumeBase(entries[0].Name())
+	for i, e := range entries {
+		if want := gozip.VolumeName(zipName, i+1); e.Name() != want {
+			t.Errorf("unexpected file %s, expected %s", e.Name(), want)
+		}
+	}
+
+	got, err := gozip.ListFiles(filepath.Join(tmpDir, zipName))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"dir1/file.json",
+		"dir1/subdir1/subfile.log",
+		"dir1/subdir1/subfile1.txt",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
 type sshContainer struct {
 	testcontainers.Container
 	host string
@@ -281,6 +351,8 @@ func TestBackupRemote(t *testing.T) {
 				},
 			},
 			expectedFiles: []string{
+				"dir1/",
+				"dir1/subdir1/",
 				"dir1/subdir1/subfile.log",
 				"dir1/subdir1/subfile1.txt",
 				"dir1/file.json",
@@ -307,8 +379,11 @@ func TestBackupRemote(t *testing.T) {
 				},
 			},
 			expectedFiles: []string{
+				"dir1/",
+				"dir1/subdir1/",
 				"dir1/subdir1/subfile1.txt",
 				"dir1/file.json",
+				"dir2/",
 				"dir2/file.yaml",
 				"dir2/.hidden",
 			},
@@ -360,7 +435,7 @@ func TestBackupRemote(t *testing.T) {
 			}
 			ignoreHostKey = true // ignore for tests only
 
-			err = backupRemote(tc.profile, zipFile, logger.SilentLogger())
+			err = backupRemote(tc.profile, zipFile, nil, logger.SilentLogger())
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
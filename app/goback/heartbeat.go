@@ -0,0 +1,164 @@
+package goback
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndresBott/goback/internal/profile"
+)
+
+const (
+	// heartbeatLogLines is the number of log lines sent in the completion ping
+	heartbeatLogLines = 50
+	// heartbeatLineLength truncates long log lines, monitors limit the size of the ping body
+	heartbeatLineLength = 500
+)
+
+// heartbeat pings a dead man's switch monitor when a run starts and ends, the last log lines of the run
+// are sent with the completion ping
+type heartbeat struct {
+	cfg  profile.Heartbeat
+	name string
+	tail *logTail
+	log  *slog.Logger
+}
+
+// startHeartbeat pings the start url and returns a logger that also records the log lines of the run,
+// the heartbeat is nil and the logger unchanged if no heartbeat url is set
+func startHeartbeat(cfg profile.Heartbeat, name string, log *slog.Logger) (*heartbeat, *slog.Logger) {
+	if !cfg.HasValues() {
+		return nil, log
+	}
+	hb := &heartbeat{
+		cfg:  cfg,
+		name: name,
+		tail: &logTail{max: heartbeatLogLines},
+		log:  log,
+	}
+	if cfg.Start != "" {
+		host, _ := os.Hostname()
+		hb.ping(cfg.Start, fmt.Sprintf("goback %s started on %s\n", name, host))
+	}
+	tailHandler := slog.NewTextHandler(hb.tail, &slog.HandlerOptions{Level: slog.LevelInfo})
+	return hb, slog.New(teeHandler{log.Handler(), tailHandler})
+}
+
+// finish pings the success or fail url with the duration, the errors and the log excerpt of the run
+func (hb *heartbeat) finish(d time.Duration, err error) {
+	if hb == nil {
+		return
+	}
+	url := hb.cfg.Success
+	if err != nil {
+		url = hb.cfg.Fail
+	}
+	if url == "" {
+		return
+	}
+	hb.ping(url, heartbeatBody(hb.name, d, err, hb.tail.String()))
+}
+
+func (hb *heartbeat) ping(url, body string) {
+	err := post(profile.Webhook{Url: url}, "text/plain; charset=utf-8", []byte(body))
+	if err != nil {
+		hb.log.Error("Error while sending heartbeat", "err", err)
+	}
+}
+
+// heartbeatBody returns the body of the completion ping
+func heartbeatBody(name string, d time.Duration, err error, logs string) string {
+	host, _ := os.Hostname()
+	var sb strings.Builder
+	if err == nil {
+		_, _ = fmt.Fprintf(&sb, "goback %s succeeded on %s in %s\n", name, host, roundDuration(d))
+	} else {
+		_, _ = fmt.Fprintf(&sb, "goback %s failed on %s after %s\n", name, host, roundDuration(d))
+		for _, msg := range errorChain(err) {
+			_, _ = fmt.Fprintf(&sb, "  - %s\n", msg)
+		}
+	}
+	if logs != "" {
+		sb.WriteString("\nlast log lines:\n")
+		sb.WriteString(logs)
+	}
+	return sb.String()
+}
+
+// logTail is a writer that keeps the last lines written to it
+type logTail struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if len(line) > heartbeatLineLength {
+			line = line[:heartbeatLineLength] + "..."
+		}
+		t.lines = append(t.lines, line)
+	}
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept lines, every line ends with a newline
+func (t *logTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var buf bytes.Buffer
+	for _, line := range t.lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// teeHandler sends the log records to several handlers
+type teeHandler []slog.Handler
+
+func (h teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, hh := range h {
+		if hh.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs error
+	for _, hh := range h {
+		if hh.Enabled(ctx, r.Level) {
+			errs = errors.Join(errs, hh.Handle(ctx, r.Clone()))
+		}
+	}
+	return errs
+}
+
+func (h teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(h))
+	for i, hh := range h {
+		out[i] = hh.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (h teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(h))
+	for i, hh := range h {
+		out[i] = hh.WithGroup(name)
+	}
+	return out
+}
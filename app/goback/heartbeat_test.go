@@ -0,0 +1,100 @@
+package goback
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunWithNotifyHeartbeat(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	tcs := []struct {
+		name      string
+		runErr    error
+		noStart   bool
+		noFail    bool
+		wantPaths []string
+		wantBody  []string
+	}{
+		{
+			name:      "ping start and success",
+			wantPaths: []string{"/start", "/ok"},
+			wantBody:  []string{"goback profile bla succeeded on", "last log lines:", `msg="copying files" dir=/data`},
+		},
+		{
+			name:      "ping start and fail",
+			runErr:    errors.Join(errors.New("disk full"), errors.New("permission denied")),
+			wantPaths: []string{"/start", "/fail"},
+			wantBody:  []string{"goback profile bla failed on", "  - disk full\n  - permission denied\n", `msg="copying files"`},
+		},
+		{
+			name:      "only the configured urls are pinged",
+			runErr:    errors.New("failed"),
+			noStart:   true,
+			noFail:    true,
+			wantPaths: nil,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ws := newWebhookServer(t)
+			hb := profile.Heartbeat{Start: ws.URL + "/start", Success: ws.URL + "/ok", Fail: ws.URL + "/fail"}
+			if tc.noStart {
+				hb.Start = ""
+			}
+			if tc.noFail {
+				hb.Fail = ""
+			}
+			prfl := profile.Profile{Name: "bla", Notify: profile.Notify{Heartbeat: hb}}
+			res := RunResult{Profile: prfl.Name, Start: time.Now()}
+			err := RunWithNotify(prfl, &res, logger.SilentLogger(), func(_ profile.Profile, _ *RunResult, log *slog.Logger) error {
+				log.Debug("not part of the excerpt")
+				log.Info("copying files", "dir", "/data")
+				return tc.runErr
+			})
+			if !errors.Is(err, tc.runErr) {
+				t.Errorf("expected the run error, got: %v", err)
+			}
+
+			var paths []string
+			for _, r := range ws.requests {
+				paths = append(paths, r.URL.Path)
+			}
+			if diff := cmp.Diff(tc.wantPaths, paths); diff != "" {
+				t.Fatalf("pinged urls mismatch (-want +got):\n%s", diff)
+			}
+			if len(ws.bodies) == 0 {
+				return
+			}
+			body := string(ws.bodies[len(ws.bodies)-1])
+			for _, want := range tc.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("expected body to contain %q, got:\n%s", want, body)
+				}
+			}
+			if strings.Contains(body, "not part of the excerpt") {
+				t.Errorf("debug lines should not be part of the body, got:\n%s", body)
+			}
+		})
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	tail := &logTail{max: 3}
+	for i := 1; i <= 5; i++ {
+		_, _ = fmt.Fprintf(tail, "line %d\n", i)
+	}
+	_, _ = tail.Write([]byte(strings.Repeat("a", heartbeatLineLength+10) + "\n"))
+
+	want := "line 4\nline 5\n" + strings.Repeat("a", heartbeatLineLength) + "...\n"
+	if diff := cmp.Diff(want, tail.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,385 @@
+package goback
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/lock"
+)
+
+// HistoryEntry is a run of a profile as stored in the history file
+type HistoryEntry struct {
+	Profile string    `json:"profile"`
+	Type    string    `json:"type"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// Status is success, failure or skipped
+	Status  string `json:"status"`
+	Archive string `json:"archive,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+	Files   int    `json:"files,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newHistoryEntry(res RunResult) HistoryEntry {
+	e := HistoryEntry{
+		Profile: res.Profile,
+		Type:    string(res.Type),
+		Start:   res.Start,
+		End:     res.Start.Add(res.Duration),
+		Status:  res.status(),
+		Archive: res.Archive,
+		Bytes:   res.Size,
+		Files:   res.Files,
+	}
+	if res.Err != nil {
+		e.Error = res.Err.Error()
+	}
+	return e
+}
+
+// defaultHistoryEntries is the number of runs kept per profile if History.MaxEntries is not set
+const defaultHistoryEntries = 1000
+
+// historyPollInterval is the time between attempts to take the lock of the history file
+var historyPollInterval = 100 * time.Millisecond
+
+// History appends the result of every run as JSON line to a file
+type History struct {
+	File string
+	// MaxEntries is the number of runs kept per profile, older runs are removed; 1000 if not set
+	MaxEntries int
+}
+
+// DefaultHistoryFile returns the location of the run history in the user state dir
+func DefaultHistoryFile() string {
+	return stateFile("history.jsonl")
+}
+
+// Record appends the result of a run to the history and removes the oldest runs of profiles with more than
+// MaxEntries runs; the history file is locked while it is written, so that concurrent runs don't lose entries
+func (h *History) Record(res RunResult) error {
+	data, err := json.Marshal(newHistoryEntry(res))
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	err = os.MkdirAll(filepath.Dir(h.File), 0700)
+	if err != nil {
+		return fmt.Errorf("unable to create history dir: %v", err)
+	}
+	l, err := lockHistory(h.File)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Release()
+	}()
+
+	f, err := os.OpenFile(h.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open history file: %v", err)
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to write history file: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("unable to write history file: %v", err)
+	}
+	return h.trim()
+}
+
+// lockHistory takes the lock of the history file, waiting while another run holds it
+func lockHistory(file string) (*lock.Lock, error) {
+	for {
+		l, err := lock.Acquire(file + ".lock")
+		if !errors.Is(err, lock.ErrLocked) {
+			return l, err
+		}
+		time.Sleep(historyPollInterval)
+	}
+}
+
+// trim rewrites the history with the last MaxEntries runs of every profile if a profile has more,
+// the file is replaced with a rename so that an interrupted write never loses the history
+func (h *History) trim() error {
+	maxEntries := h.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultHistoryEntries
+	}
+	entries, err := ReadHistory(h.File)
+	if err != nil {
+		return err
+	}
+	count := map[string]int{}
+	for _, e := range entries {
+		count[e.Profile]++
+	}
+	trim := false
+	for _, n := range count {
+		if n > maxEntries {
+			trim = true
+		}
+	}
+	if !trim {
+		return nil
+	}
+
+	var data []byte
+	for _, e := range entries {
+		if count[e.Profile] > maxEntries {
+			count[e.Profile]--
+			continue
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	tmp := h.File + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to write history file: %v", err)
+	}
+	err = os.Rename(tmp, h.File)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("unable to write history file: %v", err)
+	}
+	return nil
+}
+
+// ReadHistory returns the entries of a history file, lines that cannot be parsed are skipped;
+// a missing file is an empty history
+func ReadHistory(file string) ([]HistoryEntry, error) {
+	// #nosec G304 -- the history file is defined by the user
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read history file: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := HistoryEntry{}
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Profile == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read history file: %v", err)
+	}
+	return entries, nil
+}
+
+// ProfileStatus summarises the history and the backups of a profile
+type ProfileStatus struct {
+	Profile     string
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   string
+	// LastFailed is set if the last run, not counting skipped ones, failed
+	LastFailed bool
+	// NewestBackup is the time of the newest backup in the destination, zero if there is none or the
+	// destination is not local
+	NewestBackup time.Time
+	// HasBackups is set if the profile keeps backups in a local destination
+	HasBackups bool
+	StaleAfter time.Duration
+	// Stale is set if the newest backup is older than StaleAfter
+	Stale bool
+}
+
+// ProfileStatuses returns the status of the profiles; if no profiles are passed, the status of every profile
+// in the history is returned, without the backups found in the destination
+func ProfileStatuses(profiles []profile.Profile, entries []HistoryEntry, now time.Time) []ProfileStatus {
+	byName := map[string]*ProfileStatus{}
+	var statuses []*ProfileStatus
+	add := func(name string) *ProfileStatus {
+		s := &ProfileStatus{Profile: name}
+		byName[name] = s
+		statuses = append(statuses, s)
+		return s
+	}
+
+	for _, prfl := range profiles {
+		if _, ok := byName[prfl.Name]; ok {
+			continue
+		}
+		s := add(prfl.Name)
+		s.StaleAfter = prfl.StaleAfter
+		if prfl.Type != profile.TypeSftpPush {
+			s.HasBackups = true
+			s.NewestBackup = newestBackup(prfl)
+		}
+	}
+
+	// entries are in the order they were recorded
+	for _, e := range entries {
+		s, ok := byName[e.Profile]
+		if !ok {
+			if len(profiles) > 0 {
+				continue
+			}
+			s = add(e.Profile)
+		}
+		switch e.Status {
+		case "success":
+			s.LastSuccess = e.End
+			s.LastFailed = false
+		case "failure":
+			s.LastFailure = e.End
+			s.LastError = e.Error
+			s.LastFailed = true
+		}
+	}
+
+	out := make([]ProfileStatus, 0, len(statuses))
+	for _, s := range statuses {
+		if s.StaleAfter > 0 {
+			// profiles without local backups are judged by their last successful run
+			last := s.LastSuccess
+			if s.HasBackups {
+				last = s.NewestBackup
+			}
+			s.Stale = last.IsZero() || now.Sub(last) > s.StaleAfter
+		}
+		out = append(out, *s)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Profile < out[j].Profile
+	})
+	return out
+}
+
+// newestBackup returns the time of the newest backup of the profile in the destination, sftpsync profiles
+// keep the backups of several remote profiles, the oldest of their newest backups is returned
+func newestBackup(prfl profile.Profile) time.Time {
+	names := []string{prfl.Name}
+	if prfl.Type == profile.TypeSftpSync {
+		names = nil
+		for _, d := range prfl.Dirs {
+			names = append(names, d.Name)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(prfl.Destination.Path, "*.zip*"))
+	if err != nil || len(files) == 0 {
+		return time.Time{}
+	}
+
+	var oldest time.Time
+	for _, name := range names {
+		g, err := backupGlob(name)
+		if err != nil {
+			return time.Time{}
+		}
+		var newest time.Time
+		for _, f := range files {
+			if !g.Match(filepath.Base(f)) {
+				continue
+			}
+			// backup names hold the local time of the run
+			t := extractTime(filepath.Base(f))
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+			if t.After(newest) {
+				newest = t
+			}
+		}
+		if newest.IsZero() {
+			return time.Time{}
+		}
+		if oldest.IsZero() || newest.Before(oldest) {
+			oldest = newest
+		}
+	}
+	return oldest
+}
+
+// WriteStatus writes the statuses as table, followed by the errors of the last failed runs
+func WriteStatus(w io.Writer, statuses []ProfileStatus, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PROFILE\tLAST SUCCESS\tLAST FAILURE\tNEWEST BACKUP\tSTATUS")
+	for _, s := range statuses {
+		newest := "-"
+		if s.HasBackups {
+			newest = timeAgo(s.NewestBackup, now)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Profile, timeAgo(s.LastSuccess, now), timeAgo(s.LastFailure, now),
+			newest, s.state())
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		if s.LastFailed && s.LastError != "" {
+			_, err = fmt.Fprintf(w, "\n%s failed: %s\n", s.Profile, s.LastError)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// state returns the status column of the profile
+func (s ProfileStatus) state() string {
+	var states []string
+	if s.Stale {
+		// e.g. 26h instead of 26h0m0s
+		threshold := strings.TrimSuffix(strings.TrimSuffix(s.StaleAfter.String(), "0s"), "0m")
+		states = append(states, fmt.Sprintf("STALE (older than %s)", threshold))
+	}
+	if s.LastFailed {
+		states = append(states, "FAILED")
+	}
+	if len(states) == 0 {
+		return "ok"
+	}
+	return strings.Join(states, ", ")
+}
+
+// timeAgo formats a time with its age, e.g. "2024-03-10 02:30 (3h ago)"
+func timeAgo(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	age := now.Sub(t)
+	var ago string
+	switch {
+	case age < time.Minute:
+		ago = "just now"
+	case age < time.Hour:
+		ago = fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 48*time.Hour:
+		ago = fmt.Sprintf("%dh ago", int(age.Hours()))
+	default:
+		ago = fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+	return fmt.Sprintf("%s (%s)", t.Local().Format("2006-01-02 15:04"), ago)
+}
@@ -0,0 +1,256 @@
+package goback
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state", "history.jsonl")
+	h := History{File: file}
+	start := time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC)
+
+	runs := []RunResult{
+		{Profile: "web", Type: profile.TypeLocal, Start: start, Duration: time.Minute, Archive: "/backups/web.zip", Size: 2048, Files: 12},
+		{Profile: "web", Type: profile.TypeLocal, Start: start.Add(time.Hour), Duration: time.Second, Err: errors.New("disk full")},
+		{Profile: "db", Type: profile.TypeRemote, Start: start, Skipped: true},
+	}
+	for _, res := range runs {
+		if err := h.Record(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// lines that cannot be parsed, e.g. of an interrupted write, are skipped
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"profile":"broken"` + "\n")
+	_ = f.Close()
+
+	got, err := ReadHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HistoryEntry{
+		{Profile: "web", Type: "local", Start: start, End: start.Add(time.Minute), Status: "success", Archive: "/backups/web.zip", Bytes: 2048, Files: 12},
+		{Profile: "web", Type: "local", Start: start.Add(time.Hour), End: start.Add(time.Hour + time.Second), Status: "failure", Error: "disk full"},
+		{Profile: "db", Type: "remote", Start: start, End: start, Status: "skipped"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = ReadHistory(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil || got != nil {
+		t.Errorf("expected an empty history, got %v, %v", got, err)
+	}
+}
+
+func TestHistoryMaxEntries(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	h := History{File: file, MaxEntries: 2}
+	start := time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC)
+
+	for i, name := range []string{"web", "db", "web", "web", "db", "web"} {
+		err := h.Record(RunResult{Profile: name, Type: profile.TypeLocal, Start: start.Add(time.Duration(i) * time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ReadHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%s %d", e.Profile, e.Start.Hour()))
+	}
+	// the last 2 runs of every profile are kept in the order they were recorded
+	want := []string{"db 3", "web 5", "db 6", "web 7"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+	matches, err := filepath.Glob(file + ".*")
+	if err != nil || len(matches) != 0 {
+		t.Errorf("expected no lock or temp files left, got %v", matches)
+	}
+}
+
+func TestProfileStatuses(t *testing.T) {
+	now := time.Date(2024, 3, 12, 12, 0, 0, 0, time.Local)
+	dest := t.TempDir()
+	backup := func(name string, t time.Time, suffix string) string {
+		return name + "_" + t.Format(dateStr) + "_backup.zip" + suffix
+	}
+	for _, f := range []string{
+		backup("web", time.Date(2024, 3, 12, 2, 30, 0, 0, time.Local), ""),
+		backup("web", time.Date(2024, 3, 11, 2, 30, 0, 0, time.Local), ""),
+		backup("db", time.Date(2024, 3, 9, 2, 30, 0, 0, time.Local), ".001"),
+		backup("db", time.Date(2024, 3, 9, 2, 30, 0, 0, time.Local), ".002"),
+		backup("pulled", time.Date(2024, 3, 12, 1, 0, 0, 0, time.Local), ""),
+	} {
+		if err := os.WriteFile(filepath.Join(dest, f), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	profiles := []profile.Profile{
+		{Name: "web", Type: profile.TypeLocal, StaleAfter: 26 * time.Hour, Destination: profile.Destination{Path: dest}},
+		{Name: "db", Type: profile.TypeRemote, StaleAfter: 26 * time.Hour, Destination: profile.Destination{Path: dest}},
+		{Name: "sync", Type: profile.TypeSftpSync, Dirs: []profile.BackupPath{{Name: "pulled"}}, Destination: profile.Destination{Path: dest}},
+		{Name: "push", Type: profile.TypeSftpPush, StaleAfter: time.Hour, Destination: profile.Destination{Path: "/remote"}},
+	}
+	entries := []HistoryEntry{
+		{Profile: "web", Status: "failure", End: now.Add(-36 * time.Hour), Error: "disk full"},
+		{Profile: "web", Status: "success", End: now.Add(-9 * time.Hour)},
+		{Profile: "db", Status: "success", End: now.Add(-3 * 24 * time.Hour)},
+		{Profile: "db", Status: "failure", End: now.Add(-10 * time.Hour), Error: "connection refused"},
+		{Profile: "db", Status: "skipped", End: now.Add(-time.Hour)},
+		{Profile: "push", Status: "success", End: now.Add(-2 * time.Hour)},
+		{Profile: "removed", Status: "success", End: now.Add(-time.Hour)},
+	}
+
+	t.Run("profiles with destination", func(t *testing.T) {
+		got := ProfileStatuses(profiles, entries, now)
+		want := []ProfileStatus{
+			{
+				Profile: "db", LastSuccess: now.Add(-3 * 24 * time.Hour), LastFailure: now.Add(-10 * time.Hour),
+				LastError: "connection refused", LastFailed: true, HasBackups: true,
+				NewestBackup: time.Date(2024, 3, 9, 2, 30, 0, 0, time.Local), StaleAfter: 26 * time.Hour, Stale: true,
+			},
+			{Profile: "push", LastSuccess: now.Add(-2 * time.Hour), StaleAfter: time.Hour, Stale: true},
+			{Profile: "sync", HasBackups: true, NewestBackup: time.Date(2024, 3, 12, 1, 0, 0, 0, time.Local)},
+			{
+				Profile: "web", LastSuccess: now.Add(-9 * time.Hour), LastFailure: now.Add(-36 * time.Hour),
+				LastError: "disk full", HasBackups: true,
+				NewestBackup: time.Date(2024, 3, 12, 2, 30, 0, 0, time.Local), StaleAfter: 26 * time.Hour,
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("history only", func(t *testing.T) {
+		var got []string
+		for _, s := range ProfileStatuses(nil, entries, now) {
+			got = append(got, s.Profile)
+		}
+		if diff := cmp.Diff([]string{"db", "push", "removed", "web"}, got); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("write status table", func(t *testing.T) {
+		var sb strings.Builder
+		err := WriteStatus(&sb, ProfileStatuses(profiles, entries, now), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `PROFILE  LAST SUCCESS               LAST FAILURE                NEWEST BACKUP               STATUS
+db       2024-03-09 12:00 (3d ago)  2024-03-12 02:00 (10h ago)  2024-03-09 02:30 (3d ago)   STALE (older than 26h), FAILED
+push     2024-03-12 10:00 (2h ago)  never                       -                           STALE (older than 1h)
+sync     never                      never                       2024-03-12 01:00 (11h ago)  ok
+web      2024-03-12 03:00 (9h ago)  2024-03-11 00:00 (36h ago)  2024-03-12 02:30 (9h ago)   ok
+
+db failed: connection refused
+`
+		if diff := cmp.Diff(want, sb.String()); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestDefaultHistoryFile(t *testing.T) {
+	// the history of the real cache dir is never moved
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	t.Run("xdg state home", func(t *testing.T) {
+		state := t.TempDir()
+		t.Setenv("XDG_STATE_HOME", state)
+		want := filepath.Join(state, "goback", "history.jsonl")
+		if got := DefaultHistoryFile(); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+
+	t.Run("relative xdg state home is ignored", func(t *testing.T) {
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		t.Setenv("XDG_STATE_HOME", "state")
+		want := filepath.Join(home, ".local", "state", "goback", "history.jsonl")
+		if got := DefaultHistoryFile(); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+
+	t.Run("history of the cache dir is not moved", func(t *testing.T) {
+		state, cache := t.TempDir(), t.TempDir()
+		t.Setenv("XDG_STATE_HOME", state)
+		t.Setenv("XDG_CACHE_HOME", cache)
+		if err := os.MkdirAll(filepath.Join(cache, "goback"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(cache, "goback", "history.jsonl"), []byte("{}\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		DefaultHistoryFile()
+		if _, err := os.Stat(filepath.Join(cache, "goback", "history.jsonl")); err != nil {
+			t.Errorf("expected the history to stay in the cache dir: %v", err)
+		}
+	})
+}
+
+func TestMigrateState(t *testing.T) {
+	state, cache := t.TempDir(), t.TempDir()
+	t.Setenv("XDG_STATE_HOME", state)
+	t.Setenv("XDG_CACHE_HOME", cache)
+	files := map[string]string{
+		filepath.Join(cache, "goback", "history.jsonl"):     "old history\n",
+		filepath.Join(cache, "goback", "daemon-state.json"): "old state\n",
+		filepath.Join(state, "goback", "daemon-state.json"): "new state\n",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	MigrateState(logger.SilentLogger())
+
+	// the history is moved, the daemon state already in the state dir is kept
+	want := map[string]string{
+		DefaultHistoryFile(): "old history\n",
+		DefaultStateFile():   "new state\n",
+		filepath.Join(cache, "goback", "daemon-state.json"): "old state\n",
+	}
+	got := map[string]string{}
+	for file := range want {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[file] = string(data)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(cache, "goback", "history.jsonl")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the old history to be removed, got: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package goback
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/lock"
+)
+
+// LockMode defines what happens when a profile or its destination is locked by another run
+type LockMode int
+
+const (
+	// LockFail fails the profile
+	LockFail LockMode = iota
+	// LockWait waits until the lock is released
+	LockWait
+	// LockSkip skips the profile without an error
+	LockSkip
+)
+
+// destLockFile is the name of the lock file created in the destination directory
+const destLockFile = ".goback.lock"
+
+// lockPollInterval is the time between attempts to take a lock in LockWait mode
+var lockPollInterval = 5 * time.Second
+
+// errSkipLocked is returned by lockProfile when the profile is skipped because of a lock
+var errSkipLocked = errors.New("profile is locked by another run")
+
+// DefaultLockDir returns the location of the profile lock files
+func DefaultLockDir() string {
+	return filepath.Join(cacheDir(), "locks")
+}
+
+// cacheDir returns the directory goback keeps its runtime files in
+func cacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "goback")
+}
+
+// stateDir returns the directory goback keeps the files in that must survive a cache cleanup, like the run
+// history: $XDG_STATE_HOME/goback or ~/.local/state/goback; relative values are ignored as the XDG spec demands
+func stateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if !filepath.IsAbs(dir) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(os.TempDir(), "goback")
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "goback")
+}
+
+// stateFile returns the location of a file in the state dir
+func stateFile(name string) string {
+	return filepath.Join(stateDir(), name)
+}
+
+// stateFiles are the files kept in the state dir, older versions kept them in the cache dir
+var stateFiles = []string{"history.jsonl", "daemon-state.json"}
+
+// MigrateState moves the files an older version left in the cache dir into the state dir, so that the history
+// and the last runs are kept; files already present in the state dir are never overwritten
+func MigrateState(log *slog.Logger) {
+	for _, name := range stateFiles {
+		file := stateFile(name)
+		if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		old := filepath.Join(cacheDir(), name)
+		if _, err := os.Stat(old); err != nil {
+			continue
+		}
+		err := os.MkdirAll(filepath.Dir(file), 0700)
+		if err == nil {
+			err = os.Rename(old, file)
+		}
+		if err != nil {
+			log.Warn("unable to move state file out of the cache dir", "file", old, "error", err)
+			continue
+		}
+		log.Info("state file moved out of the cache dir", "from", old, "to", file)
+	}
+}
+
+// lockProfile takes the lock of the profile and, for profiles writing into a local directory, the lock of
+// the destination directory, so that two runs never write into or expurge the same location at the same time.
+// The returned function releases the locks.
+func lockProfile(prfl profile.Profile, lockDir string, mode LockMode, log *slog.Logger) (func(), error) {
+	err := os.MkdirAll(lockDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create lock dir: %v", err)
+	}
+
+	paths := []string{filepath.Join(lockDir, prfl.Name+".lock")}
+	if prfl.Type != profile.TypeSftpPush {
+		err = prepareDestination(prfl.Destination.Path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, filepath.Join(prfl.Destination.Path, destLockFile))
+	}
+
+	var locks []*lock.Lock
+	release := func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			rErr := locks[i].Release()
+			if rErr != nil {
+				log.Warn("unable to release lock", "file", locks[i].Path(), "error", rErr)
+			}
+		}
+	}
+
+	for _, path := range paths {
+		l, err := acquireLock(path, mode, log)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+	return release, nil
+}
+
+// acquireLock takes a single lock file according to the lock mode
+func acquireLock(path string, mode LockMode, log *slog.Logger) (*lock.Lock, error) {
+	logged := false
+	for {
+		l, err := lock.Acquire(path)
+		if err == nil {
+			return l, nil
+		}
+
+		lockErr := &lock.LockedError{}
+		if !errors.As(err, &lockErr) {
+			return nil, err
+		}
+
+		switch mode {
+		case LockSkip:
+			log.Info("skipping profile locked by another run", "file", path, "pid", lockErr.Pid)
+			return nil, errSkipLocked
+		case LockWait:
+			if !logged {
+				log.Info("waiting for lock held by another run", "file", path, "pid", lockErr.Pid)
+				logged = true
+			}
+			time.Sleep(lockPollInterval)
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package goback
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/lock"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLockProfile(t *testing.T) {
+	setup := func(t *testing.T) (profile.Profile, string) {
+		prfl := profile.Profile{
+			Name:        "bla",
+			Type:        profile.TypeLocal,
+			Destination: profile.Destination{Path: filepath.Join(t.TempDir(), "dest")},
+		}
+		return prfl, t.TempDir()
+	}
+
+	t.Run("fail if the profile is locked", func(t *testing.T) {
+		prfl, lockDir := setup(t)
+		release, err := lockProfile(prfl, lockDir, LockFail, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer release()
+
+		_, err = lockProfile(prfl, lockDir, LockFail, logger.SilentLogger())
+		if !errors.Is(err, lock.ErrLocked) {
+			t.Errorf("expected ErrLocked, got: %v", err)
+		}
+	})
+
+	t.Run("fail if the destination is locked by another profile", func(t *testing.T) {
+		prfl, lockDir := setup(t)
+		release, err := lockProfile(prfl, lockDir, LockFail, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer release()
+
+		other := prfl
+		other.Name = "other"
+		_, err = lockProfile(other, lockDir, LockFail, logger.SilentLogger())
+		if !errors.Is(err, lock.ErrLocked) {
+			t.Errorf("expected ErrLocked, got: %v", err)
+		}
+		// the profile lock of the failed attempt is released again
+		if _, err = lock.Acquire(filepath.Join(lockDir, "other.lock")); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("skip if locked", func(t *testing.T) {
+		prfl, lockDir := setup(t)
+		release, err := lockProfile(prfl, lockDir, LockFail, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer release()
+
+		_, err = lockProfile(prfl, lockDir, LockSkip, logger.SilentLogger())
+		if !errors.Is(err, errSkipLocked) {
+			t.Errorf("expected errSkipLocked, got: %v", err)
+		}
+
+		br := BackupRunner{Logger: logger.SilentLogger(), LockMode: LockSkip, LockDir: lockDir}
+		if err = br.RunProfile(prfl); err != nil {
+			t.Errorf("expected skipped profile to not fail, got: %v", err)
+		}
+	})
+
+	t.Run("wait until released", func(t *testing.T) {
+		prfl, lockDir := setup(t)
+		lockPollInterval = 10 * time.Millisecond
+		t.Cleanup(func() {
+			lockPollInterval = 5 * time.Second
+		})
+
+		release, err := lockProfile(prfl, lockDir, LockFail, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			release()
+		}()
+
+		start := time.Now()
+		release2, err := lockProfile(prfl, lockDir, LockWait, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		release2()
+		if time.Since(start) < 50*time.Millisecond {
+			t.Errorf("expected to wait for the lock to be released")
+		}
+	})
+}
+
+func TestRunProfileLockedNotify(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	tcs := []struct {
+		name       string
+		mode       LockMode
+		wantStatus string
+		wantPaths  []string
+	}{
+		{
+			name:       "locked profile fails",
+			mode:       LockFail,
+			wantStatus: "failure",
+			wantPaths:  []string{"/start", "/fail", "/hook"},
+		},
+		{
+			name:       "locked profile is skipped",
+			mode:       LockSkip,
+			wantStatus: "skipped",
+			wantPaths:  []string{"/start", "/ok", "/hook"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ws := newWebhookServer(t)
+			prfl := profile.Profile{
+				Name:        "bla",
+				Type:        profile.TypeLocal,
+				Destination: profile.Destination{Path: filepath.Join(t.TempDir(), "dest")},
+				Notify: profile.Notify{
+					Webhooks:  []profile.Webhook{{Url: ws.URL + "/hook", OnSuccess: true}},
+					Heartbeat: profile.Heartbeat{Start: ws.URL + "/start", Success: ws.URL + "/ok", Fail: ws.URL + "/fail"},
+				},
+			}
+			lockDir := t.TempDir()
+			release, err := lockProfile(prfl, lockDir, LockFail, logger.SilentLogger())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer release()
+
+			br := BackupRunner{Logger: logger.SilentLogger(), LockMode: tc.mode, LockDir: lockDir}
+			_ = br.RunProfile(prfl)
+
+			var paths []string
+			for _, r := range ws.requests {
+				paths = append(paths, r.URL.Path)
+			}
+			if diff := cmp.Diff(tc.wantPaths, paths); diff != "" {
+				t.Fatalf("called urls mismatch (-want +got):\n%s", diff)
+			}
+			payload := webhookPayload{}
+			if err = json.Unmarshal(ws.bodies[len(ws.bodies)-1], &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.Status != tc.wantStatus {
+				t.Errorf("got webhook status %q, want %q", payload.Status, tc.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package goback
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric describes a metric of a profile in the Prometheus text format
+type metric struct {
+	name string
+	typ  string
+	help string
+}
+
+var metricDefs = []metric{
+	{"goback_last_run_timestamp_seconds", "gauge", "Unix time the last run of the profile finished."},
+	{"goback_last_success_timestamp_seconds", "gauge", "Unix time the last successful run of the profile finished."},
+	{"goback_last_run_success", "gauge", "Whether the last run of the profile succeeded (1) or failed (0)."},
+	{"goback_last_duration_seconds", "gauge", "Duration of the last run of the profile in seconds."},
+	{"goback_archive_bytes", "gauge", "Size of the archive created by the last successful run, including all volumes."},
+	{"goback_files_total", "gauge", "Number of files in the archive created by the last successful run."},
+	{"goback_runs_total", "counter", "Number of runs of the profile, skipped runs are not counted."},
+	{"goback_run_failures_total", "counter", "Number of failed runs of the profile."},
+}
+
+// Metrics keeps the Prometheus metrics of the profile runs, if File is set the metrics are persisted in it as
+// node_exporter textfile, so that the runs of every goback process are added up
+type Metrics struct {
+	// File is the textfile written after every run, e.g. /var/lib/node_exporter/textfile_collector/goback.prom
+	File string
+	// LockDir is the location of the lock file of the textfile, DefaultLockDir is used if empty
+	LockDir string
+
+	mu       sync.Mutex
+	profiles map[string]map[string]float64
+}
+
+// Record updates the metrics of the profile with the result of a run, skipped runs are ignored
+func (m *Metrics) Record(res RunResult) error {
+	if res.Skipped {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.File == "" {
+		m.update(res)
+		return nil
+	}
+
+	// other goback processes write the same file
+	lockDir := m.LockDir
+	if lockDir == "" {
+		lockDir = DefaultLockDir()
+	}
+	err := os.MkdirAll(lockDir, 0700)
+	if err != nil {
+		return fmt.Errorf("unable to create lock dir: %v", err)
+	}
+	l, err := acquireLock(filepath.Join(lockDir, "metrics.lock"), LockWait, slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Release()
+	}()
+
+	err = m.load()
+	if err != nil {
+		return err
+	}
+	m.update(res)
+	return m.write()
+}
+
+// Load reads the metrics from the textfile, a missing file is not an error
+func (m *Metrics) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.load()
+}
+
+func (m *Metrics) load() error {
+	// #nosec G304 -- the metrics file is defined by the user
+	f, err := os.Open(m.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read metrics file: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	profiles, err := parseMetrics(f)
+	if err != nil {
+		return fmt.Errorf("unable to read metrics file %s: %v", m.File, err)
+	}
+	m.profiles = profiles
+	return nil
+}
+
+// write replaces the textfile, the content is written into a temp file that is renamed so that
+// node_exporter never reads an incomplete file
+func (m *Metrics) write() error {
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+	// node_exporter only reads *.prom files
+	tmp := m.File + ".tmp"
+	// #nosec G306 -- the metrics are read by node_exporter
+	err := os.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write metrics file: %v", err)
+	}
+	err = os.Rename(tmp, m.File)
+	if err != nil {
+		return fmt.Errorf("unable to write metrics file: %v", err)
+	}
+	return nil
+}
+
+func (m *Metrics) update(res RunResult) {
+	if m.profiles == nil {
+		m.profiles = map[string]map[string]float64{}
+	}
+	p, ok := m.profiles[res.Profile]
+	if !ok {
+		p = map[string]float64{}
+		m.profiles[res.Profile] = p
+	}
+
+	end := float64(res.Start.Add(res.Duration).Unix())
+	p["goback_last_run_timestamp_seconds"] = end
+	p["goback_last_duration_seconds"] = res.Duration.Seconds()
+	p["goback_runs_total"]++
+	// the failure counter is exported from the first run on, so that increases are detected
+	if _, ok := p["goback_run_failures_total"]; !ok {
+		p["goback_run_failures_total"] = 0
+	}
+	if res.Err != nil {
+		p["goback_last_run_success"] = 0
+		p["goback_run_failures_total"]++
+		return
+	}
+	p["goback_last_run_success"] = 1
+	p["goback_last_success_timestamp_seconds"] = end
+	// profiles without archive, e.g. sftpsync, have no archive metrics
+	if res.Archive != "" {
+		p["goback_archive_bytes"] = float64(res.Size)
+		p["goback_files_total"] = float64(res.Files)
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeMetrics writes the metrics of all profiles sorted by name, metrics a profile has no value for are left out
+func (m *Metrics) writeMetrics(w io.Writer) {
+	names := make([]string, 0, len(m.profiles))
+	for name := range m.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, def := range metricDefs {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n", def.name, def.help)
+		_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", def.name, def.typ)
+		for _, name := range names {
+			v, ok := m.profiles[name][def.name]
+			if !ok {
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "%s{profile=\"%s\"} %s\n", def.name, escapeLabel(name), strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+}
+
+// parseMetrics reads the metrics written by writeMetrics, unknown metrics are ignored
+func parseMetrics(r io.Reader) (map[string]map[string]float64, error) {
+	known := map[string]bool{}
+	for _, def := range metricDefs {
+		known[def.name] = true
+	}
+
+	profiles := map[string]map[string]float64{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, rest, ok := strings.Cut(line, `{profile="`)
+		if !ok || !known[name] {
+			continue
+		}
+		i := strings.LastIndex(rest, `"} `)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid metric on line %d", n)
+		}
+		v, err := strconv.ParseFloat(rest[i+3:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric value on line %d", n)
+		}
+		prfl := unescapeLabel(rest[:i])
+		if profiles[prfl] == nil {
+			profiles[prfl] = map[string]float64{}
+		}
+		profiles[prfl][name] = v
+	}
+	return profiles, scanner.Err()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var labelUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n")
+
+// escapeLabel escapes a label value as defined by the Prometheus text format
+func escapeLabel(in string) string {
+	return labelEscaper.Replace(in)
+}
+
+func unescapeLabel(in string) string {
+	return labelUnescaper.Replace(in)
+}
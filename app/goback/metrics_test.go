@@ -0,0 +1,98 @@
+package goback
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetrics(t *testing.T) {
+	start := time.Unix(1710037800, 0)
+	runs := []RunResult{
+		{Profile: "web", Start: start, Duration: 90 * time.Second, Archive: "/backups/web.zip", Size: 2048, Files: 12},
+		{Profile: "web", Start: start.Add(time.Hour), Duration: 1500 * time.Millisecond, Err: errors.New("disk full")},
+		{Profile: `db "main"`, Start: start, Duration: 30 * time.Second},
+		{Profile: "db", Start: start, Skipped: true},
+	}
+
+	want := `# HELP goback_last_run_timestamp_seconds Unix time the last run of the profile finished.
+# TYPE goback_last_run_timestamp_seconds gauge
+goback_last_run_timestamp_seconds{profile="db \"main\""} 1710037830
+goback_last_run_timestamp_seconds{profile="web"} 1710041401
+# HELP goback_last_success_timestamp_seconds Unix time the last successful run of the profile finished.
+# TYPE goback_last_success_timestamp_seconds gauge
+goback_last_success_timestamp_seconds{profile="db \"main\""} 1710037830
+goback_last_success_timestamp_seconds{profile="web"} 1710037890
+# HELP goback_last_run_success Whether the last run of the profile succeeded (1) or failed (0).
+# TYPE goback_last_run_success gauge
+goback_last_run_success{profile="db \"main\""} 1
+goback_last_run_success{profile="web"} 0
+# HELP goback_last_duration_seconds Duration of the last run of the profile in seconds.
+# TYPE goback_last_duration_seconds gauge
+goback_last_duration_seconds{profile="db \"main\""} 30
+goback_last_duration_seconds{profile="web"} 1.5
+# HELP goback_archive_bytes Size of the archive created by the last successful run, including all volumes.
+# TYPE goback_archive_bytes gauge
+goback_archive_bytes{profile="web"} 2048
+# HELP goback_files_total Number of files in the archive created by the last successful run.
+# TYPE goback_files_total gauge
+goback_files_total{profile="web"} 12
+# HELP goback_runs_total Number of runs of the profile, skipped runs are not counted.
+# TYPE goback_runs_total counter
+goback_runs_total{profile="db \"main\""} 1
+goback_runs_total{profile="web"} 2
+# HELP goback_run_failures_total Number of failed runs of the profile.
+# TYPE goback_run_failures_total counter
+goback_run_failures_total{profile="db \"main\""} 0
+goback_run_failures_total{profile="web"} 1
+`
+
+	t.Run("serve recorded runs", func(t *testing.T) {
+		m := &Metrics{}
+		for _, res := range runs {
+			if err := m.Record(res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if diff := cmp.Diff(want, rec.Body.String()); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+			t.Errorf("unexpected content type: %s", got)
+		}
+	})
+
+	t.Run("textfile is shared between processes", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "goback.prom")
+		lockDir := t.TempDir()
+		// every run is recorded by a different instance, like separate goback processes
+		for _, res := range runs {
+			m := &Metrics{File: file, LockDir: lockDir}
+			if err := m.Record(res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+
+		m := &Metrics{File: file}
+		if err := m.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(2.0, m.profiles["web"]["goback_runs_total"]); diff != "" {
+			t.Errorf("loaded runs mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -1,56 +1,397 @@
 package goback
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/AndresBott/goback/internal/profile"
+	htmlTemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"os"
 	"strings"
+	textTemplate "text/template"
 	"time"
+
+	"github.com/AndresBott/goback/internal/profile"
 )
 
-func NotifySuccess(cfg profile.EmailNotify, prfName string) error {
-	var s strings.Builder
+const smtpTimeout = 2 * time.Minute
+
+const defaultSubject = `Goback {{if .Success}}Success{{else}}Failure{{end}} Notification`
+
+const defaultBody = `{{if .Success}}✅ Backup completed successfully.{{else}}❌ Backup failed.{{end}}
+
+Profile: {{.Profile}}
+Host: {{.Host}}
+Time: {{.Time.Format "Mon, 02 Jan 2006 15:04:05 MST"}}
+Duration: {{.Duration}}
+{{- if .Archive}}
+Archive: {{.Archive}}
+Size: {{.Size}}
+Files: {{.Files}}
+{{- end}}
+{{- if not .Success}}
+Error: {{.Error}}
+{{- if gt (len .Errors) 1}}
+{{range .Errors}}
+  - {{.}}
+{{- end}}
+{{- end}}
+{{- end}}
 
-	s.WriteString("Subject: Goback Success Notification\r\n")
-	s.WriteString("\r\n") // Email body starts here
-	s.WriteString("✅ Backup completed successfully.\n\n")
-	s.WriteString(fmt.Sprintf("Profile: %s\n", prfName))
-	s.WriteString(fmt.Sprintf("Time: %s\n", time.Now().Format(time.RFC1123)))
-	s.WriteString("\nEverything went as expected.\n")
+{{if .Success}}Everything went as expected.{{else}}Please investigate the issue.{{end}}
+`
 
-	return send(cfg, []byte(s.String()))
+const defaultHtmlBody = `<html><body>
+<h3>{{if .Success}}✅ Backup completed successfully.{{else}}❌ Backup failed.{{end}}</h3>
+<table>
+<tr><td>Profile</td><td>{{.Profile}}</td></tr>
+<tr><td>Host</td><td>{{.Host}}</td></tr>
+<tr><td>Time</td><td>{{.Time.Format "Mon, 02 Jan 2006 15:04:05 MST"}}</td></tr>
+<tr><td>Duration</td><td>{{.Duration}}</td></tr>
+{{- if .Archive}}
+<tr><td>Archive</td><td>{{.Archive}}</td></tr>
+<tr><td>Size</td><td>{{.Size}}</td></tr>
+<tr><td>Files</td><td>{{.Files}}</td></tr>
+{{- end}}
+</table>
+{{- if not .Success}}
+<p>Errors:</p>
+<ul>
+{{- range .Errors}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+<p>Please investigate the issue.</p>
+{{- end}}
+</body></html>
+`
+
+// emailData is the data available in the email templates
+type emailData struct {
+	Profile string
+	Type    string
+	Host    string
+	Success bool
+	// Status is success, failure or skipped if the profile was locked by another run
+	Status   string
+	Time     time.Time
+	Start    time.Time
+	Duration time.Duration
+	Archive  string
+	Size     profile.ByteSize
+	Files    int
+	Error    string
+	// Errors lists every error of the error chain, errors joined together are listed one by one
+	Errors []string
+}
+
+func newEmailData(res RunResult) emailData {
+	host, _ := os.Hostname()
+	d := emailData{
+		Profile:  res.Profile,
+		Type:     string(res.Type),
+		Host:     host,
+		Success:  res.Err == nil,
+		Status:   res.status(),
+		Time:     time.Now(),
+		Start:    res.Start,
+		Duration: roundDuration(res.Duration),
+		Archive:  res.Archive,
+		Size:     profile.ByteSize(res.Size),
+		Files:    res.Files,
+	}
+	if res.Err != nil {
+		d.Error = res.Err.Error()
+		d.Errors = errorChain(res.Err)
+	}
+	return d
 }
 
-func NotifyFailure(cfg profile.EmailNotify, prfName string, err error) error {
-	var s strings.Builder
+// emailTemplates are the default templates of an email, replaced by the ones in the notify settings
+type emailTemplates struct {
+	subject string
+	body    string
+	html    string
+}
 
-	s.WriteString("Subject: Goback Failure Notification\r\n")
-	s.WriteString("\r\n") // Email body starts here
-	s.WriteString("❌ Backup failed.\n\n")
-	s.WriteString(fmt.Sprintf("Profile: %s\n", prfName))
-	s.WriteString(fmt.Sprintf("Time: %s\n", time.Now().Format(time.RFC1123)))
-	s.WriteString(fmt.Sprintf("Error: %v\n", err))
-	s.WriteString("\nPlease investigate the issue.\n")
+var profileTemplates = emailTemplates{subject: defaultSubject, body: defaultBody, html: defaultHtmlBody}
 
-	return send(cfg, []byte(s.String()))
+// NotifyEmail sends the result of a run per email, using the templates of the profile if set
+func NotifyEmail(cfg profile.EmailNotify, res RunResult) error {
+	data := newEmailData(res)
+	msg, err := emailMessage(cfg, profileTemplates, data, data.Time)
+	if err != nil {
+		return err
+	}
+	return send(cfg, msg)
 }
 
-func send(cfg profile.EmailNotify, body []byte) error {
-	// Authentication.
-	auth := smtp.PlainAuth("", cfg.User, cfg.Password, cfg.Host)
+// emailMessage renders the email, including the headers, with the data passed to the templates
+func emailMessage(cfg profile.EmailNotify, tmpls emailTemplates, data any, date time.Time) ([]byte, error) {
+	subject, err := renderText(cfg.Subject, tmpls.subject, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render email subject: %v", err)
+	}
+	body, err := renderText(cfg.Body, tmpls.body, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render email body: %v", err)
+	}
 
-	// Construct the email headers and body.
-	var msg strings.Builder
-	msg.WriteString(fmt.Sprintf("From: %s\r\n", cfg.From))
-	msg.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(cfg.To, ", ")))
-	msg.WriteString("MIME-version: 1.0;\r\n")
-	msg.WriteString("Content-Type: text/plain; charset=\"UTF-8\";\r\n")
-	msg.Write(body)
+	var msg bytes.Buffer
+	header := func(k, v string) {
+		_, _ = fmt.Fprintf(&msg, "%s: %s\r\n", k, v)
+	}
+	header("From", cfg.From)
+	header("To", strings.Join(cfg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageId(cfg.From))
+	header("MIME-Version", "1.0")
+
+	if !cfg.Html {
+		header("Content-Type", `text/plain; charset="UTF-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		msg.WriteString("\r\n")
+		err = writeQuotedPrintable(&msg, body)
+		if err != nil {
+			return nil, err
+		}
+		return msg.Bytes(), nil
+	}
 
-	// Send the email.
-	err := smtp.SendMail(cfg.Host+":"+cfg.Port, auth, cfg.User, cfg.To, []byte(msg.String()))
+	tmpl := cfg.HtmlBody
+	if tmpl == "" {
+		tmpl = tmpls.html
+	}
+	ht, err := htmlTemplate.New("htmlBody").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse email html template: %v", err)
+	}
+	var html strings.Builder
+	err = ht.Execute(&html, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render email html body: %v", err)
+	}
+
+	var parts bytes.Buffer
+	mw := multipart.NewWriter(&parts)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{`text/plain; charset="UTF-8"`, body},
+		{`text/html; charset="UTF-8"`, html.String()},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = writeQuotedPrintable(w, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	header("Content-Type", fmt.Sprintf(`multipart/alternative; boundary="%s"`, mw.Boundary()))
+	msg.WriteString("\r\n")
+	msg.Write(parts.Bytes())
+	return msg.Bytes(), nil
+}
+
+// renderText executes a text template, def is used if tmpl is empty
+func renderText(tmpl, def string, data any) (string, error) {
+	if tmpl == "" {
+		tmpl = def
+	}
+	t, err := textTemplate.New("").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	err = t.Execute(&sb, data)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// writeQuotedPrintable writes the text with CRLF line endings as quoted-printable
+func writeQuotedPrintable(w io.Writer, text string) error {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\n", "\r\n")
+	qw := quotedprintable.NewWriter(w)
+	_, err := qw.Write([]byte(text))
+	if err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// messageId returns a unique Message-ID in the domain of the sender
+func messageId(from string) string {
+	domain := ""
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndex(addr.Address, "@"); i >= 0 {
+			domain = addr.Address[i+1:]
+		}
+	}
+	if domain == "" {
+		domain, _ = os.Hostname()
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
+
+// send delivers the message to the smtp server; authentication is skipped if no user is set, e.g. for relays
+func send(cfg profile.EmailNotify, msg []byte) error {
+	err := sendMail(cfg, msg)
 	if err != nil {
 		return fmt.Errorf("unable to send email: %v", err)
 	}
 	return nil
 }
+
+func sendMail(cfg profile.EmailNotify, msg []byte) error {
+	tlsCfg, err := smtpTlsConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	security := cfg.Security
+	if security == "" && cfg.Port == "465" {
+		security = profile.SecurityTls
+	}
+
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	if security == profile.SecurityTls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if security == "" || security == profile.SecurityStartTls {
+		ok, _ := c.Extension("STARTTLS")
+		if ok {
+			err = c.StartTLS(tlsCfg)
+			if err != nil {
+				return err
+			}
+		} else if security == profile.SecurityStartTls {
+			return errors.New("server does not support STARTTLS")
+		}
+	}
+
+	if cfg.User != "" {
+		err = c.Auth(smtp.PlainAuth("", cfg.User, cfg.Password, cfg.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(cfg.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// smtpTlsConfig returns the tls config used to verify the server, with the CAs of the CA file if set
+func smtpTlsConfig(cfg profile.EmailNotify) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName: cfg.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.CaFile == "" {
+		return tlsCfg, nil
+	}
+	// #nosec G304 -- the CA file is defined by the user
+	pem, err := os.ReadFile(cfg.CaFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CaFile)
+	}
+	tlsCfg.RootCAs = pool
+	return tlsCfg, nil
+}
+
+// errorChain returns the messages of the errors joined in err, errors wrapping a joined error are expanded
+func errorChain(err error) []string {
+	if err == nil {
+		return nil
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		joined, ok := e.(interface{ Unwrap() []error })
+		if !ok {
+			continue
+		}
+		var msgs []string
+		for _, je := range joined.Unwrap() {
+			msgs = append(msgs, errorChain(je)...)
+		}
+		return msgs
+	}
+	return []string{err.Error()}
+}
+
+// roundDuration rounds a duration for display
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
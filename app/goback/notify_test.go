@@ -0,0 +1,291 @@
+package goback
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/google/go-cmp/cmp"
+)
+
+// smtpServer is a minimal smtp server accepting all mails
+type smtpServer struct {
+	host string
+	port string
+	mu   sync.Mutex
+	auth []string
+	from []string
+	data []string
+}
+
+func newSmtpServer(t *testing.T, cert *tls.Certificate) *smtpServer {
+	var ln net.Listener
+	var err error
+	if cert != nil {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	s := &smtpServer{}
+	s.host, s.port, _ = net.SplitHostPort(ln.Addr().String())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	defer func() { _ = tp.Close() }()
+
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		s.mu.Lock()
+		switch cmd {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = append(s.auth, line)
+			_ = tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			s.from = append(s.from, line)
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			s.mu.Unlock()
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = append(s.data, string(data))
+			_ = tp.PrintfLine("250 ok")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+		s.mu.Unlock()
+	}
+}
+
+// readBody parses a message and returns its headers and decoded text parts by content type
+func readBody(t *testing.T, msg []byte) (mail.Header, map[string]string) {
+	m, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	decode := func(r io.Reader) string {
+		data, err := io.ReadAll(quotedprintable.NewReader(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		parts[mediaType] = decode(m.Body)
+		return m.Header, parts
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pt, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[pt] = decode(p)
+	}
+	return m.Header, parts
+}
+
+func TestEmailMessage(t *testing.T) {
+	cfg := profile.EmailNotify{From: "Goback <goback@mail.com>", To: []string{"a@mail.com", "b@mail.com"}}
+	data := emailData{
+		Profile:  "bla",
+		Host:     "server1",
+		Time:     time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC),
+		Duration: 90 * time.Second,
+		Error:    "disk full\nremote gone",
+		Errors:   []string{"disk full", "remote gone"},
+	}
+
+	t.Run("default plain text failure", func(t *testing.T) {
+		msg, err := emailMessage(cfg, profileTemplates, data, data.Time)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		header, parts := readBody(t, msg)
+
+		if got := header.Get("Subject"); got != "Goback Failure Notification" {
+			t.Errorf("unexpected subject %s", got)
+		}
+		if got := header.Get("To"); got != "a@mail.com, b@mail.com" {
+			t.Errorf("unexpected to %s", got)
+		}
+		if got := header.Get("Date"); got != "Sun, 10 Mar 2024 02:30:00 +0000" {
+			t.Errorf("unexpected date %s", got)
+		}
+		if got := header.Get("Message-Id"); !strings.HasPrefix(got, "<") || !strings.HasSuffix(got, "@mail.com>") {
+			t.Errorf("unexpected message id %s", got)
+		}
+
+		body := parts["text/plain"]
+		for _, want := range []string{"❌ Backup failed.", "Profile: bla\r\n", "Duration: 1m30s\r\n", "  - disk full\r\n", "  - remote gone\r\n"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected body to contain %q, got:\n%s", want, body)
+			}
+		}
+	})
+
+	t.Run("custom templates with html", func(t *testing.T) {
+		custom := cfg
+		custom.Subject = "Backup {{.Profile}} on {{.Host}} {{if .Success}}ok{{else}}failed{{end}}"
+		custom.Body = "size: {{.Size}}"
+		custom.Html = true
+
+		success := data
+		success.Error = ""
+		success.Errors = nil
+		success.Success = true
+		success.Archive = "/backups/bla.zip"
+		success.Size = 1536
+		success.Files = 12
+
+		msg, err := emailMessage(custom, profileTemplates, success, success.Time)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		header, parts := readBody(t, msg)
+		if got := header.Get("Subject"); got != "Backup bla on server1 ok" {
+			t.Errorf("unexpected subject %s", got)
+		}
+		if diff := cmp.Diff("size: 1.5 KiB", parts["text/plain"]); diff != "" {
+			t.Errorf("text part mismatch (-want +got):\n%s", diff)
+		}
+		if !strings.Contains(parts["text/html"], "<tr><td>Files</td><td>12</td></tr>") {
+			t.Errorf("unexpected html part:\n%s", parts["text/html"])
+		}
+	})
+}
+
+func TestErrorChain(t *testing.T) {
+	err := fmt.Errorf("profile bla failed: %w", errors.Join(errors.New("dir1 failed"), errors.Join(errors.New("db1 failed"), errors.New("db2 failed"))))
+	want := []string{"dir1 failed", "db1 failed", "db2 failed"}
+	if diff := cmp.Diff(want, errorChain(err)); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"single"}, errorChain(errors.New("single"))); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	res := RunResult{Profile: "bla", Start: time.Now(), Err: errors.New("failed")}
+
+	t.Run("relay without authentication", func(t *testing.T) {
+		srv := newSmtpServer(t, nil)
+		cfg := profile.EmailNotify{Host: srv.host, Port: srv.port, From: "goback@mail.com", To: []string{"a@mail.com"}}
+		err := NotifyEmail(cfg, res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(srv.auth) != 0 {
+			t.Errorf("expected no authentication, got %v", srv.auth)
+		}
+		if diff := cmp.Diff([]string{"MAIL FROM:<goback@mail.com>"}, srv.from); diff != "" {
+			t.Errorf("sender mismatch (-want +got):\n%s", diff)
+		}
+		if len(srv.data) != 1 || !strings.Contains(srv.data[0], "Subject: Goback Failure Notification") {
+			t.Errorf("unexpected data: %v", srv.data)
+		}
+	})
+
+	t.Run("implicit tls with custom CA and authentication", func(t *testing.T) {
+		// borrow the certificate of the test http server, valid for 127.0.0.1
+		ts := httptest.NewTLSServer(nil)
+		cert := ts.TLS.Certificates[0]
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv := newSmtpServer(t, &cert)
+		cfg := profile.EmailNotify{
+			Host: srv.host, Port: srv.port, User: "user", Password: "pw",
+			From: "goback@mail.com", To: []string{"a@mail.com"},
+			Security: profile.SecurityTls, CaFile: caFile,
+		}
+		err = NotifyEmail(cfg, res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(srv.auth) != 1 || len(srv.data) != 1 {
+			t.Errorf("expected an authenticated mail, got auth %v and %d mails", srv.auth, len(srv.data))
+		}
+
+		// without the CA the server is not trusted
+		cfg.CaFile = ""
+		err = NotifyEmail(cfg, res)
+		if err == nil || !strings.Contains(err.Error(), "certificate") {
+			t.Errorf("expected a certificate error, got: %v", err)
+		}
+	})
+
+	t.Run("fail if starttls is required but not supported", func(t *testing.T) {
+		srv := newSmtpServer(t, nil)
+		cfg := profile.EmailNotify{
+			Host: srv.host, Port: srv.port, From: "goback@mail.com", To: []string{"a@mail.com"},
+			Security: profile.SecurityStartTls,
+		}
+		err := NotifyEmail(cfg, res)
+		if err == nil || !strings.Contains(err.Error(), "does not support STARTTLS") {
+			t.Errorf("expected a STARTTLS error, got: %v", err)
+		}
+	})
+}
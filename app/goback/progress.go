@@ -0,0 +1,335 @@
+package goback
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/zip"
+)
+
+// progressLogInterval is how often the progress of a running source is logged
+const progressLogInterval = 30 * time.Second
+
+// progressBarInterval is how often the progress bar is redrawn
+const progressBarInterval = 200 * time.Millisecond
+
+// progressBarWidth is the number of characters of the bar, without the brackets
+const progressBarWidth = 20
+
+// SourceStats are the statistics collected while a dir or db is written into the archive
+type SourceStats struct {
+	Files int `json:"files"`
+	// BytesRead is the size of the content read from the source
+	BytesRead int64 `json:"bytesRead"`
+	// BytesCompressed is the size the source takes in the archive
+	BytesCompressed int64 `json:"bytesCompressed"`
+	// Excluded is the number of files and dirs skipped by an exclude pattern
+	Excluded int `json:"excluded"`
+	// Errors counts the errors of the source, including lost connections that were retried
+	Errors int `json:"errors"`
+}
+
+// sourceProgress collects the stats of a source while it is written into the zip handler, it logs the
+// progress periodically and draws a progress bar if the run has an output for it; all methods accept nil
+type sourceProgress struct {
+	res   *RunResult
+	kind  string
+	i     int
+	name  string
+	log   *slog.Logger
+	zh    *zip.Handler
+	start time.Time
+	// read0 and written0 are the stats of the zip handler when the source started
+	read0    int64
+	written0 int64
+	// total is the expected size of the source, 0 if unknown
+	total    atomic.Int64
+	files    atomic.Int64
+	excluded atomic.Int64
+	errors   atomic.Int64
+	bar      *progressBar
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// startSource starts collecting the stats of the i-th source of a kind written into zh,
+// runs without result, e.g. the agent, pass nil and get a nil progress
+func (r *RunResult) startSource(kind string, i int, zh *zip.Handler, log *slog.Logger) *sourceProgress {
+	if r == nil {
+		return nil
+	}
+	p := &sourceProgress{
+		res:   r,
+		kind:  kind,
+		i:     i,
+		log:   log,
+		zh:    zh,
+		start: time.Now(),
+		bar:   r.progress,
+		stop:  make(chan struct{}),
+	}
+	if src := r.source(kind, i); src != nil {
+		p.name = src.Name
+	}
+	if zh != nil {
+		p.read0, p.written0 = zh.Stats()
+	}
+	p.wg.Add(1)
+	go p.report()
+	return p
+}
+
+// report logs the progress every progressLogInterval and redraws the bar until the source is done
+func (p *sourceProgress) report() {
+	defer p.wg.Done()
+
+	logTicker := time.NewTicker(progressLogInterval)
+	defer logTicker.Stop()
+
+	var barC <-chan time.Time
+	if p.bar != nil {
+		barTicker := time.NewTicker(progressBarInterval)
+		defer barTicker.Stop()
+		barC = barTicker.C
+		p.draw()
+	}
+
+	for {
+		select {
+		case <-p.stop:
+			p.clearBar()
+			return
+		case <-logTicker.C:
+			p.log.Info("backup progress", p.logAttrs()...)
+		case <-barC:
+			p.draw()
+		}
+	}
+}
+
+func (p *sourceProgress) draw() {
+	if p.bar == nil {
+		return
+	}
+	p.bar.draw(progressLine(p.name, p.stats(), p.total.Load(), time.Since(p.start)))
+}
+
+func (p *sourceProgress) clearBar() {
+	if p.bar == nil {
+		return
+	}
+	p.bar.clear()
+}
+
+// progressBar is a line redrawn in place on the terminal, the log output of the run goes through a barHandler
+// so that log lines are never appended to the bar
+type progressBar struct {
+	mu  sync.Mutex
+	out io.Writer
+	// line is the bar currently shown, empty if none
+	line string
+}
+
+// draw replaces the bar with line
+func (b *progressBar) draw(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.line = line
+	_, _ = fmt.Fprint(b.out, "\r\033[K"+line)
+}
+
+// clear removes the bar
+func (b *progressBar) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.line == "" {
+		return
+	}
+	b.line = ""
+	_, _ = fmt.Fprint(b.out, "\r\033[K")
+}
+
+// barHandler clears the progress bar before every log record and draws it again below the record
+type barHandler struct {
+	slog.Handler
+	bar *progressBar
+}
+
+func (h barHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.bar.mu.Lock()
+	defer h.bar.mu.Unlock()
+	if h.bar.line == "" {
+		return h.Handler.Handle(ctx, r)
+	}
+	_, _ = fmt.Fprint(h.bar.out, "\r\033[K")
+	err := h.Handler.Handle(ctx, r)
+	_, _ = fmt.Fprint(h.bar.out, h.bar.line)
+	return err
+}
+
+func (h barHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return barHandler{h.Handler.WithAttrs(attrs), h.bar}
+}
+
+func (h barHandler) WithGroup(name string) slog.Handler {
+	return barHandler{h.Handler.WithGroup(name), h.bar}
+}
+
+// logAttrs returns the progress as log attributes, the percentage and ETA are only known if the total is
+func (p *sourceProgress) logAttrs() []any {
+	s := p.stats()
+	elapsed := time.Since(p.start)
+	attrs := []any{
+		"kind", p.kind,
+		"name", p.name,
+		"files", s.Files,
+		"read", profile.ByteSize(s.BytesRead).String(),
+		"compressed", profile.ByteSize(s.BytesCompressed).String(),
+		"rate", rateStr(s.BytesRead, elapsed),
+	}
+	if total := p.total.Load(); total > 0 {
+		attrs = append(attrs, "total", profile.ByteSize(total).String(), "percent", percent(s.BytesRead, total))
+		if left, ok := eta(s.BytesRead, total, elapsed); ok {
+			attrs = append(attrs, "eta", left.String())
+		}
+	}
+	return attrs
+}
+
+// stats returns the stats collected so far
+func (p *sourceProgress) stats() SourceStats {
+	s := SourceStats{
+		Files:    int(p.files.Load()),
+		Excluded: int(p.excluded.Load()),
+		Errors:   int(p.errors.Load()),
+	}
+	if p.zh != nil {
+		read, written := p.zh.Stats()
+		s.BytesRead = read - p.read0
+		s.BytesCompressed = written - p.written0
+	}
+	return s
+}
+
+// setTotal sets the expected size of the source, used to calculate the percentage and ETA
+func (p *sourceProgress) setTotal(n int64) {
+	if p == nil {
+		return
+	}
+	p.total.Store(n)
+}
+
+// addFile counts a file, symlink or hard link added to the archive
+func (p *sourceProgress) addFile() {
+	if p == nil {
+		return
+	}
+	p.files.Add(1)
+}
+
+// addExcluded counts files and dirs skipped by an exclude pattern
+func (p *sourceProgress) addExcluded(n int) {
+	if p == nil {
+		return
+	}
+	p.excluded.Add(int64(n))
+}
+
+// retried counts errors that were recovered from, e.g. a lost connection
+func (p *sourceProgress) retried(n int) {
+	if p == nil {
+		return
+	}
+	p.errors.Add(int64(n))
+}
+
+// done stops the progress reporting and records the outcome and the stats of the source in the result
+func (p *sourceProgress) done(err error) {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+
+	if err != nil {
+		p.errors.Add(1)
+	}
+	if p.zh != nil {
+		// the zip writer buffers its output, the source is only fully counted once flushed
+		_ = p.zh.Flush()
+	}
+	s := p.stats()
+	if p.kind == sourceDb && err == nil {
+		// a db is dumped into a single file
+		s.Files = 1
+	}
+	p.res.sourceDone(p.kind, p.i, err)
+	if src := p.res.source(p.kind, p.i); src != nil {
+		src.Stats = s
+	}
+	if err == nil {
+		p.log.Info("source backed up", "kind", p.kind, "name", p.name, "files", s.Files,
+			"read", profile.ByteSize(s.BytesRead).String(), "compressed", profile.ByteSize(s.BytesCompressed).String(),
+			"excluded", s.Excluded, "duration", time.Since(p.start).Round(time.Millisecond).String())
+	}
+}
+
+// progressLine returns the line of the progress bar, without total only the amount copied and the rate are shown, e.g.
+// [#########-----------]  45% /var/www 1.2 GiB of 2.6 GiB, 1234 files, 12.3 MiB/s, ETA 1m55s
+func progressLine(name string, s SourceStats, total int64, elapsed time.Duration) string {
+	read := profile.ByteSize(s.BytesRead).String()
+	rate := rateStr(s.BytesRead, elapsed)
+	if total <= 0 {
+		return fmt.Sprintf("%s %s, %d files, %s", name, read, s.Files, rate)
+	}
+
+	pct := percent(s.BytesRead, total)
+	filled := pct * progressBarWidth / 100
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	line := fmt.Sprintf("[%s] %3d%% %s %s of %s, %d files, %s", bar, pct, name, read,
+		profile.ByteSize(total).String(), s.Files, rate)
+	if left, ok := eta(s.BytesRead, total, elapsed); ok {
+		line += ", ETA " + left.String()
+	}
+	return line
+}
+
+// percent returns the part of total that is read, capped at 100 since files can grow while they are copied
+func percent(read, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	pct := int(read * 100 / total)
+	if pct > 100 {
+		return 100
+	}
+	return pct
+}
+
+// rateStr returns the throughput, e.g. 12.3 MiB/s
+func rateStr(read int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "0 B/s"
+	}
+	return profile.ByteSize(float64(read)/elapsed.Seconds()).String() + "/s"
+}
+
+// eta returns the time left to read the total at the current rate, false if nothing was read yet
+func eta(read, total int64, elapsed time.Duration) (time.Duration, bool) {
+	if read <= 0 || elapsed <= 0 {
+		return 0, false
+	}
+	left := total - read
+	if left < 0 {
+		left = 0
+	}
+	d := time.Duration(float64(elapsed) * float64(left) / float64(read))
+	return d.Round(time.Second), true
+}
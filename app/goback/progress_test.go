@@ -0,0 +1,173 @@
+package goback
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/zip"
+	"github.com/gobwas/glob"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProgressLine(t *testing.T) {
+	tcs := []struct {
+		name    string
+		stats   SourceStats
+		total   int64
+		elapsed time.Duration
+		want    string
+	}{
+		{
+			name:    "unknown total",
+			stats:   SourceStats{Files: 12, BytesRead: 3 << 20},
+			elapsed: 2 * time.Second,
+			want:    "/var/www 3.0 MiB, 12 files, 1.5 MiB/s",
+		},
+		{
+			name:    "known total with eta",
+			stats:   SourceStats{Files: 1234, BytesRead: 1 << 30},
+			total:   4 << 30,
+			elapsed: time.Minute,
+			want:    "[#####---------------]  25% /var/www 1.0 GiB of 4.0 GiB, 1234 files, 17.1 MiB/s, ETA 3m0s",
+		},
+		{
+			name:    "nothing read yet",
+			total:   4 << 30,
+			elapsed: time.Second,
+			want:    "[--------------------]   0% /var/www 0 B of 4.0 GiB, 0 files, 0 B/s",
+		},
+		{
+			name:    "files grew while copied",
+			stats:   SourceStats{Files: 2, BytesRead: 3 << 20},
+			total:   2 << 20,
+			elapsed: time.Second,
+			want:    "[####################] 100% /var/www 3.0 MiB of 2.0 MiB, 2 files, 3.0 MiB/s, ETA 0s",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := progressLine("/var/www", tc.stats, tc.total, tc.elapsed)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCopyLocalFilesStats(t *testing.T) {
+	res := &RunResult{Sources: []SourceResult{{Kind: sourceDir, Name: "sampledata/files"}}}
+	p := res.startSource(sourceDir, 0, nil, logger.SilentLogger())
+
+	dir := profile.BackupPath{
+		Path:    "sampledata/files",
+		Exclude: []glob.Glob{getGlob("*.log"), getGlob("*.txt")},
+	}
+	err := copyLocalFiles(dir, &fileAppender{}, p)
+	p.done(err)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := SourceResult{Kind: sourceDir, Name: "sampledata/files", Status: "success", Stats: SourceStats{Files: 4, Excluded: 2}}
+	if diff := cmp.Diff(want, res.Sources[0]); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunProgressBar(t *testing.T) {
+	var bar bytes.Buffer
+	br := BackupRunner{
+		Logger:   logger.SilentLogger(),
+		LockDir:  t.TempDir(),
+		Progress: &bar,
+		profiles: []profile.Profile{
+			{
+				Name:        "bla",
+				Type:        profile.TypeLocal,
+				Dirs:        []profile.BackupPath{{Path: "sampledata/files/dir1"}},
+				Destination: profile.Destination{Path: filepath.Join(t.TempDir(), "bla")},
+			},
+		},
+	}
+	if err := br.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := bar.String()
+	// the size of a local dir is known, so the bar has a percentage
+	if !strings.HasPrefix(got, "\r\033[K[") || !strings.Contains(got, "% sampledata/files/dir1 ") {
+		t.Errorf("expected the bar of the dir, got %q", got)
+	}
+	// the bar is cleared once the dir is done
+	if !strings.HasSuffix(got, "\r\033[K") {
+		t.Errorf("expected the bar to be cleared, got %q", got)
+	}
+}
+
+func TestBarHandler(t *testing.T) {
+	var out bytes.Buffer
+	bar := &progressBar{out: &out}
+	text := slog.NewTextHandler(&out, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	log := slog.New(barHandler{text, bar}).With("profile", "bla")
+
+	log.Info("before the bar")
+	bar.draw("[##--] 50%")
+	log.Info("during the bar")
+	bar.clear()
+	log.Info("after the bar")
+
+	want := "level=INFO msg=\"before the bar\" profile=bla\n" +
+		"\r\033[K[##--] 50%" +
+		"\r\033[Klevel=INFO msg=\"during the bar\" profile=bla\n[##--] 50%" +
+		"\r\033[K" +
+		"level=INFO msg=\"after the bar\" profile=bla\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCopyLocalFilesEta(t *testing.T) {
+	zh, err := zip.New(filepath.Join(t.TempDir(), "eta.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = zh.Close()
+	}()
+	res := &RunResult{Sources: []SourceResult{{Kind: sourceDir, Name: "sampledata/files"}}}
+	p := res.startSource(sourceDir, 0, zh, logger.SilentLogger())
+	defer p.done(nil)
+
+	err = copyLocalFiles(profile.BackupPath{Path: "sampledata/files", Exclude: []glob.Glob{getGlob("*.log")}}, zh, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	attrs := map[string]any{}
+	logAttrs := p.logAttrs()
+	for i := 0; i+1 < len(logAttrs); i += 2 {
+		attrs[logAttrs[i].(string)] = logAttrs[i+1]
+	}
+	for _, key := range []string{"total", "percent", "eta"} {
+		if _, ok := attrs[key]; !ok {
+			t.Errorf("expected %s in the progress of a local dir, got %v", key, logAttrs)
+		}
+	}
+	if attrs["percent"] != 100 {
+		t.Errorf("got percent %v, want 100", attrs["percent"])
+	}
+}
@@ -0,0 +1,100 @@
+package goback
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ReportJson is the only report format
+const ReportJson = "json"
+
+// Report is the machine readable result of a run, errors joined together are listed one by one
+type Report struct {
+	Status   string          `json:"status"`
+	Host     string          `json:"host"`
+	Total    int             `json:"total"`
+	Failed   int             `json:"failed"`
+	Skipped  int             `json:"skipped"`
+	Profiles []ProfileReport `json:"profiles"`
+}
+
+// ProfileReport is the result of a profile in the report
+type ProfileReport struct {
+	Profile         string         `json:"profile"`
+	Type            string         `json:"type"`
+	Status          string         `json:"status"`
+	Start           time.Time      `json:"start"`
+	End             time.Time      `json:"end"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	Archive         string         `json:"archive,omitempty"`
+	ArchiveSize     int64          `json:"archiveSize,omitempty"`
+	Files           int            `json:"files,omitempty"`
+	Errors          []string       `json:"errors,omitempty"`
+	Sources         []SourceReport `json:"sources"`
+}
+
+// SourceReport is the result of a dir or db of a profile in the report
+type SourceReport struct {
+	Kind   string      `json:"kind"`
+	Name   string      `json:"name"`
+	Status string      `json:"status"`
+	Errors []string    `json:"errors,omitempty"`
+	Stats  SourceStats `json:"stats"`
+}
+
+// NewReport returns the report of the results
+func NewReport(results []RunResult) Report {
+	host, _ := os.Hostname()
+	r := Report{
+		Status:   "success",
+		Host:     host,
+		Total:    len(results),
+		Profiles: []ProfileReport{},
+	}
+	for _, res := range results {
+		p := ProfileReport{
+			Profile:         res.Profile,
+			Type:            string(res.Type),
+			Status:          res.status(),
+			Start:           res.Start,
+			End:             res.Start.Add(res.Duration),
+			DurationSeconds: res.Duration.Seconds(),
+			Archive:         res.Archive,
+			ArchiveSize:     res.Size,
+			Files:           res.Files,
+			Errors:          errorChain(res.Err),
+			Sources:         []SourceReport{},
+		}
+		for _, src := range res.Sources {
+			p.Sources = append(p.Sources, SourceReport{
+				Kind:   src.Kind,
+				Name:   src.Name,
+				Status: src.Status,
+				Errors: errorChain(src.Err),
+				Stats:  src.Stats,
+			})
+		}
+		switch {
+		case res.Err != nil:
+			r.Failed++
+			r.Status = "failure"
+		case res.Skipped:
+			r.Skipped++
+		}
+		r.Profiles = append(r.Profiles, p)
+	}
+	return r
+}
+
+// WriteReport writes the report of the results in the format, json is the only format
+func WriteReport(w io.Writer, format string, results []RunResult) error {
+	if format != ReportJson {
+		return fmt.Errorf("unsupported report format: %s", format)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(NewReport(results))
+}
@@ -0,0 +1,165 @@
+package goback
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewReport(t *testing.T) {
+	start := time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC)
+	dbErr := errors.Join(errors.New("dump failed"), errors.New("container not found"))
+	results := []RunResult{
+		{
+			Profile: "web", Type: profile.TypeLocal, Start: start, Duration: time.Minute,
+			Archive: "/backups/web.zip", Size: 2048, Files: 12,
+			Sources: []SourceResult{{Kind: sourceDir, Name: "/var/www", Status: "success"}},
+		},
+		{
+			Profile: "db", Type: profile.TypeRemote, Start: start, Duration: time.Second,
+			Err: fmt.Errorf("backup failed: %w", dbErr),
+			Sources: []SourceResult{
+				{Kind: sourceDir, Name: "/etc", Status: "success"},
+				{Kind: sourceDb, Name: "main", Status: "failure", Err: dbErr},
+				{Kind: sourceDb, Name: "other", Status: "skipped"},
+			},
+		},
+		{Profile: "locked", Type: profile.TypeLocal, Start: start, Skipped: true},
+	}
+
+	got := NewReport(results)
+	got.Host = ""
+	want := Report{
+		Status:  "failure",
+		Total:   3,
+		Failed:  1,
+		Skipped: 1,
+		Profiles: []ProfileReport{
+			{
+				Profile: "web", Type: "local", Status: "success", Start: start, End: start.Add(time.Minute),
+				DurationSeconds: 60, Archive: "/backups/web.zip", ArchiveSize: 2048, Files: 12,
+				Sources: []SourceReport{{Kind: "dir", Name: "/var/www", Status: "success"}},
+			},
+			{
+				Profile: "db", Type: "remote", Status: "failure", Start: start, End: start.Add(time.Second),
+				DurationSeconds: 1, Errors: []string{"dump failed", "container not found"},
+				Sources: []SourceReport{
+					{Kind: "dir", Name: "/etc", Status: "success"},
+					{Kind: "db", Name: "main", Status: "failure", Errors: []string{"dump failed", "container not found"}},
+					{Kind: "db", Name: "other", Status: "skipped"},
+				},
+			},
+			{Profile: "locked", Type: "local", Status: "skipped", Start: start, End: start, Sources: []SourceReport{}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+
+	var sb strings.Builder
+	if err := WriteReport(&sb, "yaml", results); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if err := WriteReport(&sb, ReportJson, results); err != nil {
+		t.Fatal(err)
+	}
+	decoded := Report{}
+	if err := json.Unmarshal([]byte(sb.String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	decoded.Host = ""
+	if diff := cmp.Diff(want, decoded); diff != "" {
+		t.Errorf("json mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunSources(t *testing.T) {
+	br := BackupRunner{
+		Logger:  logger.SilentLogger(),
+		LockDir: t.TempDir(),
+		profiles: []profile.Profile{
+			{
+				Name: "bla",
+				Type: profile.TypeLocal,
+				Dirs: []profile.BackupPath{
+					{Path: "sampledata/files/dir1"},
+					{Path: "sampledata/files/missing"},
+					{Path: "sampledata/files/dir2"},
+				},
+				Destination: profile.Destination{Path: filepath.Join(t.TempDir(), "bla")},
+			},
+		},
+	}
+	err := br.Run()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(br.Results) != 1 {
+		t.Fatalf("expected one result, got %d", len(br.Results))
+	}
+
+	var got []string
+	for _, src := range br.Results[0].Sources {
+		got = append(got, src.Name+":"+src.Status)
+	}
+	want := []string{"sampledata/files/dir1:success", "sampledata/files/missing:failure", "sampledata/files/dir2:skipped"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("sources mismatch (-want +got):\n%s", diff)
+	}
+	if br.Results[0].Sources[1].Err == nil {
+		t.Error("expected the error of the failed source")
+	}
+
+	stats := br.Results[0].Sources[0].Stats
+	if stats.Files != 3 || stats.BytesRead == 0 || stats.BytesCompressed == 0 {
+		t.Errorf("unexpected stats of the dir: %+v", stats)
+	}
+	if diff := cmp.Diff(1, br.Results[0].Sources[1].Stats.Errors); diff != "" {
+		t.Errorf("errors mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "dest")
+	valid := fmt.Sprintf("version: 1\nname: valid\ntype: local\ndirs:\n  - path: sampledata/files/dir1\ndestination:\n  path: %s\n", dest)
+	broken := filepath.Join(dir, "broken.backup.yaml")
+	for file, content := range map[string]string{
+		filepath.Join(dir, "valid.backup.yaml"): valid,
+		broken:                                  "version: 1\nname: broken\ntype: nope\n",
+	} {
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	br := BackupRunner{Logger: logger.SilentLogger(), LockDir: t.TempDir()}
+	if err := br.LoadProfilesDir(dir); err != nil {
+		t.Fatalf("expected load errors to be part of the run, got: %v", err)
+	}
+	if err := br.Run(); err == nil {
+		t.Error("expected the run to fail")
+	}
+
+	report := NewReport(br.Results)
+	var got []string
+	for _, p := range report.Profiles {
+		got = append(got, p.Profile+": "+p.Status)
+	}
+	want := []string{broken + ": failure", "valid: success"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+	if report.Total != 2 || report.Failed != 1 {
+		t.Errorf("got total %d failed %d, want 2 and 1", report.Total, report.Failed)
+	}
+}
@@ -0,0 +1,159 @@
+package goback
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// pushLocalBackups compares the backups of a profile name in the local path with the remote destination and uploads
+// the ones missing in the remote, this is the counterpart of syncRemoteBackups
+func pushLocalBackups(sftpc *sftp.Client, localPath, profileName, remotePath string, log *slog.Logger) error {
+
+	if profileName == "" {
+		return errors.New("profile name cannot be empty")
+	}
+
+	// local location
+	locFileInfos, err := os.ReadDir(localPath)
+	if err != nil {
+		return fmt.Errorf("error reading dir %s, %v", localPath, err)
+	}
+
+	localFiles := []string{}
+	localSizes := map[string]int64{}
+	for _, f := range locFileInfos {
+		if !f.IsDir() {
+			info, iErr := f.Info()
+			if iErr != nil {
+				return fmt.Errorf("error reading file info %s, %v", f.Name(), iErr)
+			}
+			localFiles = append(localFiles, f.Name())
+			localSizes[f.Name()] = info.Size()
+		}
+	}
+
+	// remote location
+	err = sftpc.MkdirAll(remotePath)
+	if err != nil {
+		return fmt.Errorf("unable to create remote dir %s, %v", remotePath, err)
+	}
+
+	remFileInfos, err := sftpc.ReadDir(remotePath)
+	if err != nil {
+		return fmt.Errorf("error reading dir %s, %v", remotePath, err)
+	}
+
+	remoteSizes := map[string]int64{}
+	for _, f := range remFileInfos {
+		if !f.IsDir() {
+			remoteSizes[f.Name()] = f.Size()
+		}
+	}
+
+	// the arguments are swapped to get the local files missing or different in the remote
+	diff, err := findChangedProfiles(localFiles, localSizes, remoteSizes, profileName)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range diff {
+		log.Debug("uploading local file", "file", f)
+
+		err = sftpUpload(sftpc, filepath.Join(localPath, f), path.Join(remotePath, f))
+		if err != nil {
+			return fmt.Errorf("unable to upload file: %s, %v", f, err)
+		}
+	}
+
+	return nil
+}
+
+// sftpUpload uses an sftp client to upload a local file to a remote destination
+// the content is written into a .part file first and only renamed once the size is verified
+func sftpUpload(sc *sftp.Client, localFile, remoteDest string) (err error) {
+
+	// #nosec G304 -- path controlled by internal var
+	srcFile, err := os.Open(localFile)
+	if err != nil {
+		return fmt.Errorf("unable to open local file: %v", err)
+	}
+	defer func() {
+		cErr := srcFile.Close()
+		if cErr != nil {
+			err = errors.Join(err, cErr)
+		}
+	}()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat local file: %v", err)
+	}
+
+	partFile := remoteDest + partExt
+	dstFile, err := sc.Create(partFile)
+	if err != nil {
+		return fmt.Errorf("unable to open remote file: %v", err)
+	}
+
+	_, err = io.Copy(dstFile, srcFile)
+	if err != nil {
+		_ = dstFile.Close()
+		return fmt.Errorf("unable to upload local file: %v", err)
+	}
+
+	err = dstFile.Close()
+	if err != nil {
+		return fmt.Errorf("unable to close remote file: %v", err)
+	}
+
+	dstInfo, err := sc.Stat(partFile)
+	if err != nil {
+		return fmt.Errorf("unable to stat remote file: %v", err)
+	}
+	if dstInfo.Size() != srcInfo.Size() {
+		_ = sc.Remove(partFile)
+		return fmt.Errorf("size mismatch on uploaded file: got %d bytes, expected %d", dstInfo.Size(), srcInfo.Size())
+	}
+
+	err = sc.PosixRename(partFile, remoteDest)
+	if err != nil {
+		return fmt.Errorf("unable to rename uploaded file: %v", err)
+	}
+	return nil
+}
+
+// expurgeRemoteDir is the remote equivalent of ExpurgeDir, it deletes the older backups
+// in a remote path keeping N versions of a specific backup profile name
+func expurgeRemoteDir(sc *sftp.Client, remotePath string, keepN int, name string, log *slog.Logger) error {
+	remFileInfos, err := sc.ReadDir(remotePath)
+	if err != nil {
+		return fmt.Errorf("error reading dir %s, %v", remotePath, err)
+	}
+
+	fileNames := []string{}
+	for _, f := range remFileInfos {
+		if !f.IsDir() {
+			fileNames = append(fileNames, f.Name())
+		}
+	}
+
+	filesToDelete, err := findToDelete(fileNames, name, keepN)
+	if err != nil {
+		return fmt.Errorf("error parsing files to delete: %v", err)
+	}
+
+	for _, file := range filesToDelete {
+		log.Info("Deleting old remote backup", "file", file)
+		e := sc.Remove(path.Join(remotePath, file))
+		if e != nil {
+			return fmt.Errorf("unable to delete old remote zip file: %v", e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package goback
+
+import (
+	"context"
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/lib/ssh"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/sftp"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestPushProfiles(t *testing.T) {
+	skipInCI(t) // skip test if running in CI
+
+	ctx := context.Background()
+	sshServer, err := setupContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = sshServer.Terminate(ctx)
+	}()
+
+	tmpdir := t.TempDir()
+	files := []string{
+		"a.zip",
+		"blib_2011_02_05-17:04:05_backup.zip",
+		"blib_2013_02_05-17:04:05_backup.zip",
+		"ble_2013_02_05-17:04:05_backup.zip",
+	}
+	for _, f := range files {
+		e := os.WriteFile(filepath.Join(tmpdir, f), []byte("hello\ngo\n"), 0600)
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	sshClient, err := ssh.New(ssh.Cfg{
+		Host:          sshServer.host,
+		Port:          sshServer.port,
+		Auth:          ssh.Password,
+		User:          "pwuser",
+		Password:      "1234",
+		IgnoreHostKey: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = sshClient.Connect()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer func() {
+		_ = sshClient.Disconnect()
+	}()
+
+	sftpClient, err := sftp.NewClient(sshClient.Connection())
+	if err != nil {
+		t.Fatalf("unable to create sftp client %v", err)
+	}
+	defer func() {
+		_ = sftpClient.Close()
+	}()
+
+	t.Run("push backups to remote", func(t *testing.T) {
+		remoteDir := "/home/pwuser/pushed"
+		err = pushLocalBackups(sftpClient, tmpdir, "blib", remoteDir, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		remFiles, err := sftpClient.ReadDir(remoteDir)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		got := []string{}
+		for _, f := range remFiles {
+			got = append(got, f.Name())
+		}
+		sort.Strings(got)
+
+		want := []string{
+			"blib_2011_02_05-17:04:05_backup.zip",
+			"blib_2013_02_05-17:04:05_backup.zip",
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("expurge remote backups", func(t *testing.T) {
+		remoteDir := "/home/pwuser/pushed"
+		err = expurgeRemoteDir(sftpClient, remoteDir, 1, "blib", logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		remFiles, err := sftpClient.ReadDir(remoteDir)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		got := []string{}
+		for _, f := range remFiles {
+			got = append(got, f.Name())
+		}
+
+		want := []string{
+			"blib_2013_02_05-17:04:05_backup.zip",
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package goback
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/AndresBott/goback/lib/ssh"
+	"github.com/pkg/sftp"
+)
+
+// sftpSession holds an sftp client on top of an ssh connection and re-creates both when the connection is lost
+type sftpSession struct {
+	sshc  *ssh.Client
+	sftpc *sftp.Client
+	// maxRetries is the number of times a single operation is retried after reconnecting
+	maxRetries int
+	// retries counts the reconnections done during the session
+	retries int
+	log     *slog.Logger
+}
+
+func newSftpSession(sshc *ssh.Client, maxRetries int, log *slog.Logger) (*sftpSession, error) {
+	sftpc, err := sshc.SftpClient()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create sftp client %v", err)
+	}
+	return &sftpSession{
+		sshc:       sshc,
+		sftpc:      sftpc,
+		maxRetries: maxRetries,
+		log:        log,
+	}, nil
+}
+
+// Close closes the sftp client, the ssh connection is left open
+func (s *sftpSession) Close() error {
+	if s.sftpc == nil {
+		return nil
+	}
+	err := s.sftpc.Close()
+	s.sftpc = nil
+	return err
+}
+
+// recover is called after an operation failed with err on its attempt number n, starting at 0.
+// If the ssh connection was lost and retries are left, it reconnects and returns nil so that the
+// operation can be retried, otherwise the original error is returned.
+func (s *sftpSession) recover(err error, attempt int) error {
+	if attempt >= s.maxRetries || s.sshc.Alive() {
+		return err
+	}
+	s.log.Warn("ssh connection lost, reconnecting", "attempt", attempt+1, "error", err)
+
+	_ = s.Close()
+	rErr := s.sshc.Reconnect()
+	if rErr != nil {
+		return errors.Join(err, fmt.Errorf("unable to reconnect: %v", rErr))
+	}
+	sftpc, cErr := s.sshc.SftpClient()
+	if cErr != nil {
+		return errors.Join(err, fmt.Errorf("unable to create sftp client %v", cErr))
+	}
+	s.sftpc = sftpc
+	s.retries++
+	return nil
+}
+
+// do runs fn with the sftp client, retrying it after a reconnection if the connection was lost
+func (s *sftpSession) do(fn func(sftpc *sftp.Client) error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn(s.sftpc)
+		if err == nil {
+			return nil
+		}
+		err = s.recover(err, attempt)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -1,18 +1,34 @@
 package goback
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/gobwas/glob"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/zip"
 	"github.com/pkg/sftp"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
-// copyRemoteFiles takes a single backup dir, connects over ssh and recursively traverses the files and adds them to the zip handler
-func syncRemoteBackups(sftpc *sftp.Client, remotePath, profileName, destPath string, log *slog.Logger) error {
+// partExt is appended to the local file name while a download is in progress,
+// the file is only renamed to its final name once it has been verified
+const partExt = ".part"
+
+// checksumExt is the extension of an optional sha256sum manifest stored next to a remote backup file
+const checksumExt = ".sha256"
+
+// syncRemoteBackups compares the backups of a profile name in the remote path with the local destination and downloads
+// the missing ones, depending on the sync options, local or remote copies are deleted afterwards; keep is the number
+// of backups the local retention keeps, 0 keeps all
+func syncRemoteBackups(sftpc *sftp.Client, remotePath, profileName, destPath string, opts profile.Sync, keep int, log *slog.Logger) error {
 
 	// check local location
 	locFileInfos, err := os.ReadDir(destPath)
@@ -20,10 +36,14 @@ func syncRemoteBackups(sftpc *sftp.Client, remotePath, profileName, destPath str
 		return fmt.Errorf("error reading dir %s, %v", destPath, err)
 	}
 
-	localFile := []string{}
+	localFiles := map[string]int64{}
 	for _, f := range locFileInfos {
 		if !f.IsDir() {
-			localFile = append(localFile, f.Name())
+			info, iErr := f.Info()
+			if iErr != nil {
+				return fmt.Errorf("error reading file info %s, %v", f.Name(), iErr)
+			}
+			localFiles[f.Name()] = info.Size()
 		}
 	}
 
@@ -42,9 +62,11 @@ func syncRemoteBackups(sftpc *sftp.Client, remotePath, profileName, destPath str
 	}
 
 	remoteFiles := []string{}
+	remoteSizes := map[string]int64{}
 	for _, f := range remFileInfos {
 		if !f.IsDir() {
 			remoteFiles = append(remoteFiles, f.Name())
+			remoteSizes[f.Name()] = f.Size()
 		}
 	}
 
@@ -53,31 +75,235 @@ func syncRemoteBackups(sftpc *sftp.Client, remotePath, profileName, destPath str
 		return errors.New("profile name cannot be empty")
 	}
 
-	diff, err := findDifferentProfiles(remoteFiles, localFile, profileName)
+	diff, err := findChangedProfiles(remoteFiles, remoteSizes, localFiles, profileName)
 	if err != nil {
 		return err
 	}
 
+	// when moving the backups, the ones the local retention deletes anyway are not downloaded, they count as moved,
+	// otherwise they would be downloaded again on every run or kept in the remote forever
+	retired := map[string]bool{}
+	if opts.RemoveRemote {
+		retired, err = retiredBackups(remoteFiles, localFiles, profileName, keep)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the sha256 sums of the files downloaded in this run
+	downloaded := map[string]string{}
 	for _, f := range diff {
+		if retired[f] {
+			continue
+		}
+		if opts.DryRun {
+			log.Info("dry-run: would download remote file", "file", f)
+			continue
+		}
 		log.Debug("downloading remote file", "file", f)
 
-		err = sftpDownload(sftpc, filepath.Join(remotePath, f), filepath.Join(destPath, f))
+		remoteFile := filepath.Join(remotePath, f)
+		checksum, err := readRemoteChecksum(sftpc, remoteFile+checksumExt)
+		if err != nil {
+			return fmt.Errorf("unable to read checksum for file: %s, %v", f, err)
+		}
+
+		sum, err := sftpDownload(sftpc, remoteFile, filepath.Join(destPath, f), checksum)
 		if err != nil {
 			return fmt.Errorf("unable to donwload file: %s, %v", f, err)
 		}
+		downloaded[f] = sum
 	}
-	_ = diff
 
-	return err
+	if opts.RemoveRemote {
+		err = removeVerifiedRemote(sftpc, remotePath, remoteFiles, profileName, destPath, downloaded, retired, opts.DryRun, log)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Mirror {
+		err = mirrorDeletions(remoteFiles, localFiles, profileName, destPath, opts.DryRun, log)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeVerifiedRemote deletes every remote backup of a profile that has a verified local copy, or that the local
+// retention deletes; the volumes of a split backup are only deleted once all of them are verified.
+// A local copy is verified by the sha256 sum calculated while it was downloaded or by the remote checksum manifest,
+// a copy of an earlier run without manifest cannot be verified without reading the remote file again and is kept.
+func removeVerifiedRemote(sftpc *sftp.Client, remotePath string, remoteFiles []string, profileName, destPath string,
+	downloaded map[string]string, retired map[string]bool, dryRun bool, log *slog.Logger) error {
+	// passing no local files returns all the remote files that match the profile name
+	matching, err := findDifferentProfiles(remoteFiles, nil, profileName)
+	if err != nil {
+		return err
+	}
+
+OUTER:
+	for _, set := range volumeSets(matching) {
+		manifests := map[string]bool{}
+		for _, f := range set {
+			remoteFile := filepath.Join(remotePath, f)
+			manifest, err := readRemoteChecksum(sftpc, remoteFile+checksumExt)
+			if err != nil {
+				return fmt.Errorf("unable to read checksum for file: %s, %v", f, err)
+			}
+			manifests[f] = manifest != ""
+			if retired[f] {
+				continue
+			}
+
+			sum, inRun := downloaded[f]
+			if !inRun && manifest == "" {
+				log.Info("keeping remote backup without checksum manifest, its local copy is from an earlier run", "file", f)
+				continue OUTER
+			}
+			ok, err := verifyLocalCopy(filepath.Join(destPath, f), sum, manifest)
+			if err != nil {
+				return fmt.Errorf("unable to verify local copy of: %s, %v", f, err)
+			}
+			if !ok {
+				log.Warn("keeping remote backup without a verified local copy", "file", f)
+				continue OUTER
+			}
+		}
+
+		for _, f := range set {
+			if dryRun {
+				log.Info("dry-run: would delete verified remote backup", "file", f)
+				continue
+			}
+			err = removeRemoteBackup(sftpc, filepath.Join(remotePath, f), manifests[f], log)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifyLocalCopy checks the local copy of a remote backup: a copy downloaded in this run has the sum calculated
+// during the download, it is compared to the manifest if there is one; an earlier copy is hashed and compared to
+// the manifest. A missing local file is not verified.
+func verifyLocalCopy(localFile, downloadedSum, manifest string) (bool, error) {
+	if downloadedSum != "" {
+		return manifest == "" || strings.EqualFold(downloadedSum, manifest), nil
+	}
+	if manifest == "" {
+		return false, nil
+	}
+	localSum, err := fileChecksum(localFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(localSum, manifest), nil
+}
+
+// retiredBackups returns the remote backups missing locally that the local retention, keeping the newest keep
+// backups of the remote and the local ones, deletes; none if keep is 0
+func retiredBackups(remoteFiles []string, localFiles map[string]int64, profileName string, keep int) (map[string]bool, error) {
+	retired := map[string]bool{}
+	if keep <= 0 {
+		return retired, nil
+	}
+	all := []string{}
+	for f := range localFiles {
+		all = append(all, f)
+	}
+	for _, f := range remoteFiles {
+		if _, ok := localFiles[f]; !ok {
+			all = append(all, f)
+		}
+	}
+	toDelete, err := findToDelete(all, profileName, keep)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range toDelete {
+		if _, ok := localFiles[f]; !ok {
+			retired[f] = true
+		}
+	}
+	return retired, nil
+}
+
+// removeRemoteBackup deletes a remote backup file, and its checksum manifest if present, once it was verified
+func removeRemoteBackup(sftpc *sftp.Client, remoteFile string, hasChecksum bool, log *slog.Logger) error {
+	log.Info("deleting verified remote backup", "file", remoteFile)
+	err := sftpc.Remove(remoteFile)
+	if err != nil {
+		return fmt.Errorf("unable to delete remote file: %s, %v", remoteFile, err)
+	}
+	if hasChecksum {
+		err = sftpc.Remove(remoteFile + checksumExt)
+		if err != nil {
+			return fmt.Errorf("unable to delete remote file: %s, %v", remoteFile+checksumExt, err)
+		}
+	}
+	return nil
+}
+
+// mirrorDeletions deletes the local backups of a profile that do not exist anymore in the remote location
+func mirrorDeletions(remoteFiles []string, localFiles map[string]int64, profileName, destPath string, dryRun bool, log *slog.Logger) error {
+	local := make([]string, 0, len(localFiles))
+	for f := range localFiles {
+		local = append(local, f)
+	}
+	sort.Strings(local)
+
+	// swapping the arguments returns the local files missing in the remote
+	missing, err := findDifferentProfiles(local, remoteFiles, profileName)
+	if err != nil {
+		return err
+	}
+
+	// a split backup is only deleted if none of its volumes exist in the remote anymore
+	remoteSets := map[string]bool{}
+	for _, f := range remoteFiles {
+		remoteSets[zip.VolumeBase(f)] = true
+	}
+	toDelete := []string{}
+	for _, f := range missing {
+		if !remoteSets[zip.VolumeBase(f)] {
+			toDelete = append(toDelete, f)
+		}
+	}
+
+	for _, f := range toDelete {
+		if dryRun {
+			log.Info("dry-run: would delete local backup deleted in remote", "file", f)
+			continue
+		}
+		log.Info("deleting local backup deleted in remote", "file", f)
+		err = os.Remove(filepath.Join(destPath, f))
+		if err != nil {
+			return fmt.Errorf("unable to delete local file: %s, %v", f, err)
+		}
+	}
+	return nil
 }
 
 // sftpDownload uses an sftp client to download a remote file to a local destination
-func sftpDownload(sc *sftp.Client, remoteFile, localDest string) (err error) {
+// the content is written into a .part file first, if such a file already exists from an earlier
+// interrupted download of the same remote file, the transfer resumes from its current size.
+// The .part file keeps the modification time of the remote file, if it differs on a later attempt, the remote
+// file was replaced and the download starts over.
+// Once the size (and the sha256 checksum if not empty) is verified, the file is renamed to its final name.
+// The sha256 sum of the file, calculated while it is written, is returned.
+func sftpDownload(sc *sftp.Client, remoteFile, localDest, checksum string) (sum string, err error) {
 
 	// Note: SFTP To Go doesn't support O_RDWR mode
 	srcFile, err := sc.OpenFile(remoteFile, os.O_RDONLY)
 	if err != nil {
-		return fmt.Errorf("unable to open remote file: %v", err)
+		return "", fmt.Errorf("unable to open remote file: %v", err)
 	}
 	defer func() {
 		cErr := srcFile.Close()
@@ -85,35 +311,247 @@ func sftpDownload(sc *sftp.Client, remoteFile, localDest string) (err error) {
 			err = errors.Join(err, cErr)
 		}
 	}()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return "", fmt.Errorf("unable to stat remote file: %v", err)
+	}
+
+	partFile := localDest + partExt
 	// #nosec G304 -- path controlled by internal var
-	dstFile, err := os.Create(localDest)
+	dstFile, err := os.OpenFile(partFile, os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
-		return fmt.Errorf("unable to open local file: %v", err)
+		return "", fmt.Errorf("unable to open local file: %v", err)
 	}
 	defer func() {
+		if dstFile == nil {
+			return
+		}
 		cErr := dstFile.Close()
 		if cErr != nil {
 			err = errors.Join(err, cErr)
 		}
 	}()
 
-	_, err = io.Copy(dstFile, srcFile)
+	offset, err := resumeOffset(dstFile, srcInfo.Size(), srcInfo.ModTime())
+	if err != nil {
+		return "", err
+	}
+
+	// the content of a resumed download is hashed before the rest is appended
+	h := sha256.New()
+	err = hashPrefix(h, partFile, offset)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = srcFile.Seek(offset, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("unable to seek remote file: %v", err)
+	}
+
+	_, err = io.Copy(io.MultiWriter(dstFile, h), srcFile)
+	// set after every attempt, a part file written by a process that did not get here is never resumed
+	tErr := os.Chtimes(partFile, srcInfo.ModTime(), srcInfo.ModTime())
 	if err != nil {
-		return fmt.Errorf("unable to download remote file: %v", err)
+		return "", fmt.Errorf("unable to download remote file: %v", err)
+	}
+	if tErr != nil {
+		return "", fmt.Errorf("unable to set modification time of local file: %v", tErr)
 	}
 
+	err = dstFile.Sync()
+	if err != nil {
+		return "", fmt.Errorf("unable to sync local file: %v", err)
+	}
+	cErr := dstFile.Close()
+	dstFile = nil
+	if cErr != nil {
+		return "", fmt.Errorf("unable to close local file: %v", cErr)
+	}
+
+	sum = hex.EncodeToString(h.Sum(nil))
+	err = verifyDownload(partFile, srcInfo.Size(), checksum, sum)
+	if err != nil {
+		// the partial content cannot be trusted, start from scratch on the next attempt
+		_ = os.Remove(partFile)
+		return "", err
+	}
+
+	err = os.Rename(partFile, localDest)
+	if err != nil {
+		return "", fmt.Errorf("unable to rename downloaded file: %v", err)
+	}
+	return sum, nil
+}
+
+// resumeOffset returns the position from where a partial download should continue,
+// if the local part file is bigger than the remote file or its modification time is not the one of the
+// remote file, it is truncated and the download starts over
+func resumeOffset(f *os.File, remoteSize int64, remoteMod time.Time) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("unable to stat local file: %v", err)
+	}
+
+	offset := info.Size()
+	// sftp transfers the modification time in seconds
+	if offset > remoteSize || (offset > 0 && info.ModTime().Unix() != remoteMod.Unix()) {
+		err = f.Truncate(0)
+		if err != nil {
+			return 0, fmt.Errorf("unable to truncate local file: %v", err)
+		}
+		offset = 0
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("unable to seek local file: %v", err)
+	}
+	return offset, nil
+}
+
+// verifyDownload checks that the downloaded file has the expected size and, if provided, that its sha256 sum
+// calculated during the download is the expected checksum
+func verifyDownload(file string, size int64, checksum, sum string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("unable to stat downloaded file: %v", err)
+	}
+	if info.Size() != size {
+		return fmt.Errorf("size mismatch on downloaded file: got %d bytes, expected %d", info.Size(), size)
+	}
+
+	if checksum != "" && !strings.EqualFold(sum, checksum) {
+		return fmt.Errorf("checksum mismatch on downloaded file: got %s, expected %s", sum, checksum)
+	}
 	return nil
 }
 
+// hashPrefix writes the first n bytes of a local file into h
+func hashPrefix(h io.Writer, file string, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	// #nosec G304 -- path controlled by internal var
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("unable to open local file: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	_, err = io.CopyN(h, f, n)
+	if err != nil {
+		return fmt.Errorf("unable to calculate checksum of partial download: %v", err)
+	}
+	return nil
+}
+
+// fileChecksum returns the hex encoded sha256 sum of a local file
+func fileChecksum(file string) (string, error) {
+	// #nosec G304 -- path controlled by internal var
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("unable to calculate checksum: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readRemoteChecksum reads a sha256sum style manifest: "<hex>  <filename>"
+// if the manifest does not exist an empty string is returned
+func readRemoteChecksum(sc *sftp.Client, manifest string) (sum string, err error) {
+	f, err := sc.Open(manifest)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to open checksum manifest: %v", err)
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+
+	return parseChecksum(f)
+}
+
+// parseChecksum extracts the hex encoded sha256 sum out of the first line of a sha256sum output
+func parseChecksum(in io.Reader) (string, error) {
+	scanner := bufio.NewScanner(in)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", fmt.Errorf("unable to read checksum manifest: %v", err)
+		}
+		return "", errors.New("checksum manifest is empty")
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) == 0 {
+		return "", errors.New("checksum manifest is empty")
+	}
+
+	sum := fields[0]
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("checksum manifest contains an invalid sha256 sum: %s", sum)
+	}
+	return sum, nil
+}
+
+// volumeSets groups file names by backup, the volumes of a split backup end up in the same group;
+// the order of the groups and of the files within them is kept
+func volumeSets(files []string) [][]string {
+	index := map[string]int{}
+	sets := [][]string{}
+	for _, f := range files {
+		base := zip.VolumeBase(f)
+		i, ok := index[base]
+		if !ok {
+			i = len(sets)
+			index[base] = i
+			sets = append(sets, nil)
+		}
+		sets[i] = append(sets[i], f)
+	}
+	return sets
+}
+
+// findChangedProfiles returns the remote files that are missing locally, as well as the ones
+// that exist in both locations but with a different size, e.g. because of an interrupted download
+func findChangedProfiles(remote []string, remoteSizes, localSizes map[string]int64, name string) ([]string, error) {
+
+	// passing no local files returns all the remote files that match the profile name
+	matching, err := findDifferentProfiles(remote, nil, name)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for _, f := range matching {
+		localSize, found := localSizes[f]
+		if found && localSize == remoteSizes[f] {
+			continue
+		}
+		changed = append(changed, f)
+	}
+	return changed, nil
+}
+
 // findDifferentProfiles takes two lists of profile names, and a name as pattern
 // and returns a list of files to be pulled from remote
 func findDifferentProfiles(remote []string, local []string, name string) ([]string, error) {
 
-	// this glob patter matches any file with the pattern: name_2006_02_01-15:04:05_backup.zip
-	pattern := name + "_[0-9][0-9][0-9][0-9]_[0-9][0-9]_[0-9][0-9]-[0-9][0-9]:[0-9][0-9]:[0-9][0-9]_backup.zip"
-	g, err := glob.Compile(pattern)
+	g, err := backupGlob(name)
 	if err != nil {
-		return nil, fmt.Errorf("glop pattern for '%s' does not compile: %v", name, err)
+		return nil, err
 	}
 
 	var remoteMatches []string
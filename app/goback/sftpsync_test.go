@@ -2,13 +2,20 @@ package goback
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
 	"github.com/AndresBott/goback/lib/ssh"
 	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/sftp"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPullProfiles(t *testing.T) {
@@ -71,7 +78,7 @@ func TestPullProfiles(t *testing.T) {
 			_ = sftpClient.Close()
 		}()
 
-		err = syncRemoteBackups(sftpClient, "/backupDestination", "blib", tmpdir, logger.SilentLogger())
+		err = syncRemoteBackups(sftpClient, "/backupDestination", "blib", tmpdir, profile.Sync{}, 0, logger.SilentLogger())
 		if err != nil {
 			t.Fatalf("unexpected error %v", err)
 		}
@@ -147,6 +154,24 @@ func TestFindDifferentProfiles(t *testing.T) {
 			},
 		},
 
+		{
+			tcName: "volumes of split backups",
+			remote: []string{
+				"blib_2006_02_05-17:04:05_backup.zip.001",
+				"blib_2006_02_05-17:04:05_backup.zip.002",
+				"blib_2006_02_05-17:04:05_backup.zip.002.sha256",
+				"blib_2007_02_05-17:04:05_backup.zip.001",
+			},
+			local: []string{
+				"blib_2006_02_05-17:04:05_backup.zip.001",
+			},
+			prfName: "blib",
+			want: []string{
+				"blib_2006_02_05-17:04:05_backup.zip.002",
+				"blib_2007_02_05-17:04:05_backup.zip.001",
+			},
+		},
+
 		{
 			tcName: "empty remote",
 			remote: []string{
@@ -167,3 +192,396 @@ func TestFindDifferentProfiles(t *testing.T) {
 		})
 	}
 }
+
+func TestFindChangedProfiles(t *testing.T) {
+	remote := []string{
+		"blib_2006_02_05-17:04:05_backup.zip",
+		"ble_2008_02_05-17:04:05_backup.zip",
+		"blib_2011_02_05-17:04:05_backup.zip",
+		"blib_2012_02_05-17:04:05_backup.zip",
+	}
+	remoteSizes := map[string]int64{
+		"blib_2006_02_05-17:04:05_backup.zip": 10,
+		"ble_2008_02_05-17:04:05_backup.zip":  10,
+		"blib_2011_02_05-17:04:05_backup.zip": 10,
+		"blib_2012_02_05-17:04:05_backup.zip": 10,
+	}
+	local := map[string]int64{
+		"blib_2006_02_05-17:04:05_backup.zip":      10,
+		"blib_2011_02_05-17:04:05_backup.zip":      4, // interrupted download
+		"blib_2012_02_05-17:04:05_backup.zip.part": 4,
+	}
+
+	got, err := findChangedProfiles(remote, remoteSizes, local, "blib")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []string{
+		"blib_2011_02_05-17:04:05_backup.zip",
+		"blib_2012_02_05-17:04:05_backup.zip",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	tcs := []struct {
+		name      string
+		in        string
+		want      string
+		expectErr string
+	}{
+		{
+			name: "sha256sum output",
+			in:   "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08  blib_2011_02_05-17:04:05_backup.zip\n",
+			want: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		},
+		{
+			name:      "empty manifest",
+			in:        "",
+			expectErr: "checksum manifest is empty",
+		},
+		{
+			name:      "invalid sum",
+			in:        "not-a-sum  file.zip",
+			expectErr: "checksum manifest contains an invalid sha256 sum: not-a-sum",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseChecksum(strings.NewReader(tc.in))
+			if tc.expectErr != "" {
+				if err == nil {
+					t.Fatalf("expected error: %s, but got none", tc.expectErr)
+				}
+				if err.Error() != tc.expectErr {
+					t.Errorf("unexpected error, got: %s, want: %s", err.Error(), tc.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMirrorDeletions(t *testing.T) {
+	setup := func(t *testing.T) (string, map[string]int64) {
+		tmpdir := t.TempDir()
+		local := map[string]int64{}
+		files := []string{
+			"bla",
+			"blib_2009_02_05-17:04:05_backup.zip",
+			"blib_2011_02_05-17:04:05_backup.zip",
+			"ble_2009_02_05-17:04:05_backup.zip",
+		}
+		for _, f := range files {
+			e := os.WriteFile(filepath.Join(tmpdir, f), []byte("hello\n"), 0600)
+			if e != nil {
+				t.Fatal(e)
+			}
+			local[f] = 6
+		}
+		return tmpdir, local
+	}
+	remote := []string{
+		"blib_2011_02_05-17:04:05_backup.zip",
+		"blib_2012_02_05-17:04:05_backup.zip",
+	}
+
+	listDir := func(t *testing.T, dir string) []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		return got
+	}
+
+	t.Run("delete local backups missing in remote", func(t *testing.T) {
+		tmpdir, local := setup(t)
+		err := mirrorDeletions(remote, local, "blib", tmpdir, false, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		want := []string{
+			"bla",
+			"ble_2009_02_05-17:04:05_backup.zip",
+			"blib_2011_02_05-17:04:05_backup.zip",
+		}
+		if diff := cmp.Diff(want, listDir(t, tmpdir)); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("split backups are deleted only if no volume exists in remote", func(t *testing.T) {
+		tmpdir := t.TempDir()
+		local := map[string]int64{}
+		for _, f := range []string{
+			"blib_2009_02_05-17:04:05_backup.zip.001",
+			"blib_2009_02_05-17:04:05_backup.zip.002",
+			"blib_2011_02_05-17:04:05_backup.zip.001",
+			"blib_2011_02_05-17:04:05_backup.zip.002",
+		} {
+			if e := os.WriteFile(filepath.Join(tmpdir, f), []byte("hello\n"), 0600); e != nil {
+				t.Fatal(e)
+			}
+			local[f] = 6
+		}
+		remoteVolumes := []string{
+			"blib_2011_02_05-17:04:05_backup.zip.001",
+		}
+
+		err := mirrorDeletions(remoteVolumes, local, "blib", tmpdir, false, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		want := []string{
+			"blib_2011_02_05-17:04:05_backup.zip.001",
+			"blib_2011_02_05-17:04:05_backup.zip.002",
+		}
+		if diff := cmp.Diff(want, listDir(t, tmpdir)); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("dry run does not delete", func(t *testing.T) {
+		tmpdir, local := setup(t)
+		err := mirrorDeletions(remote, local, "blib", tmpdir, true, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if len(listDir(t, tmpdir)) != 4 {
+			t.Errorf("expected no file to be deleted in dry-run mode")
+		}
+	})
+}
+
+// localSftp returns an sftp client served in process from the local file system
+func localSftp(t *testing.T) *sftp.Client {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve()
+	}()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client
+}
+
+func TestSftpDownload(t *testing.T) {
+	content := "hello world, this is a remote backup\n"
+	sum := sha256.Sum256([]byte(content))
+	remoteMod := time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC)
+
+	tcs := []struct {
+		name string
+		// part is the content of a .part file left by an earlier attempt, none if empty
+		part     string
+		partMod  time.Time
+		checksum string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "download with checksum",
+			checksum: hex.EncodeToString(sum[:]),
+			want:     content,
+		},
+		{
+			// the differing prefix shows that only the rest of the file was downloaded
+			name:    "resume partial download",
+			part:    "HELLO",
+			partMod: remoteMod,
+			want:    "HELLO" + content[5:],
+		},
+		{
+			name:    "remote file changed since the last attempt",
+			part:    "HELLO",
+			partMod: remoteMod.Add(-time.Hour),
+			want:    content,
+		},
+		{
+			name:     "checksum mismatch",
+			part:     "HELLO",
+			partMod:  remoteMod,
+			checksum: hex.EncodeToString(sum[:]),
+			wantErr:  "checksum mismatch on downloaded file",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			remoteFile := filepath.Join(dir, "remote.zip")
+			if err := os.WriteFile(remoteFile, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(remoteFile, remoteMod, remoteMod); err != nil {
+				t.Fatal(err)
+			}
+			localFile := filepath.Join(dir, "local.zip")
+			if tc.part != "" {
+				if err := os.WriteFile(localFile+partExt, []byte(tc.part), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(localFile+partExt, tc.partMod, tc.partMod); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			gotSum, err := sftpDownload(localSftp(t), remoteFile, localFile, tc.checksum)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+				}
+				// the partial content cannot be trusted, nothing is kept
+				for _, f := range []string{localFile, localFile + partExt} {
+					if _, sErr := os.Stat(f); !os.IsNotExist(sErr) {
+						t.Errorf("expected %s to be removed", f)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := os.ReadFile(localFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+			// the sum of a resumed download includes the part downloaded earlier
+			wantSum := sha256.Sum256([]byte(tc.want))
+			if gotSum != hex.EncodeToString(wantSum[:]) {
+				t.Errorf("got sum %s, want the sum of the local file", gotSum)
+			}
+			if _, err := os.Stat(localFile + partExt); !os.IsNotExist(err) {
+				t.Error("expected the part file to be renamed")
+			}
+		})
+	}
+}
+
+func TestSyncRemoveRemote(t *testing.T) {
+	backup := func(year int) string {
+		return fmt.Sprintf("blib_%d_02_05-17:04:05_backup.zip", year)
+	}
+	manifest := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:]) + "  backup.zip\n"
+	}
+
+	remote := map[string]string{
+		// deleted locally by the retention of 4 backups, it counts as moved
+		backup(2009): "retired backup",
+		// downloaded in an earlier run without manifest, it can only be verified by reading the remote file
+		backup(2011): "old backup",
+		// downloaded in an earlier run, verified against the manifest
+		backup(2012):               "with manifest",
+		backup(2012) + checksumExt: manifest("with manifest"),
+		// the local copy has the same size but a different content
+		backup(2013):               "corrupt",
+		backup(2013) + checksumExt: manifest("corrupt"),
+		// a split backup with one verified and one corrupt volume is kept as a whole
+		backup(2014) + ".001":               "volume one",
+		backup(2014) + ".001" + checksumExt: manifest("volume one"),
+		backup(2014) + ".002":               "volume two",
+		backup(2014) + ".002" + checksumExt: manifest("volume two"),
+		// downloaded in this run, verified by the sum calculated during the download
+		backup(2015):                           "new backup",
+		"other_2011_02_05-17:04:05_backup.zip": "other profile",
+	}
+	local := map[string]string{
+		backup(2011):          "old backup",
+		backup(2012):          "with manifest",
+		backup(2013):          "CORRUPT",
+		backup(2014) + ".001": "volume one",
+		backup(2014) + ".002": "VOLUME TWO",
+	}
+
+	setup := func(t *testing.T) (string, string) {
+		remoteDir := t.TempDir()
+		localDir := t.TempDir()
+		for dir, files := range map[string]map[string]string{remoteDir: remote, localDir: local} {
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return remoteDir, localDir
+	}
+	list := func(t *testing.T, dir string) []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		return got
+	}
+
+	t.Run("delete verified remote backups", func(t *testing.T) {
+		remoteDir, localDir := setup(t)
+		opts := profile.Sync{RemoveRemote: true}
+		err := syncRemoteBackups(localSftp(t), remoteDir, "blib", localDir, opts, 4, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		want := []string{
+			backup(2011),
+			backup(2013),
+			backup(2013) + checksumExt,
+			backup(2014) + ".001",
+			backup(2014) + ".001" + checksumExt,
+			backup(2014) + ".002",
+			backup(2014) + ".002" + checksumExt,
+			"other_2011_02_05-17:04:05_backup.zip",
+		}
+		if diff := cmp.Diff(want, list(t, remoteDir)); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+		// the retired backup is not downloaded
+		if _, err = os.Stat(filepath.Join(localDir, backup(2009))); !os.IsNotExist(err) {
+			t.Errorf("expected the retired backup not to be downloaded, got %v", err)
+		}
+	})
+
+	t.Run("dry run keeps all remote backups", func(t *testing.T) {
+		remoteDir, localDir := setup(t)
+		opts := profile.Sync{RemoveRemote: true, DryRun: true}
+		err := syncRemoteBackups(localSftp(t), remoteDir, "blib", localDir, opts, 4, logger.SilentLogger())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if diff := cmp.Diff(len(remote), len(list(t, remoteDir))); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
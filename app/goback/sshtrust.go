@@ -0,0 +1,32 @@
+package goback
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/ssh"
+)
+
+// TrustProfileHosts connects to the ssh host of a profile, and its jump hosts, calling confirm for every host
+// whose key is not known yet; confirmed keys are recorded in the known_hosts file managed by goback.
+func TrustProfileHosts(prfl profile.Profile, confirm ssh.TrustFunc) error {
+	if !slices.Contains([]profile.ProfileType{profile.TypeRemote, profile.TypeSftpSync, profile.TypeSftpPush}, prfl.Type) {
+		return fmt.Errorf("profile %s of type %s does not use ssh", prfl.Name, prfl.Type)
+	}
+
+	sshC, err := ssh.New(sshCfg(prfl.Ssh, confirm))
+	if err != nil {
+		return fmt.Errorf("error creating ssh client: %v", err)
+	}
+
+	err = sshC.Connect()
+	if err != nil {
+		if errors.Is(err, ssh.ErrUnknownHostKey) {
+			return fmt.Errorf("host key was not trusted: %v", err)
+		}
+		return fmt.Errorf("error connecting ssh: %v", err)
+	}
+	return sshC.Disconnect()
+}
@@ -0,0 +1,332 @@
+package goback
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/AndresBott/goback/lib/cron"
+)
+
+// DefaultUnitDir is the directory systemd units are installed into
+const DefaultUnitDir = "/etc/systemd/system"
+
+// SystemdOptions configures the generated systemd units
+type SystemdOptions struct {
+	// Binary is the absolute path of the goback executable used in ExecStart
+	Binary string
+	// OnFailure is the unit started when a backup fails, e.g. "notify-failure@%n.service"; not set if empty
+	OnFailure string
+	// MetricsFile is the node_exporter textfile the metrics of the runs are written to; not written if empty
+	MetricsFile string
+	// LockDir and HistoryFile are passed to the services, so that they share the locks and the history with
+	// manual runs of the same user; DefaultLockDir and DefaultHistoryFile are used if empty
+	LockDir     string
+	HistoryFile string
+}
+
+// SystemdUnit is the name and content of a generated unit file
+type SystemdUnit struct {
+	Name    string
+	Content string
+	// Dirs are the directories the unit writes into, created on install since systemd fails to start
+	// a service whose ReadWritePaths don't exist; the destination is not part of them, it must exist
+	Dirs []string
+}
+
+// SystemdUnits returns a service and a timer unit for every profile in dir with a schedule,
+// profiles without schedule are skipped
+func SystemdUnits(dir string, opts SystemdOptions, log *slog.Logger) ([]SystemdUnit, error) {
+	if opts.Binary == "" {
+		return nil, errors.New("goback binary path cannot be empty")
+	}
+	if opts.LockDir == "" {
+		opts.LockDir = DefaultLockDir()
+	}
+	if opts.HistoryFile == "" {
+		opts.HistoryFile = DefaultHistoryFile()
+	}
+
+	files, err := profileFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var units []SystemdUnit
+	names := map[string]string{}
+	for _, file := range files {
+		prfl, err := profile.LoadProfile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load profile %s: %v", file, err)
+		}
+		if prfl.Schedule == "" {
+			log.Info("skipping profile without schedule", "profile", prfl.Name)
+			continue
+		}
+		if other, ok := names[prfl.Name]; ok {
+			return nil, fmt.Errorf("profile name %s is used in %s and %s", prfl.Name, other, file)
+		}
+		names[prfl.Name] = file
+
+		sched, err := cron.Parse(prfl.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", prfl.Name, err)
+		}
+		dirs := []string{opts.LockDir, filepath.Dir(opts.HistoryFile)}
+		if knownHosts := tofuKnownHosts(prfl); knownHosts != "" {
+			dirs = append(dirs, filepath.Dir(knownHosts))
+		}
+		units = append(units,
+			SystemdUnit{Name: unitName(prfl.Name, ".service"), Content: serviceUnit(prfl, file, opts), Dirs: dirs},
+			SystemdUnit{Name: unitName(prfl.Name, ".timer"), Content: timerUnit(prfl, file, sched)},
+		)
+	}
+	return units, nil
+}
+
+// serviceUnit returns the oneshot service running the profile, the service can only write into the
+// destination, the lock dir, the history and, with trust on first use, the known_hosts file
+func serviceUnit(prfl profile.Profile, file string, opts SystemdOptions) string {
+	var sb strings.Builder
+	w := func(format string, a ...any) {
+		_, _ = fmt.Fprintf(&sb, format+"\n", a...)
+	}
+
+	w("# %s generated by goback from %s", unitName(prfl.Name, ".service"), file)
+	w("[Unit]")
+	w("Description=goback backup of profile %s", prfl.Name)
+	w("Wants=network-online.target")
+	w("After=network-online.target")
+	if opts.OnFailure != "" {
+		w("OnFailure=%s", opts.OnFailure)
+	}
+	w("")
+	w("[Service]")
+	w("Type=oneshot")
+	// profiles sharing a destination with a running one wait for it instead of failing
+	args := []string{opts.Binary, "backup", "--wait", "--lock-dir", opts.LockDir, "--history", opts.HistoryFile}
+	if opts.MetricsFile != "" {
+		args = append(args, "--metrics-file", opts.MetricsFile)
+	}
+	args = append(args, file)
+	for i, arg := range args {
+		args[i] = execArg(arg)
+	}
+	w("ExecStart=%s", strings.Join(args, " "))
+	w("Nice=10")
+	w("IOSchedulingClass=idle")
+	w("NoNewPrivileges=true")
+	w("PrivateTmp=true")
+	w("ProtectSystem=strict")
+	w("ProtectHome=read-only")
+	w("ProtectKernelTunables=true")
+	w("ProtectKernelModules=true")
+	w("ProtectControlGroups=true")
+	w("ReadWritePaths=%s", quoteArg(opts.LockDir))
+	w("ReadWritePaths=%s", quoteArg(filepath.Dir(opts.HistoryFile)))
+	if knownHosts := tofuKnownHosts(prfl); knownHosts != "" {
+		// trusted host keys are recorded in the known_hosts file
+		w("ReadWritePaths=%s", quoteArg(filepath.Dir(knownHosts)))
+	}
+	// the destination of sftppush profiles is on the remote host
+	if prfl.Type != profile.TypeSftpPush {
+		w("ReadWritePaths=%s", quoteArg(prfl.Destination.Path))
+	}
+	if opts.MetricsFile != "" {
+		// the textfile is replaced by renaming a temp file in the same directory
+		w("ReadWritePaths=%s", quoteArg(filepath.Dir(opts.MetricsFile)))
+	}
+	return sb.String()
+}
+
+// tofuKnownHosts returns the known_hosts file the profile records unknown host keys in, empty if it doesn't trust on first use
+func tofuKnownHosts(prfl profile.Profile) string {
+	if prfl.Type == profile.TypeLocal || !prfl.Ssh.TrustOnFirstUse {
+		return ""
+	}
+	if prfl.Ssh.KnownHosts != "" {
+		return prfl.Ssh.KnownHosts
+	}
+	return DefaultKnownHostsFile()
+}
+
+// createDirs creates the directories if they don't exist
+func createDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return fmt.Errorf("unable to create dir %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// timerUnit returns the timer starting the service on the profile schedule, runs missed while the
+// host was down are started on the next boot
+func timerUnit(prfl profile.Profile, file string, sched *cron.Schedule) string {
+	var sb strings.Builder
+	w := func(format string, a ...any) {
+		_, _ = fmt.Fprintf(&sb, format+"\n", a...)
+	}
+
+	w("# %s generated by goback from %s", unitName(prfl.Name, ".timer"), file)
+	w("# schedule: %s", prfl.Schedule)
+	w("[Unit]")
+	w("Description=goback schedule of profile %s", prfl.Name)
+	w("")
+	w("[Timer]")
+	for _, cal := range sched.OnCalendar() {
+		w("OnCalendar=%s", cal)
+	}
+	w("Persistent=true")
+	w("")
+	w("[Install]")
+	w("WantedBy=timers.target")
+	return sb.String()
+}
+
+// InstallSystemdUnits creates the dirs of the units, writes the units into unitDir, reloads systemd and enables
+// and starts the timers
+func InstallSystemdUnits(units []SystemdUnit, unitDir string, log *slog.Logger) error {
+	var timers []string
+	for _, u := range units {
+		err := createDirs(u.Dirs...)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(unitDir, u.Name)
+		// #nosec G306 -- unit files are world readable
+		err = os.WriteFile(path, []byte(u.Content), 0644)
+		if err != nil {
+			return fmt.Errorf("unable to write unit file: %v", err)
+		}
+		log.Info("unit installed", "file", path)
+		if strings.HasSuffix(u.Name, ".timer") {
+			timers = append(timers, u.Name)
+		}
+	}
+	if len(timers) == 0 {
+		return nil
+	}
+
+	err := systemctl("daemon-reload")
+	if err != nil {
+		return err
+	}
+	return systemctl(append([]string{"enable", "--now"}, timers...)...)
+}
+
+// UninstallSystemdUnits stops and disables the timers of the profiles in dir and deletes their units from unitDir
+func UninstallSystemdUnits(dir, unitDir string, log *slog.Logger) error {
+	profiles, err := profile.LoadProfiles(dir)
+	if err != nil {
+		// units of the valid profiles are still removed
+		log.Error("unable to load some profiles", "dir", dir, "error", err)
+	}
+
+	var timers, files []string
+	for _, prfl := range profiles {
+		for _, ext := range []string{".timer", ".service"} {
+			name := unitName(prfl.Name, ext)
+			path := filepath.Join(unitDir, name)
+			_, err := os.Stat(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if ext == ".timer" {
+				timers = append(timers, name)
+			}
+			files = append(files, path)
+		}
+	}
+	if len(files) == 0 {
+		log.Info("no units installed for the profiles", "dir", dir)
+		return nil
+	}
+
+	if len(timers) > 0 {
+		err = systemctl(append([]string{"disable", "--now"}, timers...)...)
+		if err != nil {
+			return err
+		}
+	}
+	for _, path := range files {
+		err = os.Remove(path)
+		if err != nil {
+			return fmt.Errorf("unable to delete unit file: %v", err)
+		}
+		log.Info("unit removed", "file", path)
+	}
+	return systemctl("daemon-reload")
+}
+
+// systemctl runs systemctl with the arguments, exposed internally for testing purposes only
+var systemctl = func(args ...string) error {
+	// #nosec G204 -- arguments are unit names generated by goback
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// profileFiles returns the profile files in a directory
+func profileFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, e os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !e.IsDir() && strings.HasSuffix(e.Name(), profile.ProfileExt) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read profile directory: %v", err)
+	}
+	return files, nil
+}
+
+// unitName returns the name of a unit of the profile, characters not allowed in unit names are
+// escaped like systemd-escape does
+func unitName(name, ext string) string {
+	var sb strings.Builder
+	sb.WriteString("goback-")
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == ':', c == '_', c == '-', c == '.':
+			sb.WriteByte(c)
+		default:
+			_, _ = fmt.Fprintf(&sb, `\x%02x`, c)
+		}
+	}
+	sb.WriteString(ext)
+	return sb.String()
+}
+
+// execArg quotes an argument of ExecStart, specifiers and variables are escaped so that it is used literally
+func execArg(in string) string {
+	return quoteArg(strings.ReplaceAll(in, "$", "$$"))
+}
+
+// quoteArg quotes a value of a unit setting if needed, specifiers are escaped so that it is used literally
+func quoteArg(in string) string {
+	in = strings.ReplaceAll(in, "%", "%%")
+	if !strings.ContainsAny(in, " \t\"'\\;") {
+		return in
+	}
+	in = strings.ReplaceAll(in, `\`, `\\`)
+	in = strings.ReplaceAll(in, `"`, `\"`)
+	return `"` + in + `"`
+}
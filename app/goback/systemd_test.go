@@ -0,0 +1,229 @@
+package goback
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSystemdUnits(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, data string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("daily.backup.yaml", `version: 1
+name: my daily
+type: local
+schedule: "0 3 15 * fri"
+dirs:
+  - path: /tmp
+destination:
+  path: /backup/daily 50%
+`)
+	state := t.TempDir()
+	knownHosts := filepath.Join(state, "ssh", "known_hosts")
+	writeFile("sync.backup.yaml", `version: 1
+name: sync
+type: sftpsync
+schedule: "0 4 * * *"
+ssh:
+  type: password
+  host: backup.example.com
+  user: backup
+  password: secret
+  trustOnFirstUse: true
+  knownHosts: `+knownHosts+`
+dirs:
+  - path: /backup/service1
+    name: service1
+destination:
+  path: /backup/sync
+`)
+	writeFile("manual.backup.yaml", `version: 1
+name: manual
+type: local
+dirs:
+  - path: /tmp
+destination:
+  path: /backup
+`)
+
+	units, err := SystemdUnits(dir, SystemdOptions{
+		Binary:      "/usr/bin/goback",
+		OnFailure:   "notify@%n.service",
+		MetricsFile: "/var/lib/node_exporter/textfile_collector/goback.prom",
+		LockDir:     filepath.Join(state, "locks"),
+		HistoryFile: filepath.Join(state, "history", "history.jsonl"),
+	}, logger.SilentLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	file := filepath.Join(dir, "daily.backup.yaml")
+	want := []SystemdUnit{
+		{
+			Name: `goback-my\x20daily.service`,
+			Content: `# goback-my\x20daily.service generated by goback from ` + file + `
+[Unit]
+Description=goback backup of profile my daily
+Wants=network-online.target
+After=network-online.target
+OnFailure=notify@%n.service
+
+[Service]
+Type=oneshot
+ExecStart=/usr/bin/goback backup --wait --lock-dir ` + state + `/locks --history ` + state + `/history/history.jsonl --metrics-file /var/lib/node_exporter/textfile_collector/goback.prom ` + file + `
+Nice=10
+IOSchedulingClass=idle
+NoNewPrivileges=true
+PrivateTmp=true
+ProtectSystem=strict
+ProtectHome=read-only
+ProtectKernelTunables=true
+ProtectKernelModules=true
+ProtectControlGroups=true
+ReadWritePaths=` + state + `/locks
+ReadWritePaths=` + state + `/history
+ReadWritePaths="/backup/daily 50%%"
+ReadWritePaths=/var/lib/node_exporter/textfile_collector
+`,
+			Dirs: []string{state + "/locks", state + "/history"},
+		},
+		{
+			Name: `goback-my\x20daily.timer`,
+			Content: `# goback-my\x20daily.timer generated by goback from ` + file + `
+# schedule: 0 3 15 * fri
+[Unit]
+Description=goback schedule of profile my daily
+
+[Timer]
+OnCalendar=*-*-15 03:00:00
+OnCalendar=Fri *-*-* 03:00:00
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`,
+		},
+	}
+	if diff := cmp.Diff(want, units[:2]); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+
+	// the known_hosts dir is writable for trust on first use
+	if len(units) != 4 {
+		t.Fatalf("expected the units of 2 profiles, got %d units", len(units))
+	}
+	if !strings.Contains(units[2].Content, "\nReadWritePaths="+state+"/ssh\n") {
+		t.Errorf("expected the known_hosts dir to be writable, got:\n%s", units[2].Content)
+	}
+	if diff := cmp.Diff([]string{state + "/locks", state + "/history", state + "/ssh"}, units[2].Dirs); diff != "" {
+		t.Errorf("dirs mismatch (-want +got):\n%s", diff)
+	}
+
+	// generating the units doesn't touch the filesystem, the dirs are created on install
+	entries, err := os.ReadDir(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no dirs to be created, got %d entries", len(entries))
+	}
+}
+
+func TestExecArg(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want string
+	}{
+		{in: "/usr/bin/goback", want: "/usr/bin/goback"},
+		{in: "/etc/goback/my profile.backup.yaml", want: `"/etc/goback/my profile.backup.yaml"`},
+		{in: `/a"b\c`, want: `"/a\"b\\c"`},
+		{in: "/a/$HOME/100%", want: "/a/$$HOME/100%%"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.in, func(t *testing.T) {
+			if got := execArg(tc.in); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstallSystemdUnits(t *testing.T) {
+	var calls []string
+	orig := systemctl
+	systemctl = func(args ...string) error {
+		calls = append(calls, strings.Join(args, " "))
+		return nil
+	}
+	defer func() { systemctl = orig }()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "bla.backup.yaml"), []byte(`version: 1
+name: bla
+type: local
+schedule: "@daily"
+dirs:
+  - path: /tmp
+destination:
+  path: /backup
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unitDir := t.TempDir()
+	state := t.TempDir()
+
+	units, err := SystemdUnits(dir, SystemdOptions{
+		Binary:      "/usr/bin/goback",
+		LockDir:     filepath.Join(state, "locks"),
+		HistoryFile: filepath.Join(state, "history", "history.jsonl"),
+	}, logger.SilentLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = InstallSystemdUnits(units, unitDir, logger.SilentLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"goback-bla.service", "goback-bla.timer"} {
+		if _, err := os.Stat(filepath.Join(unitDir, name)); err != nil {
+			t.Errorf("expected unit %s to be installed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"locks", "history"} {
+		if _, err := os.Stat(filepath.Join(state, name)); err != nil {
+			t.Errorf("expected dir %s to be created: %v", name, err)
+		}
+	}
+
+	err = UninstallSystemdUnits(dir, unitDir, logger.SilentLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, err := os.ReadDir(unitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected all units to be removed, got %d files", len(entries))
+	}
+
+	wantCalls := []string{
+		"daemon-reload",
+		"enable --now goback-bla.timer",
+		"disable --now goback-bla.timer",
+		"daemon-reload",
+	}
+	if diff := cmp.Diff(wantCalls, calls); diff != "" {
+		t.Errorf("systemctl calls mismatch (-want +got):\n%s", diff)
+	}
+}
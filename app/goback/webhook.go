@@ -0,0 +1,172 @@
+package goback
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/AndresBott/goback/app/metainfo"
+	"github.com/AndresBott/goback/internal/profile"
+)
+
+const (
+	// signatureHeader holds the HMAC-SHA256 of the body, signed with the webhook secret
+	signatureHeader       = "X-Goback-Signature"
+	defaultWebhookRetries = 3
+	webhookTimeout        = 10 * time.Second
+)
+
+// exposed internally for testing purposes only
+var webhookRetryDelay = 2 * time.Second
+
+// webhookPayload is the JSON document posted to webhooks; title and text summarise the run for chat
+// services, text is repeated as content and message, the fields used by Discord and Gotify
+type webhookPayload struct {
+	Profile         string    `json:"profile"`
+	Type            string    `json:"type"`
+	Status          string    `json:"status"`
+	Host            string    `json:"host"`
+	Start           time.Time `json:"start"`
+	Duration        string    `json:"duration"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Archive         string    `json:"archive,omitempty"`
+	ArchiveSize     int64     `json:"archiveSize,omitempty"`
+	Error           string    `json:"error,omitempty"`
+
+	Title   string `json:"title,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Content string `json:"content,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func newWebhookPayload(res RunResult) webhookPayload {
+	host, _ := os.Hostname()
+	p := webhookPayload{
+		Profile:         res.Profile,
+		Type:            string(res.Type),
+		Status:          res.status(),
+		Host:            host,
+		Start:           res.Start,
+		Duration:        res.Duration.Round(time.Second).String(),
+		DurationSeconds: res.Duration.Seconds(),
+		Archive:         res.Archive,
+		ArchiveSize:     res.Size,
+	}
+
+	p.Title = fmt.Sprintf("goback: profile %s succeeded", res.Profile)
+	p.Text = fmt.Sprintf("✅ Backup of profile %s on %s completed in %s", res.Profile, host, p.Duration)
+	if res.Skipped {
+		p.Title = fmt.Sprintf("goback: profile %s skipped", res.Profile)
+		p.Text = fmt.Sprintf("⏭️ Backup of profile %s on %s skipped, it is locked by another run", res.Profile, host)
+	}
+	if res.Err != nil {
+		p.Error = res.Err.Error()
+		p.Title = fmt.Sprintf("goback: profile %s failed", res.Profile)
+		p.Text = fmt.Sprintf("❌ Backup of profile %s on %s failed after %s: %s", res.Profile, host, p.Duration, p.Error)
+	}
+	p.Content = p.Text
+	p.Message = p.Text
+	return p
+}
+
+// sendWebhook posts the result of a run to the webhook
+func sendWebhook(hook profile.Webhook, res RunResult) error {
+	return postJSON(hook, newWebhookPayload(res))
+}
+
+// postJSON posts the payload as JSON document to the webhook
+func postJSON(hook profile.Webhook, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(hook, "application/json", body)
+}
+
+// post sends the body to the webhook, requests failing because of the network, a server error or
+// rate limiting are retried with an increasing delay
+func post(hook profile.Webhook, contentType string, body []byte) error {
+	// the url is not part of the errors since it often contains a token
+	u, err := url.Parse(hook.Url)
+	if err != nil {
+		return errors.New("invalid webhook url")
+	}
+
+	retries := hook.Retries
+	if retries == 0 {
+		retries = defaultWebhookRetries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := postWebhook(hook, contentType, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= retries {
+			return fmt.Errorf("webhook to %s failed: %v", u.Host, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postWebhook sends a single request, it returns if the request should be retried on error
+func postWebhook(hook profile.Webhook, contentType string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.New("unable to create request")
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "goback/"+metainfo.Version)
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+	if hook.Secret != "" {
+		req.Header.Set(signatureHeader, "sha256="+sign(hook.Secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// the url error repeats the url
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the body
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,180 @@
+package goback
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndresBott/goback/app/logger"
+	"github.com/AndresBott/goback/internal/profile"
+	"github.com/google/go-cmp/cmp"
+)
+
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	// statuses are returned in order, 200 once they are used up
+	statuses []int
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	ws := &webhookServer{statuses: statuses}
+	ws.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		ws.requests = append(ws.requests, r)
+		ws.bodies = append(ws.bodies, body)
+		status := http.StatusOK
+		if len(ws.statuses) > 0 {
+			status = ws.statuses[0]
+			ws.statuses = ws.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ws.Close)
+	return ws
+}
+
+func TestSendWebhook(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	res := RunResult{
+		Profile:  "bla",
+		Type:     profile.TypeLocal,
+		Start:    time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC),
+		Duration: 90 * time.Second,
+		Archive:  "/backups/bla_2024_10_03-02:30:00_backup.zip",
+		Size:     1234,
+	}
+
+	t.Run("post signed json document", func(t *testing.T) {
+		ws := newWebhookServer(t)
+		hook := profile.Webhook{
+			Url:     ws.URL + "/hook",
+			Headers: map[string]string{"Authorization": "Bearer token"},
+			Secret:  "s3cret",
+		}
+		err := sendWebhook(hook, res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(ws.requests) != 1 {
+			t.Fatalf("expected 1 request, got %d", len(ws.requests))
+		}
+
+		req, body := ws.requests[0], ws.bodies[0]
+		if req.Method != http.MethodPost || req.URL.Path != "/hook" {
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+		}
+		if got := req.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("unexpected content type %s", got)
+		}
+		if got := req.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("unexpected authorization header %s", got)
+		}
+		if got, want := req.Header.Get(signatureHeader), "sha256="+sign("s3cret", body); got != want {
+			t.Errorf("got signature %s, want %s", got, want)
+		}
+
+		got := webhookPayload{}
+		err = json.Unmarshal(body, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := newWebhookPayload(res)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("payload mismatch (-want +got):\n%s", diff)
+		}
+		if got.Status != "success" || got.Duration != "1m30s" || got.ArchiveSize != 1234 {
+			t.Errorf("unexpected payload values: %+v", got)
+		}
+	})
+
+	t.Run("failed run", func(t *testing.T) {
+		failed := res
+		failed.Err = errors.New("disk full")
+		p := newWebhookPayload(failed)
+		if p.Status != "failure" || p.Error != "disk full" || !strings.Contains(p.Text, "disk full") {
+			t.Errorf("unexpected payload values: %+v", p)
+		}
+	})
+
+	t.Run("retry server errors", func(t *testing.T) {
+		ws := newWebhookServer(t, http.StatusBadGateway, http.StatusTooManyRequests)
+		err := sendWebhook(profile.Webhook{Url: ws.URL}, res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(ws.requests) != 3 {
+			t.Errorf("expected 3 requests, got %d", len(ws.requests))
+		}
+	})
+
+	t.Run("give up after the retries", func(t *testing.T) {
+		ws := newWebhookServer(t, 500, 500, 500)
+		err := sendWebhook(profile.Webhook{Url: ws.URL + "/secret-token", Retries: 2}, res)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if strings.Contains(err.Error(), "secret-token") {
+			t.Errorf("the error should not contain the url: %v", err)
+		}
+		if len(ws.requests) != 3 {
+			t.Errorf("expected 3 requests, got %d", len(ws.requests))
+		}
+	})
+
+	t.Run("don't retry client errors", func(t *testing.T) {
+		ws := newWebhookServer(t, http.StatusNotFound)
+		err := sendWebhook(profile.Webhook{Url: ws.URL}, res)
+		if err == nil || !strings.Contains(err.Error(), "404") {
+			t.Errorf("expected a 404 error, got: %v", err)
+		}
+		if len(ws.requests) != 1 {
+			t.Errorf("expected 1 request, got %d", len(ws.requests))
+		}
+	})
+}
+
+func TestRunWithNotifyWebhooks(t *testing.T) {
+	tcs := []struct {
+		name      string
+		runErr    error
+		onSuccess bool
+		wantCalls int
+	}{
+		{name: "failure is always sent", runErr: errors.New("failed"), wantCalls: 1},
+		{name: "success is not sent by default", wantCalls: 0},
+		{name: "success is sent with onSuccess", onSuccess: true, wantCalls: 1},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ws := newWebhookServer(t)
+			prfl := profile.Profile{
+				Name: "bla",
+				Notify: profile.Notify{
+					Webhooks: []profile.Webhook{{Url: ws.URL, OnSuccess: tc.onSuccess}},
+				},
+			}
+			res := RunResult{Profile: prfl.Name, Start: time.Now()}
+			err := RunWithNotify(prfl, &res, logger.SilentLogger(), func(profile.Profile, *RunResult, *slog.Logger) error {
+				return tc.runErr
+			})
+			if !errors.Is(err, tc.runErr) {
+				t.Errorf("expected the run error, got: %v", err)
+			}
+			if len(ws.requests) != tc.wantCalls {
+				t.Errorf("expected %d requests, got %d", tc.wantCalls, len(ws.requests))
+			}
+		})
+	}
+}
@@ -26,13 +26,19 @@ func GetLogLevel(in string) slog.Level {
 }
 
 func GetDefault(level slog.Level) (*slog.Logger, error) {
+	return GetWithOutput(level, os.Stdout)
+}
+
+// GetWithOutput returns the default logger writing to out instead of stdout,
+// e.g. stderr when stdout is used to stream data
+func GetWithOutput(level slog.Level, out *os.File) (*slog.Logger, error) {
 
-	useTty := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+	useTty := isatty.IsTerminal(out.Fd()) || isatty.IsCygwinTerminal(out.Fd())
 	//useTty = false
 
 	var defaultHandler slog.Handler
 	if useTty {
-		consoleHan := console.NewHandler(os.Stdout, &console.HandlerOptions{
+		consoleHan := console.NewHandler(out, &console.HandlerOptions{
 			Level: level,
 			//AddSource:  true,
 			TimeFormat: time.Kitchen,
@@ -50,7 +56,7 @@ func GetDefault(level slog.Level) (*slog.Logger, error) {
 
 		defaultHandler = slogformatter.NewFormatterHandler(fmts...)(consoleHan)
 	} else {
-		jsonHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		jsonHandler := slog.NewJSONHandler(out, &slog.HandlerOptions{
 			Level: level,
 		})
 
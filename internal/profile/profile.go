@@ -4,11 +4,16 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	htmlTemplate "html/template"
+	"net/url"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
+	textTemplate "text/template"
+	"time"
 
+	"github.com/AndresBott/goback/lib/cron"
 	"github.com/gobwas/glob"
 	"gopkg.in/yaml.v2"
 )
@@ -39,7 +44,11 @@ func LoadProfile(file string) (Profile, error) {
 	if err != nil {
 		return Profile{}, err
 	}
+	return LoadProfileData(data)
+}
 
+// LoadProfileData loads a profile from yaml data, e.g. read from stdin
+func LoadProfileData(data []byte) (Profile, error) {
 	version, err := getVersion(data)
 	if err != nil {
 		return Profile{}, fmt.Errorf("unable to get profile version: %w", err)
@@ -54,8 +63,10 @@ func LoadProfile(file string) (Profile, error) {
 }
 
 type profileV1 struct {
-	Name string
-	Type ProfileType
+	Name       string
+	Type       ProfileType
+	Schedule   string
+	StaleAfter time.Duration `yaml:"staleAfter"`
 
 	Ssh  Ssh
 	Dirs []struct {
@@ -66,7 +77,10 @@ type profileV1 struct {
 	Dbs []BackupDb
 
 	Destination Destination
-	Notify      EmailNotify
+	Compression Compression
+	Sync        Sync
+	Agent       Agent
+	Notify      Notify
 }
 
 // load Profile V1 and return a valid profile
@@ -90,6 +104,30 @@ func loadProfileV1(data []byte) (Profile, error) {
 		return Profile{}, err
 	}
 
+	if err := validateSync(returnProfile); err != nil {
+		return Profile{}, err
+	}
+
+	if err := validateDestination(returnProfile.Destination); err != nil {
+		return Profile{}, err
+	}
+
+	if err := validateCompression(&returnProfile.Compression); err != nil {
+		return Profile{}, err
+	}
+
+	if err := validateEmail(&returnProfile.Notify.EmailNotify); err != nil {
+		return Profile{}, err
+	}
+
+	if err := validateWebhooks(returnProfile.Notify.Webhooks); err != nil {
+		return Profile{}, err
+	}
+
+	if err := validateHeartbeat(returnProfile.Notify.Heartbeat); err != nil {
+		return Profile{}, err
+	}
+
 	dirs, err := processDirectories(loadedProfile.Dirs, returnProfile.Type)
 	if err != nil {
 		return Profile{}, err
@@ -114,12 +152,17 @@ func createProfile(loadedProfile profileV1) (Profile, error) {
 	returnProfile := Profile{
 		Name:        loadedProfile.Name,
 		Type:        ProfileType(strings.ToLower(string(loadedProfile.Type))),
+		Schedule:    strings.TrimSpace(loadedProfile.Schedule),
+		StaleAfter:  loadedProfile.StaleAfter,
 		Ssh:         loadedProfile.Ssh,
 		Destination: loadedProfile.Destination,
+		Compression: loadedProfile.Compression,
+		Sync:        loadedProfile.Sync,
+		Agent:       loadedProfile.Agent,
 		Notify:      loadedProfile.Notify,
 	}
 
-	if !slices.Contains([]ProfileType{TypeSftpSync, TypeLocal, TypeRemote}, returnProfile.Type) {
+	if !slices.Contains([]ProfileType{TypeSftpSync, TypeSftpPush, TypeLocal, TypeRemote}, returnProfile.Type) {
 		return Profile{}, errors.New("profile has invalid type")
 	}
 
@@ -130,23 +173,51 @@ func createProfile(loadedProfile profileV1) (Profile, error) {
 		return Profile{}, errors.New("profile name cannot be empty")
 	}
 
+	if returnProfile.StaleAfter < 0 {
+		return Profile{}, errors.New("profile staleAfter cannot be negative")
+	}
+
+	if returnProfile.Schedule != "" {
+		if _, err := cron.Parse(returnProfile.Schedule); err != nil {
+			return Profile{}, fmt.Errorf("profile schedule: %v", err)
+		}
+	}
+
 	return returnProfile, nil
 }
 
 // validateSshConfig validates SSH configuration for profiles that require it
 func validateSshConfig(profile *Profile) error {
 	// requires ssh config
-	if slices.Contains([]ProfileType{TypeSftpSync, TypeRemote}, profile.Type) {
-		if !slices.Contains([]ConnType{ConnTypeSshKey, ConnTypePasswd, ConnTypeSshAgent}, profile.Ssh.Type) || profile.Ssh.Type == "" {
+	if slices.Contains([]ProfileType{TypeSftpSync, TypeSftpPush, TypeRemote}, profile.Type) {
+		// when using a host alias of the ssh config, the missing values are resolved when connecting
+		fromConfig := profile.Ssh.ConfigHost != ""
+
+		if !slices.Contains([]ConnType{ConnTypeSshKey, ConnTypePasswd, ConnTypeSshAgent}, profile.Ssh.Type) &&
+			(!fromConfig || profile.Ssh.Type != "") {
 			return errors.New("profile has invalid ssh connection type")
 		}
-		if profile.Ssh.Host == "" {
+		if profile.Ssh.Host == "" && !fromConfig {
 			return errors.New("profile ssh host cannot be empty")
 		}
 
-		if profile.Ssh.Port == 0 {
+		if profile.Ssh.Port == 0 && !fromConfig {
 			profile.Ssh.Port = 22
 		}
+
+		for i := range profile.Ssh.Jump {
+			jump := &profile.Ssh.Jump[i]
+			jump.Type = ConnType(strings.ToLower(string(jump.Type)))
+			if !slices.Contains([]ConnType{ConnTypeSshKey, ConnTypePasswd, ConnTypeSshAgent}, jump.Type) {
+				return errors.New("profile has invalid ssh jump connection type")
+			}
+			if jump.Host == "" {
+				return errors.New("profile ssh jump host cannot be empty")
+			}
+			if jump.Port == 0 {
+				jump.Port = 22
+			}
+		}
 	}
 	return nil
 }
@@ -161,6 +232,100 @@ func validateBackupTargets(loadedProfile profileV1, profileType ProfileType) err
 	return nil
 }
 
+// validateSync ensures the sync options don't contradict each other
+func validateSync(profile Profile) error {
+	if profile.Type == TypeSftpSync && profile.Sync.Mirror && profile.Sync.RemoveRemote {
+		return errors.New("sync mirror and removeRemote cannot be used together")
+	}
+	return nil
+}
+
+// minSplitSize is the smallest allowed volume size of split backups
+const minSplitSize = 1 << 20
+
+// validateDestination checks the destination options
+func validateDestination(d Destination) error {
+	if d.SplitSize != 0 && d.SplitSize < minSplitSize {
+		return errors.New("destination splitSize must be at least 1MiB")
+	}
+	return nil
+}
+
+// validateCompression checks the compression level and normalizes the extensions to lower case with a leading dot
+func validateCompression(c *Compression) error {
+	if c.Level != nil && (*c.Level < 0 || *c.Level > 9) {
+		return errors.New("compression level must be between 0 and 9")
+	}
+	for i, ext := range c.StoreExtensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			return errors.New("compression store extension cannot be empty")
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		c.StoreExtensions[i] = ext
+	}
+	return nil
+}
+
+// validateEmail checks the connection security and that the email templates can be parsed
+func validateEmail(m *EmailNotify) error {
+	m.Security = strings.ToLower(m.Security)
+	if !slices.Contains([]string{"", SecurityStartTls, SecurityTls, SecurityNone}, m.Security) {
+		return fmt.Errorf("invalid notify security \"%s\"", m.Security)
+	}
+	if _, err := textTemplate.New("subject").Parse(m.Subject); err != nil {
+		return fmt.Errorf("invalid notify subject template: %v", err)
+	}
+	if _, err := textTemplate.New("body").Parse(m.Body); err != nil {
+		return fmt.Errorf("invalid notify body template: %v", err)
+	}
+	if _, err := htmlTemplate.New("htmlBody").Parse(m.HtmlBody); err != nil {
+		return fmt.Errorf("invalid notify htmlBody template: %v", err)
+	}
+	if m.HtmlBody != "" {
+		m.Html = true
+	}
+	return nil
+}
+
+// validateWebhooks checks that every webhook has a valid http(s) url, the url itself is not part of
+// the error since webhook urls often contain a token
+func validateWebhooks(hooks []Webhook) error {
+	for i, h := range hooks {
+		if !isHttpUrl(h.Url) {
+			return fmt.Errorf("webhook %d: url must be an http or https url", i+1)
+		}
+		if h.Retries < -1 {
+			return fmt.Errorf("webhook %d: retries must be -1 or greater", i+1)
+		}
+	}
+	return nil
+}
+
+// validateHeartbeat checks that the heartbeat urls are http(s) urls, like webhook urls they are not part
+// of the error
+func validateHeartbeat(h Heartbeat) error {
+	for _, u := range []struct {
+		name string
+		url  string
+	}{{"start", h.Start}, {"success", h.Success}, {"fail", h.Fail}} {
+		if u.url == "" {
+			continue
+		}
+		if !isHttpUrl(u.url) {
+			return fmt.Errorf("heartbeat %s: url must be an http or https url", u.name)
+		}
+	}
+	return nil
+}
+
+func isHttpUrl(in string) bool {
+	u, err := url.Parse(in)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // processDirectories processes and validates directory configurations
 func processDirectories(dirs []struct {
 	Path    string
@@ -181,14 +346,15 @@ func processDirectories(dirs []struct {
 				return nil, fmt.Errorf("unable to compile exclude pattern: %w", gerr)
 			}
 			d.Exclude = append(d.Exclude, g)
+			d.ExcludePatterns = append(d.ExcludePatterns, excl)
 		}
 
 		if d.Path == "" {
 			return nil, errors.New("profile path cannot be empty")
 		}
 
-		// if type is sftpsync we also require a profile name
-		if profileType == TypeSftpSync && d.Name == "" {
+		// if type is sftpsync or sftppush we also require a profile name
+		if slices.Contains([]ProfileType{TypeSftpSync, TypeSftpPush}, profileType) && d.Name == "" {
 			return nil, errors.New("profile name for sync path cannot be empty")
 		}
 
@@ -223,7 +389,70 @@ func processDatabases(dbs []BackupDb) ([]BackupDb, error) {
 	return backupDbs, nil
 }
 
-const profileExt = ".backup.yaml"
+// AgentProfile serialises the dirs and dbs of a remote profile into a local profile,
+// it is sent to goback running on the remote host which creates the archive there.
+func AgentProfile(prfl Profile) ([]byte, error) {
+	type agentDir struct {
+		Path    string
+		Exclude []string `yaml:",omitempty"`
+	}
+	type agentProfile struct {
+		Version     int
+		Name        string
+		Type        ProfileType
+		Dirs        []agentDir  `yaml:",omitempty"`
+		Dbs         []BackupDb  `yaml:",omitempty"`
+		Compression Compression `yaml:",omitempty"`
+	}
+
+	out := agentProfile{
+		Version:     1,
+		Name:        prfl.Name,
+		Type:        TypeLocal,
+		Dbs:         prfl.Dbs,
+		Compression: prfl.Compression,
+	}
+	for _, dir := range prfl.Dirs {
+		out.Dirs = append(out.Dirs, agentDir{Path: dir.Path, Exclude: dir.ExcludePatterns})
+	}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialise agent profile: %v", err)
+	}
+	return data, nil
+}
+
+// LoadNotify loads notification settings from a file, the file has the same fields as the notify section of
+// a profile; it is used for notifications that are not bound to a single profile
+func LoadNotify(file string) (Notify, error) {
+	// #nosec G304 -- the file is defined by the user
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Notify{}, fmt.Errorf("unable to read notify config: %v", err)
+	}
+	n := Notify{}
+	err = yaml.Unmarshal(data, &n)
+	if err != nil {
+		return Notify{}, fmt.Errorf("unable to parse notify config: %v", err)
+	}
+	if !n.HasValues() && len(n.Webhooks) == 0 && !n.Heartbeat.HasValues() {
+		return Notify{}, errors.New("notify config has neither email settings, webhooks nor heartbeat")
+	}
+	if err := validateEmail(&n.EmailNotify); err != nil {
+		return Notify{}, err
+	}
+	if err := validateWebhooks(n.Webhooks); err != nil {
+		return Notify{}, err
+	}
+	if err := validateHeartbeat(n.Heartbeat); err != nil {
+		return Notify{}, err
+	}
+	return n, nil
+}
+
+// ProfileExt is the extension of profile files loaded from a directory
+const ProfileExt = ".backup.yaml"
 
 // LoadProfiles will try to load all profiles in a directory, no error is returned if all profiles are ok
 // if any profile is incomplete, the slice of profiles still contain the valid profiles
@@ -241,7 +470,7 @@ func LoadProfiles(dir string) ([]Profile, error) {
 	var files []string
 	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if !info.IsDir() {
-			if strings.HasSuffix(info.Name(), profileExt) {
+			if strings.HasSuffix(info.Name(), ProfileExt) {
 				files = append(files, path)
 			}
 		}
@@ -256,7 +485,7 @@ func LoadProfiles(dir string) ([]Profile, error) {
 	for _, file := range files {
 		p, perr := LoadProfile(file)
 		if perr != nil {
-			errs = errors.Join(errs, fmt.Errorf("failed to load profile %s: %w", file, perr))
+			errs = errors.Join(errs, &LoadError{File: file, Err: perr})
 			continue
 		}
 		profiles = append(profiles, p)
@@ -265,6 +494,20 @@ func LoadProfiles(dir string) ([]Profile, error) {
 	return profiles, errs
 }
 
+// LoadError is returned by LoadProfiles for every profile file that failed to load
+type LoadError struct {
+	File string
+	Err  error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("failed to load profile %s: %v", e.File, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
 //go:embed configv1.yaml
 var configV1Yaml string
 
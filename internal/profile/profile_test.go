@@ -3,6 +3,7 @@ package profile
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gobwas/glob"
 	"github.com/google/go-cmp/cmp"
@@ -12,6 +13,10 @@ func getGlob(in string) glob.Glob {
 	return glob.MustCompile(in)
 }
 
+func intPtr(in int) *int {
+	return &in
+}
+
 func TestLoadProfile(t *testing.T) {
 
 	tcs := []struct {
@@ -23,20 +28,24 @@ func TestLoadProfile(t *testing.T) {
 			name: "local backup",
 			file: "sampledata/correctProfileDir/local.backup.yaml",
 			want: Profile{
-				Name: "localBackup",
-				Type: TypeLocal,
+				Name:       "localBackup",
+				Type:       TypeLocal,
+				Schedule:   "30 2 * * *",
+				StaleAfter: 26 * time.Hour,
 				Dirs: []BackupPath{
 					{
 						Path: "/bla",
 						Exclude: []glob.Glob{
 							getGlob("*.log"),
 						},
+						ExcludePatterns: []string{"*.log"},
 					},
 					{
 						Path: "/ble",
 						Exclude: []glob.Glob{
 							getGlob("*.logs"),
 						},
+						ExcludePatterns: []string{"*.logs"},
 					},
 				},
 				Dbs: []BackupDb{
@@ -55,17 +64,36 @@ func TestLoadProfile(t *testing.T) {
 					},
 				},
 				Destination: Destination{
-					Path:  "/backups",
-					Keep:  3,
-					Owner: "ble",
-					Mode:  "0600",
+					Path:      "/backups",
+					Keep:      3,
+					Owner:     "ble",
+					Mode:      "0600",
+					SplitSize: 4 << 30,
 				},
-				Notify: EmailNotify{
-					Host:     "smtp.mail.com",
-					Port:     "587",
-					User:     "mail@mails.com",
-					Password: "1234",
-					To:       []string{"mail1@mail.com", "mail2@mail.com"},
+				Notify: Notify{
+					EmailNotify: EmailNotify{
+						Host:     "smtp.mail.com",
+						Port:     "587",
+						User:     "mail@mails.com",
+						Password: "1234",
+						To:       []string{"mail1@mail.com", "mail2@mail.com"},
+						Security: "starttls",
+						Subject:  "Backup of {{.Profile}}",
+					},
+					Webhooks: []Webhook{
+						{
+							Url:       "https://hooks.example.com/goback",
+							Headers:   map[string]string{"Authorization": "Bearer token"},
+							Secret:    "s3cret",
+							Retries:   5,
+							OnSuccess: true,
+						},
+					},
+					Heartbeat: Heartbeat{
+						Start:   "https://hc-ping.com/5d8f1c2e/start",
+						Success: "https://hc-ping.com/5d8f1c2e",
+						Fail:    "https://hc-ping.com/5d8f1c2e/fail",
+					},
 				},
 			},
 		},
@@ -84,6 +112,18 @@ func TestLoadProfile(t *testing.T) {
 					Password:   "bla",
 					PrivateKey: "privKey",
 					Passphrase: "pass",
+					Jump: []SshJump{
+						{
+							Type:       ConnTypeSshKey,
+							Host:       "bastion.ble.com",
+							Port:       22,
+							User:       "jumper",
+							PrivateKey: "/path/to/bastion/key",
+						},
+					},
+					KeepAlive:  15 * time.Second,
+					Retries:    5,
+					RetryDelay: 2 * time.Second,
 				},
 				Dirs: []BackupPath{
 					{
@@ -91,6 +131,7 @@ func TestLoadProfile(t *testing.T) {
 						Exclude: []glob.Glob{
 							getGlob("*.log"),
 						},
+						ExcludePatterns: []string{"*.log"},
 					},
 					{
 						Path: "/backup/service2",
@@ -110,12 +151,22 @@ func TestLoadProfile(t *testing.T) {
 					Owner: "ble",
 					Mode:  "0600",
 				},
-				Notify: EmailNotify{
-					Host:     "smtp.mail.com",
-					Port:     "587",
-					User:     "mail@mails.com",
-					Password: "1234",
-					To:       []string{"mail1@mail.com", "mail2@mail.com"},
+				Compression: Compression{
+					Level:           intPtr(6),
+					StoreExtensions: []string{".jpg", ".mp4"},
+				},
+				Agent: Agent{
+					Enabled: true,
+					Upload:  true,
+				},
+				Notify: Notify{
+					EmailNotify: EmailNotify{
+						Host:     "smtp.mail.com",
+						Port:     "587",
+						User:     "mail@mails.com",
+						Password: "1234",
+						To:       []string{"mail1@mail.com", "mail2@mail.com"},
+					},
 				},
 			},
 		},
@@ -137,18 +188,68 @@ func TestLoadProfile(t *testing.T) {
 					{Path: "/backup/service1", Name: "service1"},
 					{Path: "/backup/service2", Name: "service2"},
 				},
+				Sync: Sync{
+					Mirror: true,
+					DryRun: true,
+				},
 				Destination: Destination{
 					Path:  "/backups",
 					Keep:  3,
 					Owner: "ble",
 					Mode:  "0600",
 				},
-				Notify: EmailNotify{
-					Host:     "smtp.mail.com",
-					Port:     "587",
-					User:     "mail@mails.com",
-					Password: "1234",
-					To:       []string{"mail1@mail.com", "mail2@mail.com"},
+				Notify: Notify{
+					EmailNotify: EmailNotify{
+						Host:     "smtp.mail.com",
+						Port:     "587",
+						User:     "mail@mails.com",
+						Password: "1234",
+						To:       []string{"mail1@mail.com", "mail2@mail.com"},
+					},
+				},
+			},
+		},
+		{
+			name: "profile using an ssh config host alias",
+			file: "sampledata/sshConfigHost.yaml",
+			want: Profile{
+				Name: "configHost",
+				Type: TypeRemote,
+				Ssh: Ssh{
+					ConfigHost:      "prod-db1",
+					User:            "backup",
+					HostKey:         "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+					TrustOnFirstUse: true,
+					Sudo:            true,
+					SudoUser:        "backup-reader",
+				},
+				Dirs: []BackupPath{
+					{Path: "/etc"},
+				},
+				Destination: Destination{
+					Path: "/backups",
+				},
+			},
+		},
+		{
+			name: "profile with sftp push",
+			file: "sampledata/correctProfileDir/sftpPush.backup.yaml",
+			want: Profile{
+				Name: "sftpPush",
+				Type: TypeSftpPush,
+				Ssh: Ssh{
+					Type:     ConnTypePasswd,
+					Host:     "bla.ble.com",
+					Port:     22,
+					User:     "user",
+					Password: "bla",
+				},
+				Dirs: []BackupPath{
+					{Path: "/backups", Name: "service1"},
+				},
+				Destination: Destination{
+					Path: "/central/backups",
+					Keep: 5,
 				},
 			},
 		},
@@ -251,6 +352,46 @@ func TestLoadProfileErrors(t *testing.T) {
 			file:      "sampledata/errCases/invalid_remote.yaml",
 			wantError: "profile has invalid ssh connection type",
 		},
+		{
+			name:      "invalid jump host",
+			file:      "sampledata/errCases/invalid_jump.yaml",
+			wantError: "profile ssh jump host cannot be empty",
+		},
+		{
+			name:      "invalid compression level",
+			file:      "sampledata/errCases/invalid_compression.yaml",
+			wantError: "compression level must be between 0 and 9",
+		},
+		{
+			name:      "invalid schedule",
+			file:      "sampledata/errCases/invalid_schedule.yaml",
+			wantError: "profile schedule: invalid cron expression \"30 25 * * *\": value 25 out of range 0-23 in hour",
+		},
+		{
+			name:      "invalid webhook url",
+			file:      "sampledata/errCases/invalid_webhook.yaml",
+			wantError: "webhook 1: url must be an http or https url",
+		},
+		{
+			name:      "invalid heartbeat url",
+			file:      "sampledata/errCases/invalid_heartbeat.yaml",
+			wantError: "heartbeat fail: url must be an http or https url",
+		},
+		{
+			name:      "invalid email template",
+			file:      "sampledata/errCases/invalid_email_template.yaml",
+			wantError: "invalid notify body template: template: body:1: unclosed action",
+		},
+		{
+			name:      "invalid split size",
+			file:      "sampledata/errCases/invalid_split_size.yaml",
+			wantError: "destination splitSize must be at least 1MiB",
+		},
+		{
+			name:      "malformed split size",
+			file:      "sampledata/errCases/malformed_split_size.yaml",
+			wantError: "invalid size: 4 gigs",
+		},
 		{
 			name:      "invalid backup content",
 			file:      "sampledata/errCases/invalid_backup_content.yaml",
@@ -261,6 +402,11 @@ func TestLoadProfileErrors(t *testing.T) {
 			file:      "sampledata/errCases/missing_sync_path_name.yaml",
 			wantError: "profile name for sync path cannot be empty",
 		},
+		{
+			name:      "contradicting sync options",
+			file:      "sampledata/errCases/invalid_sync_options.yaml",
+			wantError: "sync mirror and removeRemote cannot be used together",
+		},
 		{
 			name:      "missing container name for docker database",
 			file:      "sampledata/errCases/missing_db_container_name.yaml",
@@ -287,6 +433,33 @@ func TestLoadProfileErrors(t *testing.T) {
 	}
 }
 
+func TestLoadNotify(t *testing.T) {
+	got, err := LoadNotify("sampledata/notify/digest.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Notify{
+		EmailNotify: EmailNotify{
+			Host:     "smtp.mail.com",
+			Port:     "465",
+			From:     "goback@mail.com",
+			To:       []string{"admin@mail.com"},
+			Security: SecurityTls,
+			Html:     true,
+		},
+		Webhooks:  []Webhook{{Url: "https://hooks.example.com/digest"}},
+		Heartbeat: Heartbeat{Success: "https://hc-ping.com/digest"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = LoadNotify("sampledata/notify/empty.yaml")
+	if err == nil || err.Error() != "notify config has neither email settings, webhooks nor heartbeat" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func TestLoadProfiles(t *testing.T) {
 
 	t.Run("load directory with profiles", func(t *testing.T) {
@@ -302,6 +475,7 @@ func TestLoadProfiles(t *testing.T) {
 		want := []string{
 			"localBackup",
 			"remote",
+			"sftpPush",
 			"sftpSync",
 		}
 		if diff := cmp.Diff(got, want); diff != "" {
@@ -370,3 +544,31 @@ func TestLoadProfiles(t *testing.T) {
 		}
 	})
 }
+
+func TestAgentProfile(t *testing.T) {
+	prfl, err := LoadProfile("sampledata/correctProfileDir/remote.backup.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	data, err := AgentProfile(prfl)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	got, err := LoadProfileData(data)
+	if err != nil {
+		t.Fatalf("unable to load agent profile: %v", err)
+	}
+
+	want := Profile{
+		Name:        prfl.Name,
+		Type:        TypeLocal,
+		Dirs:        prfl.Dirs,
+		Dbs:         prfl.Dbs,
+		Compression: prfl.Compression,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
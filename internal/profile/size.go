@@ -0,0 +1,82 @@
+package profile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes, in yaml it can be written as a plain number or with a unit, e.g. 4GiB or 500MB
+type ByteSize int64
+
+// sizeUnits maps the accepted units to their multiplier, checked in order so that longer suffixes match first
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// UnmarshalYAML implements the yaml.v2 Unmarshaler interface
+func (s *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	size, err := ParseByteSize(str)
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+// MarshalYAML implements the yaml.v2 Marshaler interface
+func (s ByteSize) MarshalYAML() (interface{}, error) {
+	return int64(s), nil
+}
+
+// ParseByteSize parses a size with an optional unit, units are case-insensitive
+func ParseByteSize(in string) (ByteSize, error) {
+	str := strings.TrimSpace(in)
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if len(str) > len(u.suffix) && strings.EqualFold(str[len(str)-len(u.suffix):], u.suffix) {
+			mult = u.mult
+			str = strings.TrimSpace(str[:len(str)-len(u.suffix)])
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", in)
+	}
+	if n > (1<<63-1)/mult {
+		return 0, fmt.Errorf("size too large: %s", in)
+	}
+	return ByteSize(n * mult), nil
+}
+
+// String returns the size in the largest binary unit, e.g. 1.5 GiB
+func (s ByteSize) String() string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	if s < 1<<10 {
+		return fmt.Sprintf("%d B", int64(s))
+	}
+	val := float64(s) / (1 << 10)
+	i := 0
+	for val >= 1<<10 && i < len(units)-1 {
+		val /= 1 << 10
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", val, units[i])
+}
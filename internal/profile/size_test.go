@@ -0,0 +1,59 @@
+package profile
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tcs := []struct {
+		in      string
+		want    ByteSize
+		wantErr string
+	}{
+		{in: "1048576", want: 1 << 20},
+		{in: "4GiB", want: 4 << 30},
+		{in: "500 MB", want: 500 * 1000 * 1000},
+		{in: "2kib", want: 2048},
+		{in: "10B", want: 10},
+		{in: "1TB", want: 1000 * 1000 * 1000 * 1000},
+		{in: "GiB", wantErr: "invalid size: GiB"},
+		{in: "-1MiB", wantErr: "invalid size: -1MiB"},
+		{in: "1.5GiB", wantErr: "invalid size: 1.5GiB"},
+		{in: "9000000TiB", wantErr: "size too large: 9000000TiB"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := ParseByteSize(tc.in)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("expected error %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	tcs := []struct {
+		in   ByteSize
+		want string
+	}{
+		{in: 0, want: "0 B"},
+		{in: 1023, want: "1023 B"},
+		{in: 1536, want: "1.5 KiB"},
+		{in: 4 << 30, want: "4.0 GiB"},
+		{in: 3 << 50, want: "3072.0 TiB"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.want, func(t *testing.T) {
+			if got := tc.in.String(); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
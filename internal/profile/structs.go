@@ -1,19 +1,28 @@
 package profile
 
 import (
+	"time"
+
 	"github.com/gobwas/glob"
 )
 
 type Profile struct {
 	Name string
 	Type ProfileType
+	// Schedule is a cron expression used by the daemon to run the profile
+	Schedule string
+	// StaleAfter is the age of the newest backup from which goback status warns about the profile, 0 disables the warning
+	StaleAfter time.Duration
 
 	Ssh  Ssh
 	Dirs []BackupPath
 	Dbs  []BackupDb
 
 	Destination Destination
-	Notify      EmailNotify
+	Compression Compression
+	Sync        Sync
+	Agent       Agent
+	Notify      Notify
 }
 
 type ProfileType string
@@ -22,6 +31,7 @@ const (
 	TypeRemote   ProfileType = "remote"
 	TypeLocal    ProfileType = "local"
 	TypeSftpSync ProfileType = "sftpsync"
+	TypeSftpPush ProfileType = "sftppush"
 )
 
 // Ssh holds the details to connect over ssh to the remote
@@ -33,6 +43,40 @@ type Ssh struct {
 	Password   string
 	PrivateKey string `yaml:"privateKey"`
 	Passphrase string
+	// Jump is an ordered list of bastion hosts used to reach Host
+	Jump []SshJump
+	// ConfigHost is a host alias in ~/.ssh/config used to fill the fields not set explicitly
+	ConfigHost string `yaml:"configHost"`
+	// HostKey pins the SHA256 fingerprint of the host key
+	HostKey string `yaml:"hostKey"`
+	// KnownHosts overrides the location of the known_hosts file managed by goback
+	KnownHosts string `yaml:"knownHosts"`
+	// TrustOnFirstUse records the key of unknown hosts instead of failing
+	TrustOnFirstUse bool `yaml:"trustOnFirstUse"`
+	// Sudo reads remote files and runs remote dumps with sudo, optionally as SudoUser instead of root
+	Sudo         bool
+	SudoUser     string `yaml:"sudoUser"`
+	SudoPassword string `yaml:"sudoPassword"`
+	// SftpServer is the path of the sftp-server binary started with sudo
+	SftpServer string `yaml:"sftpServer"`
+	// KeepAlive is the interval between keepalive requests, a negative value disables them
+	KeepAlive time.Duration `yaml:"keepAlive"`
+	// Retries is the number of times a failed connection or file copy is retried, a negative value disables retries
+	Retries int
+	// RetryDelay is the delay before the first retry, it doubles on every following retry
+	RetryDelay time.Duration `yaml:"retryDelay"`
+}
+
+// SshJump holds the details to connect to a bastion host
+type SshJump struct {
+	Type       ConnType
+	Host       string
+	Port       int
+	User       string
+	Password   string
+	PrivateKey string `yaml:"privateKey"`
+	Passphrase string
+	HostKey    string `yaml:"hostKey"`
 }
 
 type ConnType string
@@ -46,8 +90,10 @@ const (
 // BackupPath Holds the details about a path to include in the backup
 type BackupPath struct {
 	Path    string
-	Name    string // used only in sftp sync
+	Name    string // used only in sftp sync and push
 	Exclude []glob.Glob
+	// ExcludePatterns are the source patterns of Exclude, used to serialise the profile
+	ExcludePatterns []string
 }
 
 type BackupDb struct {
@@ -73,6 +119,70 @@ type Destination struct {
 	Keep  int
 	Owner string
 	Mode  string
+	// SplitSize splits the backup file into volumes of at most this size, 0 disables splitting
+	SplitSize ByteSize `yaml:"splitSize"`
+}
+
+// Compression holds the settings of the archive compression
+type Compression struct {
+	// Level of the compression from 0 (no compression) to 9 (best), the default level is used if not set
+	Level *int
+	// StoreExtensions are file extensions stored without compression, if not set a built-in list of
+	// already compressed formats is used
+	StoreExtensions []string `yaml:"storeExtensions"`
+}
+
+// Sync holds the options only used by sftpsync profiles
+type Sync struct {
+	// Mirror deletes local backups that no longer exist in the remote location
+	Mirror bool
+	// RemoveRemote deletes the remote backups that have a local copy verified by its sha256 sum
+	RemoveRemote bool `yaml:"removeRemote"`
+	// DryRun only lists the actions without downloading or deleting any file
+	DryRun bool `yaml:"dryRun"`
+}
+
+// Agent holds the options only used by remote profiles to run goback on the remote host
+type Agent struct {
+	// Enabled runs "goback backup --stdout" on the remote host and falls back to sftp if that is not possible
+	Enabled bool
+	// Path of the goback binary on the remote host, looked up in the PATH if empty
+	Path string
+	// Upload copies the local goback binary to a temp dir on the remote host for the duration of the run
+	Upload bool
+}
+
+// Notify holds the notification settings of a profile, the email settings are set directly on notify
+type Notify struct {
+	EmailNotify `yaml:",inline"`
+	// Webhooks receive a JSON document with the result of every run
+	Webhooks []Webhook
+	// Heartbeat is pinged on every run, so that a monitor can alert when backups stop running
+	Heartbeat Heartbeat
+}
+
+// Heartbeat holds the urls of a dead man's switch monitor, e.g. healthchecks.io, every url is optional
+type Heartbeat struct {
+	// Start is pinged when a run starts, Success and Fail when it ends
+	Start   string
+	Success string
+	Fail    string
+}
+
+// HasValues check if any heartbeat url is set
+func (h Heartbeat) HasValues() bool {
+	return h.Start != "" || h.Success != "" || h.Fail != ""
+}
+
+// Webhook posts the result of a run as JSON document to an url
+type Webhook struct {
+	Url     string
+	Headers map[string]string
+	// Secret is used to sign the body with HMAC-SHA256, the signature is sent in the X-Goback-Signature header
+	Secret string
+	// Retries is the number of times a failed request is retried, 3 if not set, -1 disables retries
+	Retries   int
+	OnSuccess bool `yaml:"onSuccess"`
 }
 
 type EmailNotify struct {
@@ -83,15 +193,32 @@ type EmailNotify struct {
 	From      string
 	To        []string
 	OnSuccess bool `yaml:"onSuccess"`
+	// Security is the encryption of the connection: "starttls", "tls" for implicit TLS or "none"; if not set
+	// implicit TLS is used on port 465, otherwise STARTTLS if the server supports it
+	Security string
+	// CaFile is a PEM file with the CA certificates the server is verified against, instead of the system ones
+	CaFile string `yaml:"caFile"`
+	// Subject and Body are text/template templates replacing the default ones
+	Subject string
+	Body    string
+	// Html sends a multipart email with an additional html part, HtmlBody is a html/template template
+	// replacing the default html part
+	Html     bool
+	HtmlBody string `yaml:"htmlBody"`
 }
 
-// HasValues check if all the notification fields are of type default zero
+const (
+	SecurityStartTls = "starttls"
+	SecurityTls      = "tls"
+	SecurityNone     = "none"
+)
+
+// HasValues check if the fields needed to send an email are set, user and password are optional
+// to allow relays without authentication
 func (m EmailNotify) HasValues() bool {
 	if m.Host == "" ||
 		m.Port == "" ||
-		m.User == "" ||
-		m.From == "" ||
-		m.Password == "" {
+		m.From == "" {
 		return false
 	}
 	if len(m.To) == 0 {
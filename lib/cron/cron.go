@@ -0,0 +1,265 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression with the five standard fields:
+// minute, hour, day of month, month and day of week
+type Schedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// domAny and dowAny are set when the field starts with "*", e.g. "*" or "*/2", if both day fields are
+	// restricted a day matches if any of them matches, like in the classic cron
+	domAny bool
+	dowAny bool
+}
+
+type field struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as sunday as well
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression like "30 2 * * *", the fields accept lists, ranges, steps and
+// month and day names, as well as the macros @yearly, @monthly, @weekly, @daily and @hourly
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if m, ok := macros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression \"%s\": expected 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &Schedule{
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	for i, dst := range []struct {
+		f    field
+		bits *uint64
+	}{
+		{minuteField, &s.minute},
+		{hourField, &s.hour},
+		{domField, &s.dom},
+		{monthField, &s.month},
+		{dowField, &s.dow},
+	} {
+		*dst.bits, err = parseField(fields[i], dst.f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression \"%s\": %v", expr, err)
+		}
+	}
+
+	// sunday as 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps into a bit set
+func parseField(in string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(in, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step \"%s\" in %s", stepStr, f.name)
+			}
+		}
+
+		var start, end int
+		switch {
+		case rng == "*":
+			start, end = f.min, f.max
+		case strings.Contains(rng, "-"):
+			lo, hi, _ := strings.Cut(rng, "-")
+			var err error
+			if start, err = f.value(lo); err != nil {
+				return 0, err
+			}
+			if end, err = f.value(hi); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range \"%s\" in %s", rng, f.name)
+			}
+		default:
+			var err error
+			if start, err = f.value(rng); err != nil {
+				return 0, err
+			}
+			end = start
+			// "5/10" means from 5 to the end every 10
+			if hasStep {
+				end = f.max
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v) // #nosec G115 -- values are checked to be within the field range
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single number or name of the field
+func (f field) value(in string) (int, error) {
+	if v, ok := f.names[strings.ToLower(in)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(in)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value \"%s\" in %s", in, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d in %s", v, f.min, f.max, f.name)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the schedule, in the location of t.
+// A zero time is returned if no time within the next years matches, e.g. for the 30th of February.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	return t
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// OnCalendar returns the schedule as systemd calendar expressions, as used in the OnCalendar= setting of
+// timer units. Two expressions are returned when both day fields are restricted, since systemd requires
+// both to match while cron runs on any of them.
+func (s *Schedule) OnCalendar() []string {
+	clock := fmt.Sprintf("%s:%s:00", bitList(s.hour, hourField, 2), bitList(s.minute, minuteField, 2))
+	date := func(dom uint64) string {
+		return fmt.Sprintf("*-%s-%s %s", bitList(s.month, monthField, 2), bitList(dom, domField, 2), clock)
+	}
+	dow := weekdays(s.dow)
+
+	switch {
+	case s.dowAny:
+		return []string{date(s.dom)}
+	case s.domAny:
+		return []string{dow + " " + date(s.dom)}
+	default:
+		return []string{date(s.dom), dow + " " + date(allBits(domField))}
+	}
+}
+
+// bitList returns the values of a bit set as a comma separated list, or "*" if all values are set
+func bitList(bits uint64, f field, width int) string {
+	if bits&allBits(f) == allBits(f) {
+		return "*"
+	}
+	var values []string
+	for v := f.min; v <= f.max; v++ {
+		if bits&(1<<uint(v)) != 0 { // #nosec G115 -- values are within the field range
+			values = append(values, fmt.Sprintf("%0*d", width, v))
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+func allBits(f field) uint64 {
+	var bits uint64
+	for v := f.min; v <= f.max; v++ {
+		bits |= 1 << uint(v) // #nosec G115 -- values are within the field range
+	}
+	return bits
+}
+
+var weekdayNames = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// weekdays returns the days of week of a bit set as a list of systemd day names
+func weekdays(bits uint64) string {
+	var days []string
+	// systemd lists start on monday
+	for _, d := range []int{1, 2, 3, 4, 5, 6, 0} {
+		if bits&(1<<uint(d)) != 0 { // #nosec G115 -- values are within the field range
+			days = append(days, weekdayNames[d])
+		}
+	}
+	return strings.Join(days, ",")
+}
@@ -0,0 +1,111 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseErrors(t *testing.T) {
+	tcs := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "* * * *", wantErr: "invalid cron expression \"* * * *\": expected 5 fields, got 4"},
+		{expr: "60 * * * *", wantErr: "invalid cron expression \"60 * * * *\": value 60 out of range 0-59 in minute"},
+		{expr: "* 5-2 * * *", wantErr: "invalid cron expression \"* 5-2 * * *\": invalid range \"5-2\" in hour"},
+		{expr: "*/0 * * * *", wantErr: "invalid cron expression \"*/0 * * * *\": invalid step \"0\" in minute"},
+		{expr: "* * * foo *", wantErr: "invalid cron expression \"* * * foo *\": invalid value \"foo\" in month"},
+		{expr: "@sometimes", wantErr: "invalid cron expression \"@sometimes\": expected 5 fields, got 1"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.expr, func(t *testing.T) {
+			_, err := Parse(tc.expr)
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("expected error %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	mustTime := func(in string) time.Time {
+		tt, err := time.ParseInLocation("2006-01-02 15:04", in, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tt
+	}
+
+	tcs := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{name: "daily later today", expr: "30 2 * * *", from: "2024-03-10 01:00", want: "2024-03-10 02:30"},
+		{name: "daily tomorrow", expr: "30 2 * * *", from: "2024-03-10 02:30", want: "2024-03-11 02:30"},
+		{name: "every 15 minutes", expr: "*/15 * * * *", from: "2024-03-10 01:16", want: "2024-03-10 01:30"},
+		{name: "hour range and list", expr: "0 8-10,20 * * *", from: "2024-03-10 10:00", want: "2024-03-10 20:00"},
+		{name: "weekly on sunday as 7", expr: "0 3 * * 7", from: "2024-03-11 00:00", want: "2024-03-17 03:00"},
+		{name: "day names", expr: "0 3 * * mon-fri", from: "2024-03-09 00:00", want: "2024-03-11 03:00"},
+		{name: "monthly wraps the year", expr: "0 0 1 * *", from: "2024-12-15 00:00", want: "2025-01-01 00:00"},
+		{name: "month names", expr: "0 0 1 jun *", from: "2024-07-01 00:00", want: "2025-06-01 00:00"},
+		{name: "leap day", expr: "0 0 29 2 *", from: "2024-03-01 00:00", want: "2028-02-29 00:00"},
+		{name: "day of month or day of week", expr: "0 0 15 * fri", from: "2024-03-09 00:00", want: "2024-03-15 00:00"},
+		{name: "day of month or day of week, weekday first", expr: "0 0 20 * mon", from: "2024-03-09 00:00", want: "2024-03-11 00:00"},
+		{name: "macro", expr: "@weekly", from: "2024-03-11 00:00", want: "2024-03-17 00:00"},
+		{name: "step from value", expr: "5/20 * * * *", from: "2024-03-10 01:26", want: "2024-03-10 01:45"},
+		{name: "day of month step and day of week", expr: "0 3 */2 * 1", from: "2024-03-11 03:00", want: "2024-03-25 03:00"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := s.Next(mustTime(tc.from))
+			if want := mustTime(tc.want); !got.Equal(want) {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+
+	t.Run("never matching schedule", func(t *testing.T) {
+		s, err := Parse("0 0 30 2 *")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := s.Next(mustTime("2024-01-01 00:00")); !got.IsZero() {
+			t.Errorf("expected zero time, got %s", got)
+		}
+	})
+}
+
+func TestOnCalendar(t *testing.T) {
+	tcs := []struct {
+		expr string
+		want []string
+	}{
+		{expr: "30 2 * * *", want: []string{"*-*-* 02:30:00"}},
+		{expr: "*/15 * * * *", want: []string{"*-*-* *:00,15,30,45:00"}},
+		{expr: "0 8-10,20 1 jan,jul *", want: []string{"*-01,07-01 08,09,10,20:00:00"}},
+		{expr: "0 3 * * 7", want: []string{"Sun *-*-* 03:00:00"}},
+		{expr: "0 3 * * sun-tue", want: []string{"Mon,Tue,Sun *-*-* 03:00:00"}},
+		{expr: "0 0 15 * fri", want: []string{"*-*-15 00:00:00", "Fri *-*-* 00:00:00"}},
+		{expr: "0 3 */2 * 1", want: []string{"Mon *-*-01,03,05,07,09,11,13,15,17,19,21,23,25,27,29,31 03:00:00"}},
+		{expr: "@monthly", want: []string{"*-*-01 00:00:00"}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.expr, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, s.OnCalendar()); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned when the lock is held by another running process
+var ErrLocked = errors.New("locked by another process")
+
+// LockedError holds the details of a lock held by another running process
+type LockedError struct {
+	Path string
+	Pid  int
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by process %d", e.Path, e.Pid)
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Lock is a lock file owned by the current process
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// errWouldBlock is returned by tryLock if the file is locked by another process or file handle
+var errWouldBlock = errors.New("file is locked")
+
+// Acquire takes an exclusive flock on the file at path, creating it if needed, and writes the pid of the
+// current process into it. If the lock is held, also by another goroutine of this process, a LockedError is
+// returned. The kernel releases the lock when the process ends, so a lock file left behind is never stale.
+func Acquire(path string) (*Lock, error) {
+	// one attempt to take the lock and one more if the file was released and deleted in the meantime
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := openFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create lock file: %v", err)
+		}
+
+		err = tryLock(file)
+		if err != nil {
+			pid, _ := readPid(file)
+			_ = file.Close()
+			if errors.Is(err, errWouldBlock) {
+				return nil, &LockedError{Path: path, Pid: pid}
+			}
+			return nil, fmt.Errorf("unable to lock file %s: %v", path, err)
+		}
+
+		// the previous owner deletes the file on release, the lock is only valid on the file still at path
+		if !samePath(file, path) {
+			_ = file.Close()
+			continue
+		}
+
+		err = writePid(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("unable to write lock file: %v", err)
+		}
+		return &Lock{path: path, file: file}, nil
+	}
+	return nil, fmt.Errorf("unable to acquire lock file %s", path)
+}
+
+// Release deletes the lock file, if it was not replaced in the meantime, and releases the lock
+func (l *Lock) Release() error {
+	var err error
+	// deleted while still locked, so that nobody takes the lock on a file that is about to disappear
+	if samePath(l.file, l.path) {
+		err = os.Remove(l.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("unable to delete lock file: %v", err)
+		} else {
+			err = nil
+		}
+	}
+	return errors.Join(err, l.file.Close())
+}
+
+// Path returns the location of the lock file
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// samePath checks if the open file is still the one at path
+func samePath(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
+
+// writePid replaces the content of the lock file with the pid of the current process
+func writePid(file *os.File) error {
+	err := file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// readPid returns the pid stored in a lock file, it is only informative, the flock decides who holds the lock
+func readPid(file *os.File) (int, error) {
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, 32))
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid in lock file %s", file.Name())
+	}
+	return pid, nil
+}
@@ -0,0 +1,19 @@
+//go:build aix || !(unix || windows)
+
+package lock
+
+import (
+	"errors"
+	"os"
+)
+
+// openFile opens the lock file at path, creating it if needed
+func openFile(path string) (*os.File, error) {
+	// #nosec G304 -- path of the lock file is controlled by the caller
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+}
+
+// tryLock is not supported without flock
+func tryLock(file *os.File) error {
+	return errors.New("file locks are not supported on this platform")
+}
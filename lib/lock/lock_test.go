@@ -0,0 +1,143 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+
+	t.Run("acquire and release", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.lock")
+		l, err := Acquire(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strconv.Itoa(os.Getpid()) + "\n"; string(data) != want {
+			t.Errorf("got lock content %q, want %q", data, want)
+		}
+
+		if err = l.Release(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected lock file to be deleted, got: %v", err)
+		}
+	})
+
+	t.Run("lock held by a running process", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.lock")
+		l, err := Acquire(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer func() {
+			_ = l.Release()
+		}()
+
+		_, err = Acquire(path)
+		if !errors.Is(err, ErrLocked) {
+			t.Fatalf("expected ErrLocked, got: %v", err)
+		}
+		lockErr := &LockedError{}
+		if !errors.As(err, &lockErr) || lockErr.Pid != os.Getpid() {
+			t.Errorf("expected the lock to be held by pid %d, got: %v", os.Getpid(), err)
+		}
+	})
+
+	t.Run("stale lock is replaced", func(t *testing.T) {
+		// the pid of a process that already finished
+		cmd := exec.Command("true")
+		if err := cmd.Run(); err != nil {
+			t.Skipf("unable to run true: %v", err)
+		}
+		stalePid := cmd.Process.Pid
+
+		for name, content := range map[string]string{
+			"dead process":    strconv.Itoa(stalePid),
+			"invalid content": "not a pid",
+			"empty file":      "",
+			// e.g. a daemon running as pid 1 in a container finding the lock file of its previous run
+			"own pid": strconv.Itoa(os.Getpid()),
+		} {
+			t.Run(name, func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "test.lock")
+				if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+				l, err := Acquire(path)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				_ = l.Release()
+			})
+		}
+	})
+
+	t.Run("release keeps a lock file replaced by another process", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.lock")
+		l, err := Acquire(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(path, []byte("1\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = l.Release(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err = os.Stat(path); err != nil {
+			t.Errorf("expected lock file to be kept, got: %v", err)
+		}
+	})
+
+	t.Run("concurrent goroutines", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.lock")
+		var holders, overlaps atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					l, err := Acquire(path)
+					if errors.Is(err, ErrLocked) {
+						continue
+					}
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+						return
+					}
+					if holders.Add(1) > 1 {
+						overlaps.Add(1)
+					}
+					data, err := os.ReadFile(path)
+					if err != nil || len(data) == 0 {
+						t.Errorf("expected the lock file to hold the pid, got %q, %v", data, err)
+					}
+					holders.Add(-1)
+					if err = l.Release(); err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if n := overlaps.Load(); n != 0 {
+			t.Errorf("the lock was held %d times by more than one goroutine", n)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+//go:build unix && !aix
+
+package lock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFile opens the lock file at path, creating it if needed
+func openFile(path string) (*os.File, error) {
+	// #nosec G304 -- path of the lock file is controlled by the caller
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+}
+
+// tryLock takes an exclusive flock on the file without waiting, errWouldBlock is returned if it is held
+func tryLock(file *os.File) error {
+	err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errWouldBlock
+	}
+	return err
+}
@@ -117,7 +117,7 @@ func (h *RemoteHandler) Run(sshc *ssh.Client, writer io.Writer) (err error) {
 		return fmt.Errorf("unable to set stdout pipe, %v", err)
 	}
 
-	err = sess.Start(h.Cmd())
+	err = sshc.StartCmd(sess, h.Cmd())
 	if err != nil {
 		return fmt.Errorf("unable to start ssh command: %v", err)
 	}
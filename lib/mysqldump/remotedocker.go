@@ -108,7 +108,7 @@ func GetSshDockerBinPath(sshc *ssh.Client, containerName string) (string, error)
 		}
 	}()
 
-	output, err := sess2.CombinedOutput(cmd)
+	output, err := sshc.CombinedOutputCmd(sess2, cmd)
 	if err != nil {
 		return "", fmt.Errorf("mysqldump not found in container %s: %v", containerName, err)
 	}
@@ -164,7 +164,7 @@ func (h *SshDockerHandler) Run(sshc *ssh.Client, writer io.Writer) (err error) {
 		return fmt.Errorf("unable to set stdout pipe, %v", err)
 	}
 
-	err = execSess.Start(dockerCmd)
+	err = sshc.StartCmd(execSess, dockerCmd)
 	if err != nil {
 		return fmt.Errorf("unable to start ssh command: %v", err)
 	}
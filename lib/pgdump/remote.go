@@ -126,7 +126,7 @@ func (h *RemoteHandler) Run(sshc *ssh.Client, writer io.Writer) (err error) {
 		cmd = h.Cmd()
 	}
 
-	err = sess.Start(cmd)
+	err = sshc.StartCmd(sess, cmd)
 	if err != nil {
 		return fmt.Errorf("unable to start ssh command: %w", err)
 	}
@@ -0,0 +1,141 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// TrustFunc is called when the key of a host is not found in any known_hosts file,
+// if it returns true the key is trusted and recorded in the known_hosts file.
+type TrustFunc func(hostname, keyType, fingerprint string) bool
+
+// ErrUnknownHostKey is returned when connecting to a host whose key is not known and not trusted
+var ErrUnknownHostKey = errors.New("unknown host key")
+
+// ErrHostKeyMismatch is returned when the host key differs from the known or pinned one
+var ErrHostKeyMismatch = errors.New("host key mismatch")
+
+// hostKeyCallback returns the callback used to verify the server host key, in order of precedence:
+// the key is ignored, compared to a pinned fingerprint or checked against the known_hosts files.
+func hostKeyCallback(cfg Cfg) (ssh.HostKeyCallback, error) {
+	if cfg.IgnoreHostKey {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		}, nil
+	}
+
+	if cfg.HostKey != "" {
+		return pinnedHostKey(cfg.HostKey), nil
+	}
+
+	userKnownHosts, err := UserKnownHostsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	// the file where newly trusted keys are written to
+	trustFile := userKnownHosts
+	if cfg.KnownHostsFile != "" {
+		trustFile = cfg.KnownHostsFile
+	}
+
+	// knownhosts.New fails on missing files, only use the existing ones
+	var files []string
+	for _, f := range []string{userKnownHosts, cfg.KnownHostsFile} {
+		if f == "" {
+			continue
+		}
+		if _, sErr := os.Stat(f); sErr == nil {
+			files = append(files, f)
+		}
+	}
+
+	var checker ssh.HostKeyCallback
+	if len(files) > 0 {
+		checker, err = knownhosts.New(files...)
+		if err != nil {
+			return nil, fmt.Errorf("error checking known_hosts: %v", err)
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if checker != nil {
+			cErr := checker(hostname, remote, key)
+			if cErr == nil {
+				return nil
+			}
+			var keyErr *knownhosts.KeyError
+			if !errors.As(cErr, &keyErr) {
+				return cErr
+			}
+			if len(keyErr.Want) > 0 {
+				return fmt.Errorf("%w for %s: %v", ErrHostKeyMismatch, hostname, cErr)
+			}
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		if cfg.TrustHostKey == nil || !cfg.TrustHostKey(hostname, key.Type(), fingerprint) {
+			return fmt.Errorf("%w for %s: %s %s", ErrUnknownHostKey, hostname, key.Type(), fingerprint)
+		}
+		return appendKnownHost(trustFile, hostname, key)
+	}, nil
+}
+
+// pinnedHostKey returns a callback that only accepts a key matching the SHA256 fingerprint
+func pinnedHostKey(fingerprint string) ssh.HostKeyCallback {
+	if !strings.HasPrefix(fingerprint, "SHA256:") {
+		fingerprint = "SHA256:" + fingerprint
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		got := ssh.FingerprintSHA256(key)
+		if got != fingerprint {
+			return fmt.Errorf("%w for %s: got %s, pinned %s", ErrHostKeyMismatch, hostname, got, fingerprint)
+		}
+		return nil
+	}
+}
+
+// appendKnownHost records the host key in a known_hosts file, creating it if needed
+func appendKnownHost(file, hostname string, key ssh.PublicKey) (err error) {
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return fmt.Errorf("unable to create known_hosts dir: %v", err)
+	}
+
+	// #nosec G304 -- path comes from config file
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open known_hosts file: %v", err)
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	_, err = f.WriteString(line + "\n")
+	if err != nil {
+		return fmt.Errorf("unable to write known_hosts file: %v", err)
+	}
+	return nil
+}
+
+// UserKnownHostsFile returns the known_hosts file of the current user: ~/.ssh/known_hosts
+func UserKnownHostsFile() (string, error) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting users home dir: %v", err)
+	}
+
+	knownHostsAbsFile, err := filepath.Abs(filepath.Join(userHome, ".ssh/known_hosts"))
+	if err != nil {
+		return "", fmt.Errorf("absolute path for known_hosts file: %v", err)
+	}
+	return knownHostsAbsFile, nil
+}
@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	hostname := "bla.ble.com:22"
+
+	t.Run("pinned host key", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+		key := newHostKey(t)
+
+		cb, err := hostKeyCallback(Cfg{HostKey: ssh.FingerprintSHA256(key)})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err = cb(hostname, remote, key); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		if err = cb(hostname, remote, newHostKey(t)); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+			t.Errorf("expected host key mismatch error, got %v", err)
+		}
+	})
+
+	t.Run("unknown host is rejected without trust", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+
+		cb, err := hostKeyCallback(Cfg{})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		err = cb(hostname, remote, newHostKey(t))
+		if !errors.Is(err, ErrUnknownHostKey) {
+			t.Errorf("expected unknown host key error, got %v", err)
+		}
+	})
+
+	t.Run("trust on first use records the key", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+		knownHosts := filepath.Join(t.TempDir(), "goback", "known_hosts")
+		key := newHostKey(t)
+
+		trusted := ""
+		cfg := Cfg{
+			KnownHostsFile: knownHosts,
+			TrustHostKey: func(hostname, keyType, fingerprint string) bool {
+				trusted = fingerprint
+				return true
+			},
+		}
+		cb, err := hostKeyCallback(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err = cb(hostname, remote, key); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if trusted != ssh.FingerprintSHA256(key) {
+			t.Errorf("trust function called with %s", trusted)
+		}
+
+		// a new callback reads the recorded key and must not ask again
+		cfg.TrustHostKey = nil
+		cb, err = hostKeyCallback(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err = cb(hostname, remote, key); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+
+		// a changed key is never trusted
+		cfg.TrustHostKey = func(hostname, keyType, fingerprint string) bool { return true }
+		cb, err = hostKeyCallback(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err = cb(hostname, remote, newHostKey(t)); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+			t.Errorf("expected host key mismatch error, got %v", err)
+		}
+	})
+}
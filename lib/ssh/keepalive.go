@@ -0,0 +1,101 @@
+package ssh
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keepAliveMaxFailures is the number of unanswered keepalive requests after which the connection is closed
+const keepAliveMaxFailures = 3
+
+// aliveTimeout is how long Alive waits for the answer of the server if keepalives are disabled
+const aliveTimeout = 15 * time.Second
+
+// RetryPolicy defines how often and how fast the connection setup is retried
+type RetryPolicy struct {
+	// Attempts is the number of retries after the first failed attempt, 0 disables retries
+	Attempts int
+	// Delay before the first retry, it doubles on every following retry
+	Delay time.Duration
+	// MaxDelay caps the delay between retries, ignored if zero
+	MaxDelay time.Duration
+}
+
+// backoff returns the delay before the retry number n, starting at 0
+func (r RetryPolicy) backoff(n int) time.Duration {
+	d := r.Delay
+	for i := 0; i < n; i++ {
+		d *= 2
+		if r.MaxDelay > 0 && d >= r.MaxDelay {
+			return r.MaxDelay
+		}
+	}
+	return d
+}
+
+// keepAlive sends keepalive requests on the connection every interval until stop is closed, a request not answered
+// within the interval is a failure; after keepAliveMaxFailures failures in a row the connection is closed, so that
+// blocked reads and writes fail instead of hanging.
+func keepAlive(conn *ssh.Client, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := sendKeepAlive(conn, interval)
+			if err == nil {
+				failures = 0
+				continue
+			}
+			failures++
+			if failures >= keepAliveMaxFailures {
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// sendKeepAlive sends a keepalive request and waits at most timeout for the answer, on a silently dropped link
+// the request would never return; it does once the connection is closed
+func sendKeepAlive(conn *ssh.Client, timeout time.Duration) error {
+	errC := make(chan error, 1)
+	go func() {
+		_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+		errC <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errC:
+		return err
+	case <-timer.C:
+		return errors.New("keepalive request timed out")
+	}
+}
+
+// Alive checks if the connection is still open by sending a keepalive request, the server has to answer
+// within the keepalive interval, or aliveTimeout if keepalives are disabled
+func (sshc *Client) Alive() bool {
+	if sshc.conn == nil {
+		return false
+	}
+	timeout := sshc.keepAlive
+	if timeout <= 0 {
+		timeout = aliveTimeout
+	}
+	return sendKeepAlive(sshc.conn, timeout) == nil
+}
+
+// Reconnect closes the current connection, if any, and opens it again using the retry policy
+func (sshc *Client) Reconnect() error {
+	sshc.closeConns()
+	return sshc.Connect()
+}
@@ -0,0 +1,238 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tcs := []struct {
+		name   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{
+			name:   "doubles the delay",
+			policy: RetryPolicy{Delay: time.Second},
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			name:   "capped by max delay",
+			policy: RetryPolicy{Delay: time.Second, MaxDelay: 3 * time.Second},
+			want:   []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, want := range tc.want {
+				got := tc.policy.backoff(i)
+				if got != want {
+					t.Errorf("retry %d: got %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectRetries(t *testing.T) {
+	// reserve a port and close it, so that every connection attempt is refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	cl, err := New(Cfg{
+		Host:          "127.0.0.1",
+		Port:          port,
+		Auth:          Password,
+		User:          "pwuser",
+		IgnoreHostKey: true,
+		Retry:         RetryPolicy{Attempts: 2, Delay: 20 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	start := time.Now()
+	err = cl.Connect()
+	if err == nil {
+		t.Fatal("expected an error but got none")
+	}
+	// two retries wait 20ms and 40ms
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("connect returned after %v, expected the retries to wait at least 60ms", elapsed)
+	}
+}
+
+func TestConnectDoesNotRetry(t *testing.T) {
+	tcs := []struct {
+		name string
+		cfg  func(srv *testServer, trusted *int) Cfg
+		// check verifies the error of the connection attempt
+		check func(err error) bool
+	}{
+		{
+			name: "unknown host key that is not trusted",
+			cfg: func(srv *testServer, trusted *int) Cfg {
+				cfg := srv.cfg()
+				cfg.HostKey = ""
+				cfg.TrustHostKey = func(hostname, keyType, fingerprint string) bool {
+					*trusted++
+					return false
+				}
+				return cfg
+			},
+			check: func(err error) bool { return errors.Is(err, ErrUnknownHostKey) },
+		},
+		{
+			name: "host key mismatch",
+			cfg: func(srv *testServer, trusted *int) Cfg {
+				cfg := srv.cfg()
+				cfg.HostKey = ssh.FingerprintSHA256(newHostKey(t))
+				return cfg
+			},
+			check: func(err error) bool { return errors.Is(err, ErrHostKeyMismatch) },
+		},
+		{
+			name: "wrong password",
+			cfg: func(srv *testServer, trusted *int) Cfg {
+				cfg := srv.cfg()
+				cfg.Password = "wrong"
+				return cfg
+			},
+			check: func(err error) bool { return strings.Contains(err.Error(), "unable to authenticate") },
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			srv := newTestServer(t, nil)
+			trusted := 0
+			cfg := tc.cfg(srv, &trusted)
+			cfg.Retry = RetryPolicy{Attempts: 3, Delay: time.Second}
+
+			cl, err := New(cfg)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			start := time.Now()
+			err = cl.Connect()
+			if err == nil || !tc.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("connect returned after %v, expected no retries", elapsed)
+			}
+			if trusted > 1 {
+				t.Errorf("expected the trust question to be asked once, got %d", trusted)
+			}
+			if logins := srv.logins.Load(); logins > 1 {
+				t.Errorf("expected at most one login attempt, got %d", logins)
+			}
+		})
+	}
+}
+
+func TestKeepAliveBlackHole(t *testing.T) {
+	srv := newTestServer(t, nil)
+	cfg := srv.cfg()
+	cfg.KeepAlive = 50 * time.Millisecond
+	cl, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err = cl.Connect(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer func() {
+		_ = cl.Disconnect()
+	}()
+	if !cl.Alive() {
+		t.Fatal("expected the connection to be alive")
+	}
+
+	// the server stops answering without closing the connection
+	srv.blackHole.Store(true)
+
+	start := time.Now()
+	if cl.Alive() {
+		t.Fatal("expected the connection to be reported as lost")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("alive returned after %v, expected it to time out after the keepalive interval", elapsed)
+	}
+
+	// the keepalives fail as well and close the connection
+	closed := make(chan struct{})
+	go func() {
+		_ = cl.conn.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the connection to be closed after the keepalive failures")
+	}
+}
+
+func TestSshReconnect(t *testing.T) {
+	skipInCI(t) // skip test if running in CI
+
+	ctx := context.Background()
+	sshServer, err := setupContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = sshServer.Terminate(ctx)
+	}()
+
+	cl, err := New(Cfg{
+		Host:          sshServer.host,
+		Port:          sshServer.port,
+		Auth:          Password,
+		User:          "pwuser",
+		Password:      "1234",
+		IgnoreHostKey: true,
+		KeepAlive:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = cl.Connect()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer func() {
+		_ = cl.Disconnect()
+	}()
+
+	// simulate a dropped connection
+	_ = cl.conn.Close()
+	if cl.Alive() {
+		t.Fatal("expected the connection to be reported as lost")
+	}
+
+	err = cl.Reconnect()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !cl.Alive() {
+		t.Fatal("expected the connection to be alive after reconnecting")
+	}
+
+	_, err = cl.Which("bash")
+	if err != nil {
+		t.Errorf("unexpected error after reconnecting: %v", err)
+	}
+}
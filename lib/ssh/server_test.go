@@ -0,0 +1,157 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an in process ssh server accepting the user pwuser with the password 1234,
+// the commands of exec requests are run by the exec func
+type testServer struct {
+	host    string
+	port    int
+	hostKey ssh.PublicKey
+	// exec runs a command with the channel as stdin and stdout and returns its exit status
+	exec func(cmd string, ch ssh.Channel) uint32
+	// blackHole drops global requests, e.g. keepalives, without answering them, like a silently dropped link
+	blackHole atomic.Bool
+	// logins counts the password authentication attempts
+	logins atomic.Int32
+
+	mu   sync.Mutex
+	cmds []string
+}
+
+func newTestServer(t *testing.T, exec func(cmd string, ch ssh.Channel) uint32) *testServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{hostKey: signer.PublicKey(), exec: exec}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			s.logins.Add(1)
+			if c.User() == "pwuser" && string(pass) == "1234" {
+				return nil, nil
+			}
+			return nil, errors.New("permission denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.host = "127.0.0.1"
+	s.port = l.Addr().(*net.TCPAddr).Port
+
+	var conns []net.Conn
+	var connsMu sync.Mutex
+	t.Cleanup(func() {
+		_ = l.Close()
+		connsMu.Lock()
+		defer connsMu.Unlock()
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, aErr := l.Accept()
+			if aErr != nil {
+				return
+			}
+			connsMu.Lock()
+			conns = append(conns, conn)
+			connsMu.Unlock()
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go func() {
+		for req := range reqs {
+			if s.blackHole.Load() {
+				continue
+			}
+			if req.WantReply {
+				_ = req.Reply(true, nil)
+			}
+		}
+	}()
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		ch, chReqs, aErr := newCh.Accept()
+		if aErr != nil {
+			continue
+		}
+		go func() {
+			for req := range chReqs {
+				if req.Type != "exec" {
+					if req.WantReply {
+						_ = req.Reply(false, nil)
+					}
+					continue
+				}
+				payload := struct{ Cmd string }{}
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+
+				s.mu.Lock()
+				s.cmds = append(s.cmds, payload.Cmd)
+				s.mu.Unlock()
+
+				go func() {
+					status := s.exec(payload.Cmd, ch)
+					_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+					_ = ch.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// commands returns the commands executed so far
+func (s *testServer) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+// cfg returns a client config to connect to the server with the password
+func (s *testServer) cfg() Cfg {
+	return Cfg{
+		Host:     s.host,
+		Port:     s.port,
+		Auth:     Password,
+		User:     "pwuser",
+		Password: "1234",
+		HostKey:  ssh.FingerprintSHA256(s.hostKey),
+	}
+}
@@ -6,12 +6,12 @@ import (
 	"github.com/AndresBott/goback/internal/profile"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
-	"golang.org/x/crypto/ssh/knownhosts"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type AuthType int
@@ -20,6 +20,8 @@ const (
 	Password AuthType = iota
 	PrivateKey
 	SshAgent
+	// Auto uses the private key if one is set, e.g. from the ssh config IdentityFile, and the ssh agent otherwise
+	Auto
 )
 
 type Cfg struct {
@@ -31,6 +33,24 @@ type Cfg struct {
 	PrivateKey    string
 	PassPhrase    string
 	IgnoreHostKey bool
+	// Jump is an ordered list of bastion hosts the connection is tunneled through
+	Jump []Cfg
+	// ConfigHost is a host alias resolved from the OpenSSH client config file, fields set explicitly take precedence
+	ConfigHost string
+	// ConfigFile overrides the default location of the ssh config: ~/.ssh/config
+	ConfigFile string
+	// HostKey pins the SHA256 fingerprint of the host key, known_hosts files are not used if set
+	HostKey string
+	// KnownHostsFile is checked in addition to ~/.ssh/known_hosts, trusted keys are recorded here if set
+	KnownHostsFile string
+	// TrustHostKey is called for hosts with an unknown key, see TrustFunc
+	TrustHostKey TrustFunc
+	// Sudo runs remote commands and the sftp server with elevated privileges
+	Sudo Sudo
+	// KeepAlive is the interval between keepalive requests, 0 disables them
+	KeepAlive time.Duration
+	// Retry defines how the connection setup is retried
+	Retry RetryPolicy
 }
 
 type Client struct {
@@ -38,18 +58,45 @@ type Client struct {
 	server    string
 	conn      *ssh.Client
 	agentConn net.Conn
+	// jumps holds the clients of the bastion hosts, and jumpConns their open connections
+	jumps     []*Client
+	jumpConns []*ssh.Client
+	sudo      Sudo
+	keepAlive time.Duration
+	retry     RetryPolicy
+	// stopKeepAlive is closed to stop the keepalive goroutine of the current connection
+	stopKeepAlive chan struct{}
 }
 
 func New(cfg Cfg) (*Client, error) {
 
+	if cfg.ConfigHost != "" {
+		var err error
+		cfg, err = applyHostConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if cfg.User == "" {
 		return nil, errors.New("user is a mandatory field")
 	}
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
 
 	var authMethod []ssh.AuthMethod
 	var sshAgentConnection net.Conn
 
-	switch cfg.Auth {
+	auth := cfg.Auth
+	if auth == Auto {
+		auth = SshAgent
+		if cfg.PrivateKey != "" {
+			auth = PrivateKey
+		}
+	}
+
+	switch auth {
 	// => Password authentication
 	case Password:
 		authMethod = append(authMethod, ssh.Password(cfg.Password))
@@ -92,27 +139,9 @@ func New(cfg Cfg) (*Client, error) {
 		return nil, errors.New("authentication type not provided")
 	}
 
-	var knownHostCallback func(hostname string, remote net.Addr, key ssh.PublicKey) error
-	if cfg.IgnoreHostKey {
-		knownHostCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		}
-	} else {
-
-		userHome, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("getting users home dir: %v", err)
-		}
-
-		knownHostsAbsFile, err := filepath.Abs(filepath.Join(userHome, ".ssh/known_hosts"))
-		if err != nil {
-			return nil, fmt.Errorf("absolute path for known_hosts file: %v", err)
-		}
-		fn, err := knownhosts.New(knownHostsAbsFile)
-		if err != nil {
-			return nil, fmt.Errorf("error checking known_hosts: %v", err)
-		}
-		knownHostCallback = fn
+	knownHostCallback, err := hostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	config := &ssh.ClientConfig{
@@ -125,6 +154,22 @@ func New(cfg Cfg) (*Client, error) {
 		config:    config,
 		server:    fmt.Sprintf("%v:%v", cfg.Host, cfg.Port),
 		agentConn: sshAgentConnection,
+		sudo:      cfg.Sudo,
+		keepAlive: cfg.KeepAlive,
+		retry:     cfg.Retry,
+	}
+
+	for _, jumpCfg := range cfg.Jump {
+		if len(jumpCfg.Jump) > 0 {
+			client.closeAgents()
+			return nil, errors.New("nested jump hosts are not supported")
+		}
+		jc, err := New(jumpCfg)
+		if err != nil {
+			client.closeAgents()
+			return nil, fmt.Errorf("jump host %s: %v", jumpCfg.Host, err)
+		}
+		client.jumps = append(client.jumps, jc)
 	}
 	return client, nil
 }
@@ -191,21 +236,102 @@ func sshAgentSigners() ([]ssh.Signer, *net.Conn, error) {
 	return signers, &conn, err
 }
 
+// Connect opens the connection to the server, through the jump hosts if any,
+// attempts that failed because of the network are retried according to the retry policy
 func (sshc *Client) Connect() error {
 	if sshc.conn != nil {
 		return errors.New("connection already open")
 	}
 
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = sshc.connect()
+		if err == nil || attempt >= sshc.retry.Attempts || !retryable(err) {
+			break
+		}
+		time.Sleep(sshc.retry.backoff(attempt))
+	}
+	if err != nil {
+		return err
+	}
+
+	if sshc.keepAlive > 0 {
+		sshc.stopKeepAlive = make(chan struct{})
+		go keepAlive(sshc.conn, sshc.keepAlive, sshc.stopKeepAlive)
+	}
+	return nil
+}
+
+// retryable reports whether a failed connection attempt is worth retrying, network errors are;
+// rejected host keys and failed authentications would fail the same way again
+func retryable(err error) bool {
+	var netErr net.Error
+	var openErr *ssh.OpenChannelError
+	return errors.As(err, &netErr) || errors.As(err, &openErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// connect performs a single connection attempt
+func (sshc *Client) connect() error {
+	// open the connections to the jump hosts, every hop is dialed through the previous one
+	var via *ssh.Client
+	for _, jump := range sshc.jumps {
+		conn, err := dialVia(via, jump.server, jump.config)
+		if err != nil {
+			sshc.closeJumpConns()
+			return fmt.Errorf("dial to jump host %v failed %w", jump.server, err)
+		}
+		sshc.jumpConns = append(sshc.jumpConns, conn)
+		via = conn
+	}
+
 	// open connection
-	conn, err := ssh.Dial("tcp", sshc.server, sshc.config)
+	conn, err := dialVia(via, sshc.server, sshc.config)
 	if err != nil {
-		return fmt.Errorf("dial to %v failed %v", sshc.server, err)
+		sshc.closeJumpConns()
+		return fmt.Errorf("dial to %v failed %w", sshc.server, err)
 	}
 	sshc.conn = conn
 
 	return nil
 }
 
+// dialVia opens an ssh connection to addr, if via is not nil the tcp connection is tunneled through it
+func dialVia(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+
+	netConn, err := via.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// closeJumpConns closes the connections to the jump hosts in reverse order
+func (sshc *Client) closeJumpConns() {
+	for i := len(sshc.jumpConns) - 1; i >= 0; i-- {
+		_ = sshc.jumpConns[i].Close()
+	}
+	sshc.jumpConns = nil
+}
+
+// closeAgents closes the ssh agent connections of the client and its jump hosts
+func (sshc *Client) closeAgents() {
+	if sshc.agentConn != nil {
+		_ = sshc.agentConn.Close()
+	}
+	for _, jump := range sshc.jumps {
+		jump.closeAgents()
+	}
+}
+
 func GetAuthType(in profile.ConnType) AuthType {
 	switch in {
 	case profile.ConnTypePasswd:
@@ -214,18 +340,31 @@ func GetAuthType(in profile.ConnType) AuthType {
 		return PrivateKey
 	case profile.ConnTypeSshAgent:
 		return SshAgent
+	case "":
+		return Auto
 	default:
 		return Password
 	}
 }
 
 func (sshc *Client) Disconnect() error {
-	if sshc.agentConn != nil {
-		_ = sshc.agentConn.Close()
+	sshc.closeAgents()
+	return sshc.closeConns()
+}
+
+// closeConns closes the connection to the server and the jump hosts, the ssh agent connections are kept
+func (sshc *Client) closeConns() error {
+	if sshc.stopKeepAlive != nil {
+		close(sshc.stopKeepAlive)
+		sshc.stopKeepAlive = nil
 	}
 
-	err := sshc.conn.Close()
-	sshc.conn = nil
+	var err error
+	if sshc.conn != nil {
+		err = sshc.conn.Close()
+		sshc.conn = nil
+	}
+	sshc.closeJumpConns()
 	return err
 }
 
@@ -179,6 +179,96 @@ func TestSshConnect(t *testing.T) {
 	})
 }
 
+func TestSshConnectJump(t *testing.T) {
+	skipInCI(t) // skip test if running in CI
+
+	ctx := context.Background()
+	sshServer, err := setupContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = sshServer.Terminate(ctx)
+	}()
+
+	t.Run("connect through a jump host", func(t *testing.T) {
+		// the container is used as bastion to reach its own ssh server on the loopback interface
+		cl, err := New(Cfg{
+			Host:          "127.0.0.1",
+			Port:          22,
+			Auth:          Password,
+			User:          "pwuser",
+			Password:      "1234",
+			IgnoreHostKey: true,
+			Jump: []Cfg{
+				{
+					Host:          sshServer.host,
+					Port:          sshServer.port,
+					Auth:          PrivateKey,
+					User:          "privkey",
+					PrivateKey:    "./sampledata/private.key",
+					PassPhrase:    "pass",
+					IgnoreHostKey: true,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		err = cl.Connect()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer func() {
+			_ = cl.Disconnect()
+		}()
+
+		session, err := cl.Session()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer func() {
+			_ = session.Close()
+		}()
+
+		got, err := session.CombinedOutput("pwd")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		want := []byte("/home/pwuser\n")
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestNewRejectsNestedJump(t *testing.T) {
+	_, err := New(Cfg{
+		Host:          "127.0.0.1",
+		Auth:          Password,
+		User:          "pwuser",
+		IgnoreHostKey: true,
+		Jump: []Cfg{
+			{
+				Host:          "bastion",
+				Auth:          Password,
+				User:          "pwuser",
+				IgnoreHostKey: true,
+				Jump:          []Cfg{{Host: "other", Auth: Password, User: "pwuser", IgnoreHostKey: true}},
+			},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an error but got none")
+	}
+	want := "nested jump hosts are not supported"
+	if err.Error() != want {
+		t.Errorf("unexpected error, got: %s, want: %s", err.Error(), want)
+	}
+}
+
 func TestClient_Which(t *testing.T) {
 	skipInCI(t) // skip test if running in CI
 
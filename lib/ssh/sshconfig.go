@@ -0,0 +1,348 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HostConfig holds the settings resolved for a host alias out of an OpenSSH client config file,
+// only the subset of keywords relevant to goback is read.
+type HostConfig struct {
+	HostName     string
+	User         string
+	Port         int
+	IdentityFile string
+	ProxyJump    []string
+}
+
+// maxIncludeDepth limits recursive Include directives
+const maxIncludeDepth = 16
+
+// DefaultConfigFile returns the location of the OpenSSH client config of the current user: ~/.ssh/config
+func DefaultConfigFile() (string, error) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting users home dir: %v", err)
+	}
+	return filepath.Join(userHome, ".ssh", "config"), nil
+}
+
+// LoadHostConfig reads an OpenSSH client config file and returns the settings that apply to the host alias.
+// Like OpenSSH, the first obtained value for each keyword is used; Match blocks other than "Match all" are ignored.
+func LoadHostConfig(file, alias string) (HostConfig, error) {
+	p := configParser{
+		alias: alias,
+		seen:  map[string]bool{},
+		base:  filepath.Dir(file),
+	}
+	err := p.parseFile(file, 0)
+	if err != nil {
+		return HostConfig{}, err
+	}
+
+	hc := p.hc
+	if hc.HostName != "" {
+		hc.HostName = expandTokens(hc.HostName, alias, hc)
+	}
+	if hc.IdentityFile != "" {
+		hc.IdentityFile = expandTokens(hc.IdentityFile, alias, hc)
+	}
+	return hc, nil
+}
+
+type configParser struct {
+	alias string
+	hc    HostConfig
+	seen  map[string]bool
+	// base is the directory used to resolve relative Include paths
+	base string
+}
+
+func (p *configParser) parseFile(file string, depth int) (err error) {
+	if depth > maxIncludeDepth {
+		return errors.New("ssh config: too many nested includes")
+	}
+
+	// #nosec G304 -- the file is the ssh config chosen by the user
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("ssh config: %v", err)
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+
+	return p.parse(f, depth)
+}
+
+func (p *configParser) parse(in io.Reader, depth int) error {
+	// lines before the first Host or Match keyword apply to all hosts
+	active := true
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		keyword, args := splitConfigLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			active = matchHost(p.alias, args)
+			continue
+		case "match":
+			active = len(args) == 1 && strings.EqualFold(args[0], "all")
+			continue
+		}
+
+		if !active {
+			continue
+		}
+
+		if keyword == "include" {
+			err := p.include(args, depth)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := p.set(keyword, args)
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ssh config: %v", err)
+	}
+	return nil
+}
+
+// include parses all the files matching the Include arguments, relative paths are resolved from the config dir
+func (p *configParser) include(args []string, depth int) error {
+	for _, arg := range args {
+		pattern := expandHome(arg)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(p.base, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("ssh config: invalid include %s: %v", arg, err)
+		}
+		for _, file := range files {
+			err = p.parseFile(file, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// set stores the value of a keyword unless it was already obtained before
+func (p *configParser) set(keyword string, args []string) error {
+	if len(args) == 0 || p.seen[keyword] {
+		return nil
+	}
+
+	switch keyword {
+	case "hostname":
+		p.hc.HostName = args[0]
+	case "user":
+		p.hc.User = args[0]
+	case "port":
+		port, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("ssh config: invalid port %s", args[0])
+		}
+		p.hc.Port = port
+	case "identityfile":
+		p.hc.IdentityFile = args[0]
+	case "proxyjump":
+		if !strings.EqualFold(args[0], "none") {
+			p.hc.ProxyJump = strings.Split(args[0], ",")
+		}
+	default:
+		return nil
+	}
+	p.seen[keyword] = true
+	return nil
+}
+
+// splitConfigLine returns the lower case keyword and the arguments of a config line,
+// both "Keyword value" and "Keyword=value" forms are accepted as well as double-quoted arguments
+func splitConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	idx := strings.IndexAny(line, " \t=")
+	if idx == -1 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:idx])
+	rest := strings.TrimLeft(line[idx:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	var cur strings.Builder
+	inQuote := false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case (r == ' ' || r == '\t') && !inQuote:
+			if cur.Len() > 0 {
+				args = append(args, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		args = append(args, cur.String())
+	}
+	return keyword, args
+}
+
+// matchHost checks the alias against the patterns of a Host line, negated patterns take precedence
+func matchHost(alias string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		ok, err := path.Match(pattern, alias)
+		if err != nil || !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// expandTokens replaces the tilde and the %h, %r, %d and %% tokens supported by OpenSSH
+func expandTokens(in, alias string, hc HostConfig) string {
+	host := hc.HostName
+	if host == "" {
+		host = alias
+	}
+	home, _ := os.UserHomeDir()
+
+	r := strings.NewReplacer("%%", "%", "%h", host, "%r", hc.User, "%d", home)
+	return r.Replace(expandHome(in))
+}
+
+// expandHome replaces a leading ~ with the home directory of the current user
+func expandHome(in string) string {
+	if in != "~" && !strings.HasPrefix(in, "~/") {
+		return in
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return in
+	}
+	return filepath.Join(home, in[1:])
+}
+
+// applyHostConfig fills the empty fields of cfg with the values resolved from the ssh config file,
+// explicitly set fields take precedence.
+func applyHostConfig(cfg Cfg) (Cfg, error) {
+	file := cfg.ConfigFile
+	if file == "" {
+		f, err := DefaultConfigFile()
+		if err != nil {
+			return cfg, err
+		}
+		file = f
+	}
+
+	hc, err := LoadHostConfig(file, cfg.ConfigHost)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.Host == "" {
+		cfg.Host = hc.HostName
+		if cfg.Host == "" {
+			cfg.Host = cfg.ConfigHost
+		}
+	}
+	if cfg.User == "" {
+		cfg.User = hc.User
+	}
+	if cfg.User == "" {
+		// same as OpenSSH, fall back to the local user name
+		if u, uErr := user.Current(); uErr == nil {
+			cfg.User = u.Username
+		}
+	}
+	if cfg.Port == 0 {
+		cfg.Port = hc.Port
+	}
+	if cfg.PrivateKey == "" {
+		cfg.PrivateKey = hc.IdentityFile
+	}
+
+	if len(cfg.Jump) == 0 {
+		for _, jump := range hc.ProxyJump {
+			jumpCfg, jErr := proxyJumpCfg(jump, file, cfg)
+			if jErr != nil {
+				return cfg, jErr
+			}
+			cfg.Jump = append(cfg.Jump, jumpCfg)
+		}
+	}
+	return cfg, nil
+}
+
+// proxyJumpCfg converts a ProxyJump entry in the form [user@]host[:port] into a Cfg,
+// the host is itself resolved against the ssh config file in case it is an alias.
+// The host key verification settings are inherited from the parent.
+func proxyJumpCfg(in, file string, parent Cfg) (Cfg, error) {
+	jump := Cfg{
+		Auth:           Auto,
+		ConfigFile:     file,
+		IgnoreHostKey:  parent.IgnoreHostKey,
+		KnownHostsFile: parent.KnownHostsFile,
+		TrustHostKey:   parent.TrustHostKey,
+	}
+
+	hostPort := in
+	if i := strings.LastIndex(in, "@"); i != -1 {
+		jump.User = in[:i]
+		hostPort = in[i+1:]
+	}
+
+	jump.ConfigHost = hostPort
+	if host, port, err := net.SplitHostPort(hostPort); err == nil {
+		p, pErr := strconv.Atoi(port)
+		if pErr != nil {
+			return jump, fmt.Errorf("ssh config: invalid ProxyJump port in %s", in)
+		}
+		jump.ConfigHost = host
+		jump.Port = p
+	}
+
+	jump, err := applyHostConfig(jump)
+	if err != nil {
+		return jump, err
+	}
+	// nested jumps of a jump host are not supported
+	jump.Jump = nil
+	return jump, nil
+}
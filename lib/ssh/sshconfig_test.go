@@ -0,0 +1,124 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadHostConfig(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name  string
+		alias string
+		want  HostConfig
+	}{
+		{
+			name:  "alias with all fields",
+			alias: "prod-db1",
+			want: HostConfig{
+				HostName:     "db1.prod.example.com",
+				User:         "backup",
+				Port:         2222,
+				IdentityFile: filepath.Join(home, ".ssh/id_db1.prod.example.com"),
+				ProxyJump:    []string{"bastion"},
+			},
+		},
+		{
+			name:  "equal sign and quoted values",
+			alias: "bastion",
+			want: HostConfig{
+				HostName:     "bastion.example.com",
+				User:         "jumper",
+				Port:         22,
+				IdentityFile: "/keys/bastion key",
+			},
+		},
+		{
+			name:  "host from included file",
+			alias: "web",
+			want: HostConfig{
+				HostName:  "web.example.com",
+				User:      "fallback",
+				Port:      22,
+				ProxyJump: []string{"admin@bastion:2200", "bastion"},
+			},
+		},
+		{
+			name:  "wildcard pattern",
+			alias: "db.internal",
+			want: HostConfig{
+				User: "internal",
+				Port: 22,
+			},
+		},
+		{
+			name:  "negated pattern",
+			alias: "secret.internal",
+			want: HostConfig{
+				User: "fallback",
+				Port: 22,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := LoadHostConfig("sampledata/sshconfig/config", tc.alias)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyHostConfig(t *testing.T) {
+	t.Run("explicit fields take precedence", func(t *testing.T) {
+		got, err := applyHostConfig(Cfg{
+			ConfigHost: "prod-db1",
+			ConfigFile: "sampledata/sshconfig/config",
+			User:       "override",
+			Auth:       Auto,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if got.Host != "db1.prod.example.com" || got.User != "override" || got.Port != 2222 {
+			t.Errorf("unexpected host values: %s@%s:%d", got.User, got.Host, got.Port)
+		}
+		if len(got.Jump) != 1 {
+			t.Fatalf("expected one jump host, got %d", len(got.Jump))
+		}
+		jump := got.Jump[0]
+		if jump.Host != "bastion.example.com" || jump.User != "jumper" || jump.PrivateKey != "/keys/bastion key" {
+			t.Errorf("unexpected jump values: %s@%s key: %s", jump.User, jump.Host, jump.PrivateKey)
+		}
+	})
+
+	t.Run("proxy jump with user and port", func(t *testing.T) {
+		got, err := applyHostConfig(Cfg{
+			ConfigHost: "web",
+			ConfigFile: "sampledata/sshconfig/config",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if len(got.Jump) != 2 {
+			t.Fatalf("expected two jump hosts, got %d", len(got.Jump))
+		}
+		jump := got.Jump[0]
+		if jump.Host != "bastion.example.com" || jump.User != "admin" || jump.Port != 2200 {
+			t.Errorf("unexpected jump values: %s@%s:%d", jump.User, jump.Host, jump.Port)
+		}
+	})
+}
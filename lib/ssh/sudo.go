@@ -0,0 +1,189 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultSftpServer is the location of the sftp-server binary on Debian based systems
+const defaultSftpServer = "/usr/lib/openssh/sftp-server"
+
+// Sudo holds the settings used to run remote commands and the sftp server with elevated privileges
+type Sudo struct {
+	Enabled bool
+	// User to run as instead of root
+	User string
+	// Password is written to the stdin of sudo if it asks for it, leave empty for passwordless sudo
+	Password string
+	// SftpServer is the path of the sftp-server binary on the remote host
+	SftpServer string
+}
+
+// sudoPrefix returns the sudo invocation without the command, with password sudo reads it from stdin
+func (s Sudo) sudoPrefix(password bool) string {
+	args := []string{"sudo"}
+	if password {
+		// read the password from stdin and don't print a prompt
+		args = append(args, "-S", "-p", "''")
+	} else {
+		// fail instead of waiting for a password
+		args = append(args, "-n")
+	}
+	if s.User != "" {
+		args = append(args, "-u", ShellQuote(s.User))
+	}
+	return strings.Join(args, " ")
+}
+
+// sudoCmd wraps a shell command so that it runs with sudo, if sudo is not enabled the command is returned unchanged
+func (s Sudo) sudoCmd(cmd string, password bool) string {
+	if !s.Enabled {
+		return cmd
+	}
+	return s.sudoPrefix(password) + " sh -c " + ShellQuote(cmd)
+}
+
+// sudoAsksPassword checks with "sudo -n true" if sudo needs the password, it does not with a NOPASSWD rule or
+// cached credentials; a password sent anyway would be read by the command as the start of its input
+func (sshc *Client) sudoAsksPassword() (bool, error) {
+	if !sshc.sudo.Enabled || sshc.sudo.Password == "" {
+		return false, nil
+	}
+	sess, err := sshc.Session()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = sess.Close()
+	}()
+
+	err = sess.Run(sshc.sudo.sudoPrefix(false) + " true")
+	if err == nil {
+		return false, nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return true, nil
+	}
+	return false, fmt.Errorf("unable to check sudo: %v", err)
+}
+
+// sudoSession returns the command wrapped with sudo and sets the password as stdin of the session if sudo asks for it
+func (sshc *Client) sudoSession(sess *ssh.Session, cmd string) (string, error) {
+	password, err := sshc.sudoAsksPassword()
+	if err != nil {
+		return "", err
+	}
+	if password {
+		sess.Stdin = strings.NewReader(sshc.sudo.Password + "\n")
+	}
+	return sshc.sudo.sudoCmd(cmd, password), nil
+}
+
+// StartCmd starts a command in the session, wrapped with sudo if the client is configured to do so
+func (sshc *Client) StartCmd(sess *ssh.Session, cmd string) error {
+	cmd, err := sshc.sudoSession(sess, cmd)
+	if err != nil {
+		return err
+	}
+	return sess.Start(cmd)
+}
+
+// CombinedOutputCmd runs a command in the session, wrapped with sudo if the client is configured to do so,
+// and returns its combined stdout and stderr
+func (sshc *Client) CombinedOutputCmd(sess *ssh.Session, cmd string) ([]byte, error) {
+	cmd, err := sshc.sudoSession(sess, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return sess.CombinedOutput(cmd)
+}
+
+// StartCmdWithStdin starts a command in the session that reads in from stdin, wrapped with sudo if the client
+// is configured to do so; the sudo password is sent ahead of the input if sudo asks for it
+func (sshc *Client) StartCmdWithStdin(sess *ssh.Session, cmd string, in io.Reader) error {
+	password, err := sshc.sudoAsksPassword()
+	if err != nil {
+		return err
+	}
+	sess.Stdin = in
+	if password {
+		sess.Stdin = io.MultiReader(strings.NewReader(sshc.sudo.Password+"\n"), in)
+	}
+	return sess.Start(sshc.sudo.sudoCmd(cmd, password))
+}
+
+// SftpClient returns an sftp client on the open connection, if sudo is enabled the sftp-server
+// binary is started through sudo instead of using the sftp subsystem of the ssh server
+func (sshc *Client) SftpClient() (*sftp.Client, error) {
+	if sshc.conn == nil {
+		return nil, fmt.Errorf("unable to create sftp client: connection not open")
+	}
+	if !sshc.sudo.Enabled {
+		return sftp.NewClient(sshc.conn)
+	}
+
+	sess, err := sshc.Session()
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		_ = sess.Close()
+		return nil, fmt.Errorf("unable to set stdin pipe: %v", err)
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		_ = sess.Close()
+		return nil, fmt.Errorf("unable to set stdout pipe: %v", err)
+	}
+
+	sftpServer := sshc.sudo.SftpServer
+	if sftpServer == "" {
+		sftpServer = defaultSftpServer
+	}
+
+	password, err := sshc.sudoAsksPassword()
+	if err != nil {
+		_ = sess.Close()
+		return nil, err
+	}
+	err = sess.Start(sshc.sudo.sudoPrefix(password) + " " + ShellQuote(sftpServer))
+	if err != nil {
+		_ = sess.Close()
+		return nil, fmt.Errorf("unable to start sftp server with sudo: %v", err)
+	}
+
+	// sudo reads the password byte by byte until the new line, the rest of stdin is left to the sftp server
+	if password {
+		_, err = io.WriteString(stdin, sshc.sudo.Password+"\n")
+		if err != nil {
+			_ = sess.Close()
+			return nil, fmt.Errorf("unable to write sudo password: %v", err)
+		}
+	}
+
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		_ = sess.Close()
+		return nil, fmt.Errorf("unable to create sftp client with sudo: %v", err)
+	}
+
+	// closing the sftp client closes stdin, which terminates the sftp server and the session
+	go func() {
+		_ = sess.Wait()
+		_ = sess.Close()
+	}()
+	return client, nil
+}
+
+// ShellQuote quotes a string to be used as a single argument in a POSIX shell
+func ShellQuote(in string) string {
+	return "'" + strings.ReplaceAll(in, "'", `'\''`) + "'"
+}
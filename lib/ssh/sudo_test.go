@@ -0,0 +1,214 @@
+package ssh
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSudoCmd(t *testing.T) {
+	tcs := []struct {
+		name     string
+		sudo     Sudo
+		password bool
+		cmd      string
+		want     string
+	}{
+		{
+			name: "sudo disabled",
+			sudo: Sudo{},
+			cmd:  "mysqldump --databases db",
+			want: "mysqldump --databases db",
+		},
+		{
+			name: "passwordless sudo",
+			sudo: Sudo{Enabled: true},
+			cmd:  "mysqldump --databases db",
+			want: "sudo -n sh -c 'mysqldump --databases db'",
+		},
+		{
+			name:     "sudo with password and user",
+			sudo:     Sudo{Enabled: true, User: "postgres", Password: "secret"},
+			password: true,
+			cmd:      "PGPASSWORD=it's pg_dump db",
+			want:     `sudo -S -p '' -u 'postgres' sh -c 'PGPASSWORD=it'\''s pg_dump db'`,
+		},
+		{
+			name: "password not asked by sudo",
+			sudo: Sudo{Enabled: true, User: "postgres", Password: "secret"},
+			cmd:  "pg_dump db",
+			want: `sudo -n -u 'postgres' sh -c 'pg_dump db'`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.sudo.sudoCmd(tc.cmd, tc.password)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// sudoServer starts a test server where "sudo -n true" fails if sudo asks for the password, sudo commands
+// reading the password from stdin record it; the sftp-server is served on the channel
+func sudoServer(t *testing.T, asksPassword bool) (*testServer, *string) {
+	password := new(string)
+	srv := newTestServer(t, func(cmd string, ch ssh.Channel) uint32 {
+		if cmd == "sudo -n true" {
+			if asksPassword {
+				return 1
+			}
+			return 0
+		}
+		if strings.HasPrefix(cmd, "sudo -S") {
+			// like sudo, read the password byte by byte until the new line
+			b := make([]byte, 1)
+			for {
+				if _, err := ch.Read(b); err != nil || b[0] == '\n' {
+					break
+				}
+				*password += string(b)
+			}
+		}
+		if strings.HasSuffix(cmd, "sftp-server'") {
+			server, err := sftp.NewServer(ch)
+			if err != nil {
+				return 1
+			}
+			_ = server.Serve()
+			return 0
+		}
+		in, _ := io.ReadAll(ch)
+		_, _ = ch.Write(in)
+		return 0
+	})
+	return srv, password
+}
+
+func TestSudoSftpClient(t *testing.T) {
+	tcs := []struct {
+		name         string
+		asksPassword bool
+		wantCmd      string
+		wantPassword string
+	}{
+		{
+			name:         "sudo asks for the password",
+			asksPassword: true,
+			wantCmd:      "sudo -S -p '' '/usr/lib/openssh/sftp-server'",
+			wantPassword: "secret",
+		},
+		{
+			name:    "password is not sent to a passwordless sudo",
+			wantCmd: "sudo -n '/usr/lib/openssh/sftp-server'",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, password := sudoServer(t, tc.asksPassword)
+			cfg := srv.cfg()
+			cfg.Sudo = Sudo{Enabled: true, Password: "secret"}
+			cl, err := New(cfg)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err = cl.Connect(); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			defer func() {
+				_ = cl.Disconnect()
+			}()
+
+			sftpc, err := cl.SftpClient()
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			defer func() {
+				_ = sftpc.Close()
+			}()
+			// the sftp protocol would be broken by a password left on stdin
+			if _, err = sftpc.Stat(t.TempDir()); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			want := []string{"sudo -n true", tc.wantCmd}
+			if diff := cmp.Diff(want, srv.commands()); diff != "" {
+				t.Errorf("commands mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantPassword, *password); diff != "" {
+				t.Errorf("password mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSudoStartCmdWithStdin(t *testing.T) {
+	tcs := []struct {
+		name         string
+		asksPassword bool
+		wantCmd      string
+		wantPassword string
+	}{
+		{
+			name:         "sudo asks for the password",
+			asksPassword: true,
+			wantCmd:      "sudo -S -p '' sh -c 'goback backup --stdout'",
+			wantPassword: "secret",
+		},
+		{
+			name:    "password is not sent to a passwordless sudo",
+			wantCmd: "sudo -n sh -c 'goback backup --stdout'",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, password := sudoServer(t, tc.asksPassword)
+			cfg := srv.cfg()
+			cfg.Sudo = Sudo{Enabled: true, Password: "secret"}
+			cl, err := New(cfg)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err = cl.Connect(); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			defer func() {
+				_ = cl.Disconnect()
+			}()
+
+			sess, err := cl.Session()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			sess.Stdout = &out
+			err = cl.StartCmdWithStdin(sess, "goback backup --stdout", strings.NewReader("name: web\n"))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err = sess.Wait(); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			// the command only gets the profile, never the password
+			if diff := cmp.Diff("name: web\n", out.String()); diff != "" {
+				t.Errorf("stdin mismatch (-want +got):\n%s", diff)
+			}
+			want := []string{"sudo -n true", tc.wantCmd}
+			if diff := cmp.Diff(want, srv.commands()); diff != "" {
+				t.Errorf("commands mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantPassword, *password); diff != "" {
+				t.Errorf("password mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// sampleSize is the size of the first block of a file used to detect incompressible data
+	sampleSize = 64 * 1024
+	// minSampleSize is the smallest sample worth checking, smaller files are always compressed
+	minSampleSize = 4 * 1024
+	// incompressibleRatio is the compressed to original size ratio above which data is stored as is
+	incompressibleRatio = 0.95
+)
+
+// DefaultStoreExtensions are the extensions of already compressed formats that are stored without compression
+var DefaultStoreExtensions = []string{
+	".7z", ".avi", ".bz2", ".docx", ".flac", ".gif", ".gz", ".heic", ".jar", ".jpeg", ".jpg", ".lz4", ".mkv",
+	".mov", ".mp3", ".mp4", ".odt", ".ogg", ".png", ".rar", ".tgz", ".webm", ".webp", ".xlsx", ".xz", ".zip", ".zst",
+}
+
+// Compression defines how the entries of the zip file are compressed
+type Compression struct {
+	// Level of the deflate compression from 1 (fastest) to 9 (best), 0 stores all entries
+	// without compression and -1 uses the default level
+	Level int
+	// StoreExtensions are file extensions, including the dot, stored without compression
+	StoreExtensions []string
+}
+
+// SetCompression changes the compression of the entries added after the call
+func (z *Handler) SetCompression(c Compression) {
+	z.level = c.Level
+	z.storeExt = map[string]bool{}
+	for _, ext := range c.StoreExtensions {
+		z.storeExt[strings.ToLower(ext)] = true
+	}
+
+	level := c.Level
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+	z.zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+}
+
+// method returns the compression method for an entry based on its name
+func (z *Handler) method(name string) uint16 {
+	if z.level == flate.NoCompression {
+		return zip.Store
+	}
+	if z.storeExt[strings.ToLower(filepath.Ext(name))] {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// incompressible compresses a sample of data with the fastest level to check if compression is worth it
+func incompressible(sample []byte) bool {
+	if len(sample) < minSampleSize {
+		return false
+	}
+
+	buf := bytes.Buffer{}
+	fw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return false
+	}
+	_, err = fw.Write(sample)
+	if err != nil {
+		return false
+	}
+	if err = fw.Close(); err != nil {
+		return false
+	}
+	return float64(buf.Len()) > float64(len(sample))*incompressibleRatio
+}
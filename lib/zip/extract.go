@@ -0,0 +1,310 @@
+package zip
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxLinkSize limits the size of a symlink target read from the archive
+const maxLinkSize = 4096
+
+// Extract writes the content of the zip file into the dest dir, restoring the mode, modification time,
+// extended attributes and hard links of files, symlinks and directories. The owner is only restored when
+// running as root, xattrs that need more privileges than the current user has are skipped.
+// The volumes of a split zip file are extracted as one, in can be the zip name or its first volume.
+// Symlinks are never followed, entries whose path would lead through a symlink are refused, so that an archive
+// cannot write outside of dest.
+func Extract(in string, dest string) (err error) {
+	read, closer, err := openReader(in)
+	if err != nil {
+		return fmt.Errorf("failed to open: %s", err)
+	}
+	defer func() {
+		err = errors.Join(err, closer.Close())
+	}()
+
+	restoreOwner := os.Geteuid() == 0
+
+	err = os.MkdirAll(dest, 0750)
+	if err != nil {
+		return fmt.Errorf("failed to create destination: %s", err)
+	}
+
+	// directory metadata is applied at the end, writing files into a dir changes its mtime
+	var dirs []*zip.File
+	// files holds the regular files extracted so far, hard links may only point to them
+	files := map[string]bool{}
+	for _, file := range read.File {
+		target, err := safeTarget(dest, file.Name)
+		if err != nil {
+			return err
+		}
+		mode := file.Mode()
+
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(target, 0700)
+			if err != nil {
+				return fmt.Errorf("failed to create dir %s: %s", target, err)
+			}
+			// MkdirAll accepts an existing symlink to a dir
+			err = checkNoSymlink(target)
+			dirs = append(dirs, file)
+		case mode&fs.ModeSymlink != 0:
+			err = extractSymlink(file, target, restoreOwner)
+		case isHardlink(file):
+			err = extractHardlink(file, dest, target, files)
+		default:
+			err = extractFile(file, target, restoreOwner)
+			files[filepath.Clean(file.Name)] = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// children first, so that read-only parents don't block them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		file := dirs[i]
+		// the path is checked again, later entries could have replaced its parents
+		target, err := safeTarget(dest, file.Name)
+		if err != nil {
+			return err
+		}
+		err = restoreMeta(file, target, restoreOwner)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// safeTarget returns the path of an entry within dest, it fails if the name leaves dest or if one of its parent
+// dirs that exists is not a directory, e.g. a symlink extracted earlier that would redirect writes outside of dest
+func safeTarget(dest, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("unsafe path in zip file: %s", name)
+	}
+	parent := filepath.Dir(filepath.Clean(name))
+	if parent == "." {
+		return filepath.Join(dest, name), nil
+	}
+
+	dir := dest
+	for _, part := range strings.Split(filepath.ToSlash(parent), "/") {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			// the rest of the path is created
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to stat %s: %s", dir, err)
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("unsafe path in zip file: %s, %s is not a directory", name, dir)
+		}
+	}
+	return filepath.Join(dest, name), nil
+}
+
+// checkNoSymlink fails if path is a symlink
+func checkNoSymlink(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", path, err)
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to follow symlink %s", path)
+	}
+	return nil
+}
+
+func extractFile(file *zip.File, target string, restoreOwner bool) (err error) {
+	err = os.MkdirAll(filepath.Dir(target), 0750)
+	if err != nil {
+		return fmt.Errorf("failed to create dir for %s: %s", target, err)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s in zip file: %s", file.Name, err)
+	}
+	defer func() {
+		err = errors.Join(err, rc.Close())
+	}()
+
+	// an existing symlink at target is not followed, opening it fails
+	// #nosec G304 -- target is checked to be within the destination
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|oNoFollow, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", target, err)
+	}
+	// #nosec G110 -- the archive is a backup created by goback
+	_, err = io.Copy(out, rc)
+	err = errors.Join(err, out.Close())
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %s", file.Name, err)
+	}
+
+	return restoreMeta(file, target, restoreOwner)
+}
+
+func extractSymlink(file *zip.File, target string, restoreOwner bool) (err error) {
+	err = os.MkdirAll(filepath.Dir(target), 0750)
+	if err != nil {
+		return fmt.Errorf("failed to create dir for %s: %s", target, err)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s in zip file: %s", file.Name, err)
+	}
+	defer func() {
+		err = errors.Join(err, rc.Close())
+	}()
+
+	link, err := io.ReadAll(io.LimitReader(rc, maxLinkSize))
+	if err != nil {
+		return fmt.Errorf("failed to read link %s: %s", file.Name, err)
+	}
+
+	err = os.Remove(target)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to replace %s: %s", target, err)
+	}
+	err = os.Symlink(string(link), target)
+	if err != nil {
+		return fmt.Errorf("failed to create link %s: %s", target, err)
+	}
+
+	if uid, gid, ok := parseOwnerExtra(file.Extra); ok && restoreOwner {
+		err = os.Lchown(target, uid, gid)
+		if err != nil {
+			return fmt.Errorf("failed to change owner of %s: %s", target, err)
+		}
+	}
+	return restoreXattrs(file, target)
+}
+
+func isHardlink(file *zip.File) bool {
+	_, ok := parseHardlinkExtra(file.Extra)
+	return ok
+}
+
+// extractHardlink links the target to a regular file extracted earlier in this run,
+// if linking is not possible the content is copied instead
+func extractHardlink(file *zip.File, dest, target string, files map[string]bool) error {
+	name, _ := parseHardlinkExtra(file.Extra)
+	if !filepath.IsLocal(name) || !files[filepath.Clean(name)] {
+		return fmt.Errorf("unsafe hard link in zip file: %s", name)
+	}
+	linkTo, err := safeTarget(dest, name)
+	if err != nil {
+		return err
+	}
+	// the file could have been replaced by a later entry, e.g. a symlink
+	info, err := os.Lstat(linkTo)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", linkTo, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("unsafe hard link in zip file: %s is not a regular file", name)
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0750)
+	if err != nil {
+		return fmt.Errorf("failed to create dir for %s: %s", target, err)
+	}
+
+	err = os.Remove(target)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to replace %s: %s", target, err)
+	}
+	err = os.Link(linkTo, target)
+	if err == nil {
+		return nil
+	}
+	return copyFile(linkTo, target)
+}
+
+// copyFile copies the content and mode of a file, symlinks are not followed
+func copyFile(origin, target string) (err error) {
+	// #nosec G304 -- origin is checked to be within the destination
+	in, err := os.OpenFile(origin, os.O_RDONLY|oNoFollow, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", origin, err)
+	}
+	defer func() {
+		err = errors.Join(err, in.Close())
+	}()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", origin, err)
+	}
+
+	// #nosec G304 -- target is checked to be within the destination
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|oNoFollow, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", target, err)
+	}
+	_, err = io.Copy(out, in)
+	err = errors.Join(err, out.Close())
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %s", origin, err)
+	}
+	return nil
+}
+
+// restoreMeta applies owner, mode and modification time of the zip entry to the extracted file,
+// the owner goes first since changing it clears the setuid and setgid bits
+func restoreMeta(file *zip.File, target string, restoreOwner bool) error {
+	// chmod and chtimes follow symlinks
+	err := checkNoSymlink(target)
+	if err != nil {
+		return err
+	}
+
+	if uid, gid, ok := parseOwnerExtra(file.Extra); ok && restoreOwner {
+		err := os.Lchown(target, uid, gid)
+		if err != nil {
+			return fmt.Errorf("failed to change owner of %s: %s", target, err)
+		}
+	}
+
+	err = restoreXattrs(file, target)
+	if err != nil {
+		return err
+	}
+
+	mode := file.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	err = os.Chmod(target, mode)
+	if err != nil {
+		return fmt.Errorf("failed to change mode of %s: %s", target, err)
+	}
+
+	if !file.Modified.IsZero() {
+		err = os.Chtimes(target, time.Time{}, file.Modified)
+		if err != nil {
+			return fmt.Errorf("failed to change mtime of %s: %s", target, err)
+		}
+	}
+	return nil
+}
+
+// restoreXattrs sets the extended attributes and ACLs stored in the zip entry,
+// the ones that need more privileges than the current user has are skipped
+func restoreXattrs(file *zip.File, target string) error {
+	attrs, err := parseXattrsExtra(file.Extra)
+	if err != nil {
+		return fmt.Errorf("failed to read xattrs of %s: %s", file.Name, err)
+	}
+	return writeXattrs(target, attrs)
+}
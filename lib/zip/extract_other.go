@@ -0,0 +1,6 @@
+//go:build !unix
+
+package zip
+
+// oNoFollow is only supported on unix, elsewhere only the checks of the target path protect against symlinks
+const oNoFollow = 0
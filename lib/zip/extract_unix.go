@@ -0,0 +1,10 @@
+//go:build unix
+
+package zip
+
+import (
+	"syscall"
+)
+
+// oNoFollow makes opening a symlink fail instead of following it
+const oNoFollow = syscall.O_NOFOLLOW
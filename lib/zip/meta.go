@@ -0,0 +1,201 @@
+package zip
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	// extraUnixOwner is the id of the Info-ZIP "new unix" extra field that holds the uid and gid of a file
+	extraUnixOwner = 0x7875
+	// extraXattrs is the id of the goback extra field that holds the extended attributes of a file
+	extraXattrs = 0x7867
+	// extraHardlink is the id of the goback extra field that holds the archive path a hard link points to
+	extraHardlink = 0x6c67
+
+	// maxExtraSize is the space available to extra fields in a zip header, some room is left for the
+	// fields added by the zip writer itself
+	maxExtraSize = 0xffff - 1024
+)
+
+// xattr is a single extended attribute
+type xattr struct {
+	name  string
+	value []byte
+}
+
+// fileHeader creates a zip header for the file info that keeps its mode, modification time and owner
+func fileHeader(info os.FileInfo, dest string) (*zip.FileHeader, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = dest
+	header.Method = zip.Deflate
+	if info.IsDir() {
+		header.Name = dest + "/"
+		header.Method = zip.Store
+	}
+
+	if uid, gid, ok := fileOwner(info); ok {
+		header.Extra = append(header.Extra, ownerExtra(uid, gid)...)
+	}
+	return header, nil
+}
+
+// localHeader creates the zip header of a local file, including its extended attributes
+func localHeader(origin string, info os.FileInfo, dest string) (*zip.FileHeader, error) {
+	header, err := fileHeader(info, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := readXattrs(origin)
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs) > 0 {
+		extra := xattrsExtra(attrs)
+		if len(header.Extra)+len(extra) > maxExtraSize {
+			return nil, fmt.Errorf("extended attributes of %s are too large to be stored", origin)
+		}
+		header.Extra = append(header.Extra, extra...)
+	}
+	return header, nil
+}
+
+// fileOwner returns the uid and gid of local files and of files stat-ed over sftp
+func fileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	if sys, ok := info.Sys().(*sftp.FileStat); ok {
+		return sys.UID, sys.GID, true
+	}
+	return localOwner(info)
+}
+
+// ownerExtra encodes the uid and gid as Info-ZIP unix extra field
+func ownerExtra(uid, gid uint32) []byte {
+	b := make([]byte, 0, 11)
+	b = append(b, 1, 4) // version 1, 4 byte uid
+	b = binary.LittleEndian.AppendUint32(b, uid)
+	b = append(b, 4) // 4 byte gid
+	b = binary.LittleEndian.AppendUint32(b, gid)
+	return extraField(extraUnixOwner, b)
+}
+
+// parseOwnerExtra reads the uid and gid out of the extra fields of a zip header, if present
+func parseOwnerExtra(extra []byte) (int, int, bool) {
+	data, ok := findExtra(extra, extraUnixOwner)
+	if !ok || len(data) < 2 || data[0] != 1 {
+		return 0, 0, false
+	}
+	uid, rest, ok := readVarUint(data[1:])
+	if !ok {
+		return 0, 0, false
+	}
+	gid, _, ok := readVarUint(rest)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(uid), int(gid), true
+}
+
+// readVarUint reads a size prefixed little endian integer of up to 8 bytes
+func readVarUint(b []byte) (uint64, []byte, bool) {
+	if len(b) < 1 {
+		return 0, nil, false
+	}
+	n := int(b[0])
+	if n > 8 || len(b) < 1+n {
+		return 0, nil, false
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[1+i])
+	}
+	return v, b[1+n:], true
+}
+
+// extraField encodes an extra field block
+func extraField(tag uint16, data []byte) []byte {
+	b := make([]byte, 0, 4+len(data))
+	b = binary.LittleEndian.AppendUint16(b, tag)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(data))) // #nosec G115 -- size is checked by the callers
+	return append(b, data...)
+}
+
+// findExtra returns the data of the first extra field block with the tag
+func findExtra(extra []byte, tag uint16) ([]byte, bool) {
+	for len(extra) >= 4 {
+		t := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			return nil, false
+		}
+		if t == tag {
+			return extra[4 : 4+size], true
+		}
+		extra = extra[4+size:]
+	}
+	return nil, false
+}
+
+// xattrsExtra encodes the extended attributes as a sequence of length prefixed names and values
+func xattrsExtra(attrs []xattr) []byte {
+	var data []byte
+	for _, a := range attrs {
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(a.name))) // #nosec G115 -- xattr names are limited to 255 bytes
+		data = append(data, a.name...)
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(a.value))) // #nosec G115 -- total size is checked by the caller
+		data = append(data, a.value...)
+	}
+	return extraField(extraXattrs, data)
+}
+
+// parseXattrsExtra reads the extended attributes out of the extra fields of a zip header, if present
+func parseXattrsExtra(extra []byte) ([]xattr, error) {
+	data, ok := findExtra(extra, extraXattrs)
+	if !ok {
+		return nil, nil
+	}
+
+	var attrs []xattr
+	for len(data) > 0 {
+		name, rest, ok := readPrefixed(data)
+		if !ok {
+			return nil, errors.New("invalid xattr extra field")
+		}
+		value, rest, ok := readPrefixed(rest)
+		if !ok {
+			return nil, errors.New("invalid xattr extra field")
+		}
+		attrs = append(attrs, xattr{name: string(name), value: value})
+		data = rest
+	}
+	return attrs, nil
+}
+
+// readPrefixed reads a byte slice prefixed by its uint16 length
+func readPrefixed(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := int(binary.LittleEndian.Uint16(b[0:2]))
+	if len(b) < 2+n {
+		return nil, nil, false
+	}
+	return b[2 : 2+n], b[2+n:], true
+}
+
+// parseHardlinkExtra returns the archive path a hard link entry points to, if the entry is a hard link
+func parseHardlinkExtra(extra []byte) (string, bool) {
+	data, ok := findExtra(extra, extraHardlink)
+	if !ok {
+		return "", false
+	}
+	return string(data), true
+}
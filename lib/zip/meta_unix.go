@@ -0,0 +1,17 @@
+//go:build unix
+
+package zip
+
+import (
+	"os"
+	"syscall"
+)
+
+// localOwner returns the uid and gid of a local file
+func localOwner(info os.FileInfo) (uint32, uint32, bool) {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return sys.Uid, sys.Gid, true
+}
@@ -0,0 +1,135 @@
+package zip
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// volumeSuffix matches the number appended to the volumes of a split archive, e.g. backup.zip.001
+var volumeSuffix = regexp.MustCompile(`\.[0-9]{3}$`)
+
+// VolumeName returns the file name of volume n, starting at 1, of the split archive dest
+func VolumeName(dest string, n int) string {
+	return fmt.Sprintf("%s.%03d", dest, n)
+}
+
+// VolumeBase returns the name of the archive a volume belongs to, names that are not volumes are returned as is
+func VolumeBase(name string) string {
+	return volumeSuffix.ReplaceAllString(name, "")
+}
+
+// Volumes returns the files that make up the archive in, either the archive itself or all the volumes
+// of a split archive in order; in can be the archive name or the name of its first volume
+func Volumes(in string) ([]string, error) {
+	base := VolumeBase(in)
+	if base == in {
+		if _, err := os.Stat(in); err == nil {
+			return []string{in}, nil
+		}
+	}
+
+	var files []string
+	for n := 1; ; n++ {
+		name := VolumeName(base, n)
+		_, err := os.Stat(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: %w", in, fs.ErrNotExist)
+	}
+	return files, nil
+}
+
+// volumeReader reads the volumes of a split archive as one continuous file
+type volumeReader struct {
+	files []*os.File
+	// offsets holds the position of every volume within the archive
+	offsets []int64
+	size    int64
+}
+
+func openVolumes(names []string) (*volumeReader, error) {
+	v := &volumeReader{}
+	for _, name := range names {
+		// #nosec G304 -- expected, since we are reading backups
+		file, err := os.Open(name)
+		if err != nil {
+			_ = v.Close()
+			return nil, err
+		}
+		v.files = append(v.files, file)
+
+		info, err := file.Stat()
+		if err != nil {
+			_ = v.Close()
+			return nil, err
+		}
+		v.offsets = append(v.offsets, v.size)
+		v.size += info.Size()
+	}
+	return v, nil
+}
+
+func (v *volumeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= v.size {
+		return 0, io.EOF
+	}
+	// last volume starting at or before off
+	i := sort.Search(len(v.offsets), func(i int) bool { return v.offsets[i] > off }) - 1
+
+	total := 0
+	for len(p) > 0 && i < len(v.files) {
+		n, err := v.files[i].ReadAt(p, off-v.offsets[i])
+		total += n
+		off += int64(n)
+		p = p[n:]
+		if err != nil && !errors.Is(err, io.EOF) {
+			return total, err
+		}
+		if len(p) > 0 {
+			i++
+		}
+	}
+	if len(p) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+func (v *volumeReader) Close() error {
+	var errs error
+	for _, f := range v.files {
+		errs = errors.Join(errs, f.Close())
+	}
+	return errs
+}
+
+// openReader opens a zip archive for reading, split archives are read as a single one
+func openReader(in string) (*zip.Reader, io.Closer, error) {
+	names, err := Volumes(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	vr, err := openVolumes(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	read, err := zip.NewReader(vr, vr.size)
+	if err != nil {
+		_ = vr.Close()
+		return nil, nil, err
+	}
+	return read, vr, nil
+}
@@ -0,0 +1,125 @@
+package zip
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVolumeBase(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want string
+	}{
+		{in: "name_backup.zip", want: "name_backup.zip"},
+		{in: "name_backup.zip.001", want: "name_backup.zip"},
+		{in: "name_backup.zip.123", want: "name_backup.zip"},
+		{in: "name_backup.zip.01", want: "name_backup.zip.01"},
+		{in: "name_backup.zip.001.part", want: "name_backup.zip.001.part"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.in, func(t *testing.T) {
+			if got := VolumeBase(tc.in); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitArchive(t *testing.T) {
+	src := t.TempDir()
+	// random data is stored without compression, so the archive spans several volumes
+	content := make([]byte, 100*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "data.bin"), content, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	zipFile := filepath.Join(dir, "split.zip")
+	zh, err := NewSplit(zipFile, 30*1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = zh.AddFile(filepath.Join(src, "data.bin"), "dir/data.bin"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = zh.WriteFile(strings.NewReader("dump"), "_mysqldump/db.dump.sql"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	zh.Close()
+
+	if _, err = os.Stat(zipFile); !os.IsNotExist(err) {
+		t.Errorf("expected %s to not exist, got: %v", zipFile, err)
+	}
+
+	volumes, err := Volumes(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantVolumes := []string{zipFile + ".001", zipFile + ".002", zipFile + ".003", zipFile + ".004"}
+	if diff := cmp.Diff(wantVolumes, volumes); diff != "" {
+		t.Errorf("volumes mismatch (-want +got):\n%s", diff)
+	}
+	for _, v := range volumes[:len(volumes)-1] {
+		info, err := os.Stat(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 30*1024 {
+			t.Errorf("expected volume %s to be %d bytes, got %d", v, 30*1024, info.Size())
+		}
+	}
+
+	t.Run("list files by zip name and first volume", func(t *testing.T) {
+		want := []string{"dir/data.bin", "_mysqldump/db.dump.sql"}
+		for _, in := range []string{zipFile, zipFile + ".001"} {
+			got, err := ListFiles(in)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		}
+	})
+
+	t.Run("verify and extract", func(t *testing.T) {
+		if err := Verify(zipFile); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		dest := t.TempDir()
+		if err := Extract(zipFile+".001", dest); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := os.ReadFile(filepath.Join(dest, "dir/data.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(content, got) {
+			t.Error("extracted content does not match the original")
+		}
+	})
+
+	t.Run("verify detects a corrupted volume", func(t *testing.T) {
+		f, err := os.OpenFile(zipFile+".002", os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.WriteAt([]byte("corrupted"), 100); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+
+		if err := Verify(zipFile); err == nil {
+			t.Error("expected an error but got none")
+		}
+	})
+}
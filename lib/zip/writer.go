@@ -0,0 +1,177 @@
+package zip
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// TmpExt is appended to the hidden temp files an archive is written into before it is renamed to its final name
+const TmpExt = ".tmp"
+
+// maxVolumes is the number of volumes a split archive can have, the volume suffix has 3 digits
+const maxVolumes = 999
+
+// TmpName returns the hidden temp file name used while writing the file name
+func TmpName(name string) string {
+	return filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+TmpExt)
+}
+
+// Writer writes an archive into hidden temp files next to its destination, the files are only renamed
+// to their final names by Commit, so that an incomplete archive never has a valid backup name.
+// If a split size is set the content is split into volumes of at most that size named dest.001, dest.002...,
+// concatenating the volumes results in the original stream.
+type Writer struct {
+	dest string
+	size int64
+	// n is the number of the current volume
+	n       int
+	written int64
+	file    *os.File
+	// names holds the final names of the files written so far
+	names []string
+	done  bool
+}
+
+// Create opens the destination of an archive for writing, if splitSize is bigger than 0 the content
+// is split into volumes of at most splitSize bytes; Commit or Abort need to be called at the end
+func Create(dest string, splitSize int64) (*Writer, error) {
+	w := &Writer{dest: dest, size: splitSize}
+	if err := w.next(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// next syncs and closes the current file and opens the following volume
+func (w *Writer) next() error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	name := w.dest
+	if w.size > 0 {
+		if w.n == maxVolumes {
+			return fmt.Errorf("archive exceeds %d volumes of %d bytes, the split size is too small", maxVolumes, w.size)
+		}
+		w.n++
+		name = VolumeName(w.dest, w.n)
+	}
+	w.written = 0
+
+	// #nosec G304 -- expected, since we are writing the backup destination
+	file, err := os.OpenFile(TmpName(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.names = append(w.names, name)
+	return nil
+}
+
+// closeFile flushes the current file to disk and closes it
+func (w *Writer) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	err := errors.Join(w.file.Sync(), w.file.Close())
+	w.file = nil
+	return err
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.file == nil {
+			return total, errors.New("archive writer is closed")
+		}
+		if w.size > 0 && w.written == w.size {
+			if err := w.next(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if left := w.size - w.written; w.size > 0 && int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+		n, err := w.file.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// Commit syncs the written files to disk and renames them to their final names, if a rename fails the files
+// already renamed are deleted, so that an incomplete set of volumes never has a valid backup name
+func (w *Writer) Commit() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	err := w.closeFile()
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to sync zip file: %v", err), w.removeTmp())
+	}
+	for i, name := range w.names {
+		err = os.Rename(TmpName(name), name)
+		if err != nil {
+			errs := []error{fmt.Errorf("failed to rename zip file: %v", err), w.removeTmp()}
+			for _, renamed := range w.names[:i] {
+				errs = append(errs, os.Remove(renamed))
+			}
+			return errors.Join(errs...)
+		}
+	}
+	return syncDir(filepath.Dir(w.dest))
+}
+
+// Abort closes and deletes the temp files written so far
+func (w *Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if w.file != nil {
+		_ = w.file.Close()
+		w.file = nil
+	}
+	return w.removeTmp()
+}
+
+// Close aborts the archive if it was not committed, it allows to defer the cleanup of failed writes
+func (w *Writer) Close() error {
+	return w.Abort()
+}
+
+// removeTmp deletes the temp files that were not renamed yet
+func (w *Writer) removeTmp() error {
+	var errs error
+	for _, name := range w.names {
+		err := os.Remove(TmpName(name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// syncDir flushes the directory entry of renamed files to disk
+func syncDir(dir string) error {
+	// #nosec G304 -- expected, since we are writing the backup destination
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open dir %s: %v", dir, err)
+	}
+	err = d.Sync()
+	err = errors.Join(err, d.Close())
+	if err != nil {
+		return fmt.Errorf("failed to sync dir %s: %v", dir, err)
+	}
+	return nil
+}
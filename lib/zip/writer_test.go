@@ -0,0 +1,141 @@
+package zip
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAtomicWrite(t *testing.T) {
+	listDir := func(t *testing.T, dir string) []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		return got
+	}
+
+	tcs := []struct {
+		name       string
+		splitSize  int64
+		whileOpen  []string
+		afterClose []string
+	}{
+		{
+			name:       "single file",
+			whileOpen:  []string{".out.zip.tmp"},
+			afterClose: []string{"out.zip"},
+		},
+		{
+			name:       "split file",
+			splitSize:  100,
+			whileOpen:  []string{".out.zip.001.tmp"},
+			afterClose: []string{"out.zip.001", "out.zip.002"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			zh, err := NewSplit(filepath.Join(dir, "out.zip"), tc.splitSize)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.whileOpen, listDir(t, dir)); diff != "" {
+				t.Errorf("files while writing mismatch (-want +got):\n%s", diff)
+			}
+
+			if err = zh.WriteFile(strings.NewReader("content"), "file.txt"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err = zh.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.afterClose, listDir(t, dir)); diff != "" {
+				t.Errorf("files after close mismatch (-want +got):\n%s", diff)
+			}
+
+			got, err := ListFiles(filepath.Join(dir, "out.zip"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff([]string{"file.txt"}, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+
+		t.Run(tc.name+" aborted", func(t *testing.T) {
+			dir := t.TempDir()
+			zh, err := NewSplit(filepath.Join(dir, "out.zip"), tc.splitSize)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err = zh.WriteFile(strings.NewReader(strings.Repeat("content", 20)), "file.txt"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err = zh.Abort(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := listDir(t, dir); len(got) != 0 {
+				t.Errorf("expected no files after abort, got: %v", got)
+			}
+		})
+	}
+}
+
+func TestCommitRenameFailure(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Create(filepath.Join(dir, "out.zip"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(strings.Repeat("x", 250))); err != nil {
+		t.Fatal(err)
+	}
+	// a dir in place of the second volume makes its rename fail
+	if err = os.Mkdir(filepath.Join(dir, "out.zip.002"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Commit()
+	if err == nil || !strings.Contains(err.Error(), "failed to rename zip file") {
+		t.Fatalf("expected a rename error, got: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if diff := cmp.Diff([]string{"out.zip.002"}, got); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMaxVolumes(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Create(filepath.Join(dir, "out.zip"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+
+	n, err := w.Write([]byte(strings.Repeat("x", maxVolumes+1)))
+	if err == nil || !strings.Contains(err.Error(), "archive exceeds 999 volumes") {
+		t.Fatalf("expected a volume limit error, got: %v", err)
+	}
+	if n != maxVolumes {
+		t.Errorf("got %d bytes written, want %d", n, maxVolumes)
+	}
+}
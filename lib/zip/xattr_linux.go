@@ -0,0 +1,75 @@
+package zip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of a file, without following symlinks,
+// POSIX ACLs are included as system.posix_acl_access and system.posix_acl_default
+func readXattrs(path string) ([]xattr, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list xattrs of %s: %s", path, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list xattrs of %s: %s", path, err)
+	}
+
+	var attrs []xattr
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, vErr := readXattr(path, string(name))
+		if vErr != nil {
+			return nil, vErr
+		}
+		attrs = append(attrs, xattr{name: string(name), value: value})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].name < attrs[j].name
+	})
+	return attrs, nil
+}
+
+func readXattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xattr %s of %s: %s", name, path, err)
+	}
+	value := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xattr %s of %s: %s", name, path, err)
+	}
+	return value[:size], nil
+}
+
+// writeXattrs sets the extended attributes on a file, attributes that cannot be set because of missing
+// privileges or lack of support in the destination file system are skipped
+func writeXattrs(path string, attrs []xattr) error {
+	for _, attr := range attrs {
+		err := unix.Lsetxattr(path, attr.name, attr.value, 0)
+		if err != nil {
+			if errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EACCES) {
+				continue
+			}
+			return fmt.Errorf("failed to set xattr %s of %s: %s", attr.name, path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package zip
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestExtractKeepsXattrs(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "labelled.txt")
+	err := os.WriteFile(src, []byte("content"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = unix.Setxattr(src, "user.goback.test", []byte("value"), 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		t.Skip("file system does not support user xattrs")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zipFile := filepath.Join(t.TempDir(), "xattr.zip")
+	zh, err := New(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = zh.AddFile(src, "labelled.txt"); err != nil {
+		t.Fatal(err)
+	}
+	zh.Close()
+
+	dest := t.TempDir()
+	err = Extract(zipFile, dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := unix.Getxattr(filepath.Join(dest, "labelled.txt"), "user.goback.test", buf)
+	if err != nil {
+		t.Fatalf("xattr not restored: %v", err)
+	}
+	if string(buf[:n]) != "value" {
+		t.Errorf("got xattr %q, want %q", buf[:n], "value")
+	}
+}
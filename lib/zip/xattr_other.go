@@ -0,0 +1,13 @@
+//go:build !linux
+
+package zip
+
+// readXattrs is only supported on linux
+func readXattrs(path string) ([]xattr, error) {
+	return nil, nil
+}
+
+// writeXattrs is only supported on linux
+func writeXattrs(path string, attrs []xattr) error {
+	return nil
+}
@@ -7,44 +7,115 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type Handler struct {
 	isOpen    bool
-	file      *os.File
+	file      *Writer
 	zipWriter *zip.Writer
+	level     int
+	storeExt  map[string]bool
+	// read and written count the content added to the entries and the bytes written to the zip file,
+	// they are read while the handler is in use to report the progress
+	read    atomic.Int64
+	written atomic.Int64
 }
 
-// Close the zipwriter as well as the file handler
-func (z *Handler) Close() {
-	if z.isOpen {
-		z.isOpen = false
-		_ = z.zipWriter.Close()
-		_ = z.file.Close()
+// Close the zipwriter as well as the file handler, the zip file only gets its final name
+// once it is completely written and synced to disk
+func (z *Handler) Close() error {
+	if !z.isOpen {
+		return nil
+	}
+	z.isOpen = false
+	err := z.zipWriter.Close()
+	if z.file == nil {
+		return err
+	}
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to close zip writer: %v", err), z.file.Abort())
 	}
+	return z.file.Commit()
+}
+
+// Abort stops writing the zip file and deletes what was written so far
+func (z *Handler) Abort() error {
+	if !z.isOpen {
+		return nil
+	}
+	z.isOpen = false
+	if z.file == nil {
+		return nil
+	}
+	return z.file.Abort()
 }
 
 // New creates a handler that holds the reference to the zip writer as well as the
 // underlying file, the close method needs to be called at the end of using it
 func New(dest string) (*Handler, error) {
+	return NewSplit(dest, 0)
+}
+
+// NewSplit creates a handler like New, if splitSize is bigger than 0 the zip file is split into
+// volumes of at most splitSize bytes named dest.001, dest.002...
+// The content is written into hidden temp files that are renamed to their final names on Close.
+func NewSplit(dest string, splitSize int64) (*Handler, error) {
 
 	if !strings.HasSuffix(dest, ".zip") {
 		return nil, errors.New("destination does not end in .zip")
 	}
 
-	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
-	// #nosec G304 -- expected, since we are loading files
-	file, err := os.OpenFile(dest, flags, 0600)
+	file, err := Create(dest, splitSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open zip for writing: %s", err)
 	}
 
-	zh := Handler{
-		isOpen:    true,
-		file:      file,
-		zipWriter: zip.NewWriter(file),
+	zh := newHandler(file)
+	zh.file = file
+	return zh, nil
+}
+
+// NewStream creates a handler that writes the zip file into w, e.g. stdout,
+// the close method needs to be called at the end to write the zip central directory
+func NewStream(w io.Writer) *Handler {
+	return newHandler(w)
+}
+
+func newHandler(w io.Writer) *Handler {
+	zh := &Handler{
+		isOpen: true,
+	}
+	zh.zipWriter = zip.NewWriter(&countWriter{w: w, n: &zh.written})
+	zh.SetCompression(Compression{Level: -1, StoreExtensions: DefaultStoreExtensions})
+	return zh
+}
+
+// countWriter adds the bytes written to w to n
+type countWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Flush writes the buffered data to the zip file, so that Stats counts all the bytes of the entries added so far
+func (z *Handler) Flush() error {
+	if !z.isOpen {
+		return nil
 	}
-	return &zh, nil
+	return z.zipWriter.Flush()
+}
+
+// Stats returns the size of the content added to the entries so far and the bytes written to the zip file,
+// it is safe to call while entries are added
+func (z *Handler) Stats() (read int64, written int64) {
+	return z.read.Load(), z.written.Load()
 }
 
 // AddFile writes a file into the current zip file
@@ -62,35 +133,97 @@ func (z *Handler) AddFile(origin string, zipDest string) (err error) {
 		err = errors.Join(err, file.Close())
 	}()
 
-	return z.WriteFile(file, zipDest)
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", origin, err)
+	}
+
+	header, err := localHeader(origin, info, zipDest)
+	if err != nil {
+		return fmt.Errorf("failed to extract header from file: %s", err)
+	}
+	header.Method = z.method(zipDest)
+
+	// sample the first block to skip compressing data that does not shrink
+	sample := make([]byte, sampleSize)
+	n, err := io.ReadFull(file, sample)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read %s: %s", origin, err)
+	}
+	sample = sample[:n]
+	if header.Method == zip.Deflate && incompressible(sample) {
+		header.Method = zip.Store
+	}
+
+	wr, err := z.zipWriter.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to create entry for %s in zip file: %s", zipDest, err)
+	}
+	wr = &countWriter{w: wr, n: &z.read}
+	if _, err := wr.Write(sample); err != nil {
+		return fmt.Errorf("failed to write from reader to zip: %s", err)
+	}
+	if _, err := io.Copy(wr, file); err != nil {
+		return fmt.Errorf("failed to write from reader to zip: %s", err)
+	}
+	return nil
 }
 
-// AddSymlink writes a symlink into a zip file
-func (z *Handler) AddSymlink(origin string, zipDest string) (err error) {
-	// #nosec G304 -- expected, since we are loading files
-	file, err := os.Open(origin)
+// AddDir writes a directory entry into the zip file, so that empty directories and their mode are kept
+func (z *Handler) AddDir(origin string, zipDest string) error {
+	info, err := os.Lstat(origin)
 	if err != nil {
-		return fmt.Errorf("failed to open %s: %s", origin, err)
+		return fmt.Errorf("failed to stat dir: %s", err)
 	}
-	defer func() {
-		err = errors.Join(err, file.Close())
-	}()
+	header, err := localHeader(origin, info, zipDest)
+	if err != nil {
+		return fmt.Errorf("failed to extract header from dir: %s", err)
+	}
+	return z.createEntry(header)
+}
 
-	info, err := os.Lstat(file.Name())
+// AddDirInfo writes a directory entry with the mode, modification time and owner of info into the zip file
+func (z *Handler) AddDirInfo(info os.FileInfo, zipDest string) error {
+	header, err := fileHeader(info, zipDest)
 	if err != nil {
-		return fmt.Errorf("failed to stat link: %s", err)
+		return fmt.Errorf("failed to extract header from dir: %s", err)
 	}
+	return z.createEntry(header)
+}
 
-	header, err := zip.FileInfoHeader(info)
+// AddHardlink writes an entry for origin that points to target, a file already added to the zip file
+// with the same inode, so that the content is only stored once
+func (z *Handler) AddHardlink(origin string, target string, zipDest string) error {
+	info, err := os.Lstat(origin)
 	if err != nil {
-		return fmt.Errorf("failed to extract header from link: %s", err)
+		return fmt.Errorf("failed to stat hard link: %s", err)
 	}
-	header.Method = zip.Deflate
-	header.Name = zipDest
+	header, err := fileHeader(info, zipDest)
+	if err != nil {
+		return fmt.Errorf("failed to extract header from hard link: %s", err)
+	}
+	header.Method = zip.Store
+	header.Extra = append(header.Extra, extraField(extraHardlink, []byte(target))...)
+	return z.createEntry(header)
+}
 
-	writer, err := z.zipWriter.CreateHeader(header)
+// createEntry writes an entry without content
+func (z *Handler) createEntry(header *zip.FileHeader) error {
+	if !z.isOpen {
+		return errors.New("zip handler is closed")
+	}
+	_, err := z.zipWriter.CreateHeader(header)
 	if err != nil {
-		return fmt.Errorf("failed to create header from link: %s", err)
+		return fmt.Errorf("failed to create entry for %s in zip file: %s", header.Name, err)
+	}
+	return nil
+}
+
+// AddSymlink writes a symlink into a zip file
+func (z *Handler) AddSymlink(origin string, zipDest string) error {
+	info, err := os.Lstat(origin)
+	if err != nil {
+		return fmt.Errorf("failed to stat link: %s", err)
 	}
 
 	linkVal, err := os.Readlink(origin)
@@ -98,8 +231,34 @@ func (z *Handler) AddSymlink(origin string, zipDest string) (err error) {
 		return fmt.Errorf("failed to read target from link: %s", err)
 	}
 
+	header, err := localHeader(origin, info, zipDest)
+	if err != nil {
+		return fmt.Errorf("failed to extract header from link: %s", err)
+	}
+	return z.writeSymlink(header, linkVal)
+}
+
+// WriteSymlink writes a symlink pointing to target with the metadata of info into a zip file
+func (z *Handler) WriteSymlink(info os.FileInfo, target string, zipDest string) error {
+	header, err := fileHeader(info, zipDest)
+	if err != nil {
+		return fmt.Errorf("failed to extract header from link: %s", err)
+	}
+	return z.writeSymlink(header, target)
+}
+
+func (z *Handler) writeSymlink(header *zip.FileHeader, target string) error {
+	if !z.isOpen {
+		return errors.New("zip handler is closed")
+	}
+
+	writer, err := z.zipWriter.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to create header from link: %s", err)
+	}
+
 	// Write symlink's target to writer - file's body for symlinks is the symlink target.
-	_, err = writer.Write([]byte(linkVal))
+	_, err = writer.Write([]byte(target))
 	if err != nil {
 		return fmt.Errorf("failed to write link into zip file: %s", err)
 	}
@@ -127,24 +286,80 @@ func (z *Handler) FileWriter(dest string) (io.Writer, error) {
 		return nil, errors.New("zip handler is closed")
 	}
 
-	wr, err := z.zipWriter.Create(dest)
+	header := &zip.FileHeader{
+		Name:     dest,
+		Method:   z.method(dest),
+		Modified: time.Now(),
+	}
+	header.SetMode(0600)
+
+	wr, err := z.zipWriter.CreateHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create entry for %s in zip file: %s", dest, err)
+	}
+	return &countWriter{w: wr, n: &z.read}, nil
+}
+
+// FileWriterInfo returns an io.writer used to write to the file within the zip file defined with dest,
+// the entry keeps the mode, modification time and owner of info
+func (z *Handler) FileWriterInfo(info os.FileInfo, dest string) (io.Writer, error) {
+	if !z.isOpen {
+		return nil, errors.New("zip handler is closed")
+	}
+
+	header, err := fileHeader(info, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract header from file: %s", err)
+	}
+	header.Method = z.method(dest)
+	wr, err := z.zipWriter.CreateHeader(header)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create entry for %s in zip file: %s", dest, err)
 	}
-	return wr, nil
+	return &countWriter{w: wr, n: &z.read}, nil
 }
 
+// ListFiles returns the names of the files in a zip file, directory entries are skipped;
+// the volumes of a split zip file are read as one
 func ListFiles(in string) (files []string, err error) {
-	read, err := zip.OpenReader(in)
+	read, closer, err := openReader(in)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open: %s", err)
 	}
 	defer func() {
-		err = errors.Join(err, read.Close())
+		err = errors.Join(err, closer.Close())
 	}()
 
 	for _, file := range read.File {
+		if file.Mode().IsDir() {
+			continue
+		}
 		files = append(files, file.Name)
 	}
 	return files, nil
 }
+
+// Verify reads the content of every entry in a zip file to check it against its checksum,
+// the volumes of a split zip file are read as one
+func Verify(in string) (err error) {
+	read, closer, err := openReader(in)
+	if err != nil {
+		return fmt.Errorf("failed to open: %s", err)
+	}
+	defer func() {
+		err = errors.Join(err, closer.Close())
+	}()
+
+	for _, file := range read.File {
+		rc, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s in zip file: %s", file.Name, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		err = errors.Join(err, rc.Close())
+		if err != nil {
+			return fmt.Errorf("failed to verify %s: %s", file.Name, err)
+		}
+	}
+	return nil
+}
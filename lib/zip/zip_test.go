@@ -1,8 +1,14 @@
 package zip
 
 import (
+	"archive/zip"
+	"crypto/rand"
 	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewZipWriter(t *testing.T) {
@@ -64,3 +70,396 @@ func TestAddFileToZip(t *testing.T) {
 		}
 	})
 }
+
+func TestExtractKeepsMetadata(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	mustNil := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustNil(os.MkdirAll(filepath.Join(src, "empty"), 0750))
+	mustNil(os.WriteFile(filepath.Join(src, "script.sh"), []byte("#!/bin/sh\n"), 0600))
+	mustNil(os.Chmod(filepath.Join(src, "script.sh"), 0754))
+	mustNil(os.Chtimes(filepath.Join(src, "script.sh"), mtime, mtime))
+	mustNil(os.Symlink("script.sh", filepath.Join(src, "link")))
+	mustNil(os.Chmod(filepath.Join(src, "empty"), 0705))
+	mustNil(os.Chtimes(filepath.Join(src, "empty"), mtime, mtime))
+
+	zipFile := filepath.Join(t.TempDir(), "meta.zip")
+	zh, err := New(zipFile)
+	mustNil(err)
+	mustNil(zh.AddDir(src, "src"))
+	mustNil(zh.AddDir(filepath.Join(src, "empty"), "src/empty"))
+	mustNil(zh.AddFile(filepath.Join(src, "script.sh"), "src/script.sh"))
+	mustNil(zh.AddSymlink(filepath.Join(src, "link"), "src/link"))
+	zh.Close()
+
+	dest := t.TempDir()
+	mustNil(Extract(zipFile, dest))
+
+	tcs := []struct {
+		path  string
+		mode  os.FileMode
+		mtime bool
+	}{
+		{path: "src/empty", mode: os.ModeDir | 0705, mtime: true},
+		{path: "src/script.sh", mode: 0754, mtime: true},
+		{path: "src/link", mode: os.ModeSymlink | 0777},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.path, func(t *testing.T) {
+			info, err := os.Lstat(filepath.Join(dest, tc.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode() != tc.mode {
+				t.Errorf("got mode %v, want %v", info.Mode(), tc.mode)
+			}
+			if tc.mtime && !info.ModTime().Equal(mtime) {
+				t.Errorf("got mtime %v, want %v", info.ModTime(), mtime)
+			}
+		})
+	}
+
+	link, err := os.Readlink(filepath.Join(dest, "src/link"))
+	mustNil(err)
+	if link != "script.sh" {
+		t.Errorf("got link target %s, want script.sh", link)
+	}
+}
+
+func TestExtractRejectsUnsafePaths(t *testing.T) {
+	zipFile := filepath.Join(t.TempDir(), "unsafe.zip")
+	zh, err := New(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zh.WriteFile(strings.NewReader("bad"), "../escape.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zh.Close()
+
+	err = Extract(zipFile, t.TempDir())
+	want := "unsafe path in zip file: ../escape.txt"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
+
+func TestExtractRejectsSymlinkedPaths(t *testing.T) {
+	tcs := []struct {
+		name string
+		// write adds the entries to the archive, outside is a dir that must stay untouched
+		write func(t *testing.T, zh *Handler, outside string)
+	}{
+		{
+			name: "file within a symlinked dir",
+			write: func(t *testing.T, zh *Handler, outside string) {
+				addSymlink(t, zh, outside, "etc")
+				if err := zh.WriteFile(strings.NewReader("bad"), "etc/shadow"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "file replacing a symlink",
+			write: func(t *testing.T, zh *Handler, outside string) {
+				addSymlink(t, zh, filepath.Join(outside, "shadow"), "shadow")
+				if err := zh.WriteFile(strings.NewReader("bad"), "shadow"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "dir replacing a symlink",
+			write: func(t *testing.T, zh *Handler, outside string) {
+				addSymlink(t, zh, outside, "etc")
+				info, err := os.Stat(outside)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = zh.AddDirInfo(info, "etc"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			outside := t.TempDir()
+			if err := os.Chmod(outside, 0700); err != nil {
+				t.Fatal(err)
+			}
+			zipFile := filepath.Join(t.TempDir(), "malicious.zip")
+			zh, err := New(zipFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.write(t, zh, outside)
+			zh.Close()
+
+			err = Extract(zipFile, t.TempDir())
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			entries, err := os.ReadDir(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("expected nothing written outside of dest, got %d entries", len(entries))
+			}
+			info, err := os.Stat(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0700 {
+				t.Errorf("expected the mode outside of dest to be kept, got %v", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+// addSymlink adds a symlink named zipDest pointing to target
+func addSymlink(t *testing.T, zh *Handler, target, zipDest string) {
+	t.Helper()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := zh.AddSymlink(link, zipDest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOwnerExtra(t *testing.T) {
+	extra := append([]byte{0x55, 0x54, 0x01, 0x00, 0x00}, ownerExtra(1001, 33)...)
+	uid, gid, ok := parseOwnerExtra(extra)
+	if !ok {
+		t.Fatal("owner extra field not found")
+	}
+	if uid != 1001 || gid != 33 {
+		t.Errorf("got uid %d gid %d, want 1001 33", uid, gid)
+	}
+}
+
+func TestExtractHardlinks(t *testing.T) {
+	src := t.TempDir()
+	err := os.WriteFile(filepath.Join(src, "a"), []byte("shared content"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Link(filepath.Join(src, "a"), filepath.Join(src, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zipFile := filepath.Join(t.TempDir(), "links.zip")
+	zh, err := New(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = zh.AddFile(filepath.Join(src, "a"), "dir/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = zh.AddHardlink(filepath.Join(src, "b"), "dir/a", "dir/b"); err != nil {
+		t.Fatal(err)
+	}
+	zh.Close()
+
+	dest := t.TempDir()
+	err = Extract(zipFile, dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a, err := os.Stat(filepath.Join(dest, "dir/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(dest, "dir/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("expected dir/a and dir/b to be the same file")
+	}
+	content, err := os.ReadFile(filepath.Join(dest, "dir/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "shared content" {
+		t.Errorf("got content %q, want %q", content, "shared content")
+	}
+}
+
+func TestExtractRejectsHardlinksToSymlinks(t *testing.T) {
+	tcs := []struct {
+		name string
+		// write adds the entries to the archive, the hard link "copy" must not read secret
+		write func(t *testing.T, zh *Handler, secret string)
+	}{
+		{
+			name: "link to a symlink",
+			write: func(t *testing.T, zh *Handler, secret string) {
+				addSymlink(t, zh, secret, "secret")
+			},
+		},
+		{
+			name: "link to a file replaced by a symlink",
+			write: func(t *testing.T, zh *Handler, secret string) {
+				if err := zh.WriteFile(strings.NewReader("good"), "secret"); err != nil {
+					t.Fatal(err)
+				}
+				addSymlink(t, zh, secret, "secret")
+			},
+		},
+		{
+			name:  "link to a file not in the archive",
+			write: func(t *testing.T, zh *Handler, secret string) {},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			secret := filepath.Join(t.TempDir(), "secret")
+			if err := os.WriteFile(secret, []byte("secret content"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			zipFile := filepath.Join(t.TempDir(), "malicious.zip")
+			zh, err := New(zipFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.write(t, zh, secret)
+			if err = zh.AddHardlink(secret, "secret", "copy"); err != nil {
+				t.Fatal(err)
+			}
+			zh.Close()
+
+			dest := t.TempDir()
+			err = Extract(zipFile, dest)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if _, err = os.Lstat(filepath.Join(dest, "copy")); !os.IsNotExist(err) {
+				t.Errorf("expected the hard link not to be extracted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCompressionMethod(t *testing.T) {
+	src := t.TempDir()
+	text := []byte(strings.Repeat("some compressible log line\n", 1000))
+	random := make([]byte, 32*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"file.log":   text,
+		"photo.JPG":  text,
+		"random.bin": random,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tcs := []struct {
+		name        string
+		compression *Compression
+		want        map[string]uint16
+	}{
+		{
+			name: "default settings",
+			want: map[string]uint16{"file.log": zip.Deflate, "photo.JPG": zip.Store, "random.bin": zip.Store},
+		},
+		{
+			name:        "level 0 stores all files",
+			compression: &Compression{Level: 0, StoreExtensions: DefaultStoreExtensions},
+			want:        map[string]uint16{"file.log": zip.Store, "photo.JPG": zip.Store, "random.bin": zip.Store},
+		},
+		{
+			name:        "custom store extensions",
+			compression: &Compression{Level: 9, StoreExtensions: []string{".log"}},
+			want:        map[string]uint16{"file.log": zip.Store, "photo.JPG": zip.Deflate, "random.bin": zip.Store},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			zipFile := filepath.Join(t.TempDir(), "out.zip")
+			zh, err := New(zipFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.compression != nil {
+				zh.SetCompression(*tc.compression)
+			}
+			for name := range files {
+				if err = zh.AddFile(filepath.Join(src, name), name); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			zh.Close()
+
+			read, err := zip.OpenReader(zipFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer read.Close()
+
+			got := map[string]uint16{}
+			for _, f := range read.File {
+				got[f.Name] = f.Method
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = rc.Close()
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	var buf strings.Builder
+	zh := NewStream(&buf)
+	content := strings.Repeat("some compressible log line\n", 1000)
+
+	err := zh.WriteFile(strings.NewReader(content), "file.log")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = zh.AddFile("sampledata/files/dir1/file.json", "file.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = zh.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat("sampledata/files/dir1/file.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, written := zh.Stats()
+	if diff := cmp.Diff(int64(len(content))+info.Size(), read); diff != "" {
+		t.Errorf("read mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(int64(buf.Len()), written); diff != "" {
+		t.Errorf("written mismatch (-want +got):\n%s", diff)
+	}
+}